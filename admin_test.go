@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 重置密码后用户原有的会话和访问令牌都应失效
+func TestResetPasswordRevokesSessionsAndTokens(t *testing.T) {
+	setupTestStores(t)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+
+	if _, err := userStore.createSession(user, "test", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	_, plain, err := userStore.CreateToken(user.ID, "ci", TOKEN_SCOPE_READ, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := userStore.ResetPassword(user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if sessions := userStore.ListSessions(user.ID); len(sessions) != 0 {
+		t.Errorf("%d sessions left after password reset", len(sessions))
+	}
+	if tokens := userStore.ListTokens(user.ID); len(tokens) != 0 {
+		t.Errorf("%d tokens left after password reset", len(tokens))
+	}
+	if _, err := userStore.AuthenticateToken(plain); err == nil {
+		t.Error("token still authenticates after password reset")
+	}
+}
+
+// 管理员关闭两步验证后用户原有的会话应失效
+func TestResetTwoFactorRevokesSessions(t *testing.T) {
+	setupTestStores(t)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+
+	if _, err := userStore.createSession(user, "test", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := userStore.ResetTwoFactor(user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if sessions := userStore.ListSessions(user.ID); len(sessions) != 0 {
+		t.Errorf("%d sessions left after resetting two-factor authentication", len(sessions))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 审计日志的默认文件名，位于数据目录下
+const AUDIT_FILE = "audit.log"
+
+// 审计事件类型
+const (
+	AUDIT_LOGIN_LOCKED     = "login_locked"     // 连续登录失败，账号被临时锁定
+	AUDIT_RATE_LIMITED     = "rate_limited"     // 登录或注册请求超过频率限制
+	AUDIT_DEFAULT_PASSWORD = "default_password" // 默认管理员仍在使用默认密码
+	AUDIT_PASSWORD_CHANGED = "password_changed" // 用户修改了密码
+	AUDIT_PASSWORD_RESET   = "password_reset"   // 管理员重置了用户密码
+	AUDIT_TWO_FACTOR_RESET = "two_factor_reset" // 管理员关闭了用户的两步验证
+	AUDIT_OIDC_PROVISIONED = "oidc_provisioned" // 单点登录时自动创建了用户
+)
+
+// AuditEvent 是一条审计日志
+type AuditEvent struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Username string    `json:"username,omitempty"`
+	IP       string    `json:"ip,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+// AuditLog 把审计事件以JSON Lines格式追加到文件中
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// 全局审计日志，未打开时只输出到标准日志
+var auditLog = &AuditLog{}
+
+// 打开审计日志文件，不存在时创建
+func openAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{file: file}, nil
+}
+
+// Record 记录一条审计事件
+func (a *AuditLog) Record(event AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	log.Printf("审计: %s 用户=%q IP=%s %s", event.Event, event.Username, event.IP, event.Detail)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("写入审计日志失败: %v", err)
+		return
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Printf("写入审计日志失败: %v", err)
+	}
+}
+
+// Close 关闭审计日志文件
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
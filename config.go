@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// 服务器配置按优先级从高到低来自：命令行参数、环境变量、配置文件、默认值。
+// 每个配置项对应一个命令行参数，环境变量名和配置文件中的键都由参数名派生：
+// 参数 -session-ttl 对应环境变量 TODOLIST_SESSION_TTL，配置文件中的 session-ttl 或 session_ttl；
+// 配置文件中也可以按前缀分组，如 cookie: {secure: true} 等价于 cookie-secure: true
+const (
+	CONFIG_ENV_PREFIX = "TODOLIST_"
+	CONFIG_ENV_FILE   = "TODOLIST_CONFIG" // 配置文件路径
+	DEFAULT_ADDR      = ":8080"
+	DEFAULT_DATA_DIR  = "data"
+)
+
+// CookieConfig 是所有Cookie共用的属性
+type CookieConfig struct {
+	Secure   bool   // 只通过HTTPS发送
+	Domain   string // 为空表示只发送给当前主机
+	SameSite http.SameSite
+}
+
+// TLSConfig 是HTTPS配置，没有设置证书也没有启用自签名证书时使用HTTP
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	SelfSigned   bool          // 在数据目录下生成自签名证书，只用于开发
+	RedirectAddr string        // 把HTTP请求重定向到HTTPS的监听地址，为空表示不监听
+	HSTSMaxAge   time.Duration // Strict-Transport-Security的有效期，0表示不发送
+}
+
+// Enabled 判断是否使用HTTPS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.SelfSigned
+}
+
+// FeatureConfig 是可以关闭的功能
+type FeatureConfig struct {
+	Registration bool // 允许新用户注册
+	Blogs        bool // 博客
+}
+
+// Config 是服务器的全部配置
+type Config struct {
+	Addr          string
+	DataDir       string
+	Storage       string
+	SQLitePath    string // 为空时使用数据目录下的todolist.db
+	AuditLog      string // 为空时使用数据目录下的audit.log
+	FlushInterval time.Duration
+	SessionTTL    time.Duration
+
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration // 关闭时等待正在处理的请求完成的时限
+
+	Cookie   CookieConfig
+	TLS      TLSConfig
+	Features FeatureConfig
+	Password PasswordPolicy
+	Login    LoginLimits
+	OIDC     OIDCConfig
+}
+
+// Cookie属性和功能开关，启动时按配置设置
+var (
+	cookieConfig = CookieConfig{SameSite: http.SameSiteLaxMode}
+	features     = FeatureConfig{Registration: true, Blogs: true}
+)
+
+// 默认配置
+func defaultConfig() Config {
+	return Config{
+		Addr:          DEFAULT_ADDR,
+		DataDir:       DEFAULT_DATA_DIR,
+		Storage:       STORAGE_JSON,
+		FlushInterval: DEFAULT_FLUSH_INTERVAL,
+		SessionTTL:    DEFAULT_SESSION_TTL,
+
+		ReadTimeout:     DEFAULT_READ_TIMEOUT,
+		WriteTimeout:    DEFAULT_WRITE_TIMEOUT,
+		IdleTimeout:     DEFAULT_IDLE_TIMEOUT,
+		ShutdownTimeout: DEFAULT_SHUTDOWN_TIMEOUT,
+
+		Cookie:   cookieConfig,
+		TLS:      TLSConfig{HSTSMaxAge: DEFAULT_HSTS_MAX_AGE},
+		Features: features,
+		Password: passwordPolicy,
+		Login:    loginLimits,
+		OIDC:     defaultOIDCConfig,
+	}
+}
+
+// 把配置项注册为命令行参数，参数的默认值取自c中的当前值
+func registerConfigFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.Addr, "addr", c.Addr, "监听地址")
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "数据目录")
+	fs.StringVar(&c.Storage, "storage", c.Storage, "存储后端: json 或 sqlite")
+	fs.StringVar(&c.SQLitePath, "sqlite-path", c.SQLitePath, "SQLite数据库文件路径，默认为数据目录下的"+SQLITE_FILE)
+	fs.StringVar(&c.AuditLog, "audit-log", c.AuditLog, "审计日志文件路径，默认为数据目录下的"+AUDIT_FILE)
+	fs.DurationVar(&c.FlushInterval, "flush-interval", c.FlushInterval, "合并写入数据的间隔，0表示每次修改后立即写入")
+	fs.DurationVar(&c.SessionTTL, "session-ttl", c.SessionTTL, "会话在最后一次活动后的有效期")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "读取整个请求的时限，0表示不限制")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "写完响应的时限，0表示不限制")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "keep-alive连接的空闲时限，0表示使用read-timeout")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "关闭时等待正在处理的请求完成的时限")
+
+	fs.BoolVar(&c.Cookie.Secure, "cookie-secure", c.Cookie.Secure, "Cookie只通过HTTPS发送")
+	fs.StringVar(&c.Cookie.Domain, "cookie-domain", c.Cookie.Domain, "Cookie的Domain属性，为空表示只发送给当前主机")
+	fs.Var((*sameSiteValue)(&c.Cookie.SameSite), "cookie-samesite", "Cookie的SameSite属性: lax、strict 或 none")
+	fs.StringVar(&c.TLS.CertFile, "tls-cert", c.TLS.CertFile, "HTTPS证书文件")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key", c.TLS.KeyFile, "HTTPS私钥文件")
+	fs.BoolVar(&c.TLS.SelfSigned, "tls-self-signed", c.TLS.SelfSigned, "没有证书时在数据目录下生成自签名证书，只用于开发")
+	fs.StringVar(&c.TLS.RedirectAddr, "tls-redirect-addr", c.TLS.RedirectAddr, "把HTTP请求重定向到HTTPS的监听地址，如 :80，为空表示不监听")
+	fs.DurationVar(&c.TLS.HSTSMaxAge, "tls-hsts-max-age", c.TLS.HSTSMaxAge, "HTTPS响应中Strict-Transport-Security的有效期，0表示不发送")
+	fs.BoolVar(&c.Features.Registration, "features-registration", c.Features.Registration, "允许新用户注册")
+	fs.BoolVar(&c.Features.Blogs, "features-blogs", c.Features.Blogs, "启用博客")
+
+	fs.IntVar(&c.Password.MinLength, "password-min-length", c.Password.MinLength, "注册时密码的最小长度")
+	fs.IntVar(&c.Password.MaxLength, "password-max-length", c.Password.MaxLength, "注册时密码的最大长度，0表示不限制")
+	fs.BoolVar(&c.Password.RequireLetter, "password-require-letter", c.Password.RequireLetter, "注册时密码必须包含字母")
+	fs.BoolVar(&c.Password.RequireDigit, "password-require-digit", c.Password.RequireDigit, "注册时密码必须包含数字")
+	fs.BoolVar(&c.Password.RequireSymbol, "password-require-symbol", c.Password.RequireSymbol, "注册时密码必须包含特殊字符")
+
+	fs.IntVar(&c.Login.MaxPerIP, "login-max-per-ip", c.Login.MaxPerIP, "每个IP每分钟最多尝试登录的次数，0表示不限制")
+	fs.IntVar(&c.Login.MaxPerUsername, "login-max-per-user", c.Login.MaxPerUsername, "每个用户名每分钟最多被尝试登录的次数，0表示不限制")
+	fs.IntVar(&c.Login.MaxRegisterPerIP, "register-max-per-ip", c.Login.MaxRegisterPerIP, "每个IP每小时最多注册的次数，0表示不限制")
+	fs.IntVar(&c.Login.LockoutThreshold, "lockout-threshold", c.Login.LockoutThreshold, "连续登录失败多少次后锁定账号，0表示不锁定")
+	fs.DurationVar(&c.Login.LockoutDuration, "lockout-duration", c.Login.LockoutDuration, "账号锁定时长")
+
+	fs.StringVar(&c.OIDC.Issuer, "oidc-issuer", c.OIDC.Issuer, "OIDC身份提供方的地址，为空表示不启用单点登录")
+	fs.StringVar(&c.OIDC.ClientID, "oidc-client-id", c.OIDC.ClientID, "OIDC客户端ID")
+	fs.StringVar(&c.OIDC.ClientSecret, "oidc-client-secret", c.OIDC.ClientSecret, "OIDC客户端密钥，公共客户端可以为空")
+	fs.StringVar(&c.OIDC.RedirectURL, "oidc-redirect-url", c.OIDC.RedirectURL, "OIDC回调地址，如 http://localhost:8080/auth/oidc/callback")
+	fs.StringVar(&c.OIDC.Scopes, "oidc-scopes", c.OIDC.Scopes, "OIDC请求的scope，空格分隔")
+	fs.StringVar(&c.OIDC.GroupsClaim, "oidc-groups-claim", c.OIDC.GroupsClaim, "ID令牌中表示用户组的声明")
+	fs.Var((*roleMapValue)(&c.OIDC.GroupRoles), "oidc-group-roles", "用户组到角色的映射，如 admins=admin,staff=member")
+	fs.StringVar(&c.OIDC.DefaultRole, "oidc-default-role", c.OIDC.DefaultRole, "没有匹配任何用户组时的角色")
+}
+
+// loadConfig 解析命令行参数，按优先级合并配置文件和环境变量，返回校验后的配置，
+// 以及是否指定了-print-config
+func loadConfig(args []string) (Config, bool, error) {
+	// 第一遍只解析命令行参数，得到配置文件路径和显式指定的参数
+	cmdline := defaultConfig()
+	cmdFlags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	registerConfigFlags(cmdFlags, &cmdline)
+	configPath := cmdFlags.String("config", os.Getenv(CONFIG_ENV_FILE), "配置文件路径（.yaml、.yml、.toml 或 .json），也可以通过"+CONFIG_ENV_FILE+"指定")
+	printConfig := cmdFlags.Bool("print-config", false, "打印合并后的配置并退出")
+	cmdFlags.Parse(args)
+
+	// 第二遍按优先级从低到高依次应用配置文件、环境变量和命令行参数
+	config := defaultConfig()
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	registerConfigFlags(fs, &config)
+
+	if *configPath != "" {
+		if err := applyConfigFile(fs, *configPath); err != nil {
+			return config, false, err
+		}
+	}
+	if err := applyConfigEnv(fs); err != nil {
+		return config, false, err
+	}
+	var err error
+	cmdFlags.Visit(func(f *flag.Flag) {
+		if err == nil && fs.Lookup(f.Name) != nil {
+			err = fs.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return config, false, err
+	}
+
+	// 收到HSTS后浏览器不再允许跳过证书警告，并在有效期内记住该主机，
+	// 自签名证书不受信任时就无法打开页面，所以默认不发送HSTS，除非显式指定了有效期
+	hstsSet := false
+	fs.Visit(func(f *flag.Flag) { hstsSet = hstsSet || f.Name == "tls-hsts-max-age" })
+	if config.TLS.SelfSigned && !hstsSet {
+		config.TLS.HSTSMaxAge = 0
+	}
+
+	config.resolve()
+	return config, *printConfig, config.Validate()
+}
+
+// 读取配置文件，按扩展名选择格式
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("%s: unsupported config format, use .yaml, .toml or .json", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := applyConfigValues(fs, "", values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// 把配置文件中的键值设置到对应的参数，嵌套的表按前缀展开
+func applyConfigValues(fs *flag.FlagSet, prefix string, values map[string]interface{}) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := prefix + strings.ReplaceAll(strings.ToLower(key), "_", "-")
+		value := values[key]
+
+		if fs.Lookup(name) == nil {
+			nested, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("unknown config key %q", name)
+			}
+			if err := applyConfigValues(fs, name+"-", nested); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var s string
+		switch v := value.(type) {
+		case map[string]interface{}:
+			// 映射类型的配置项，如oidc-group-roles
+			pairs := make([]string, 0, len(v))
+			for k, item := range v {
+				pairs = append(pairs, fmt.Sprintf("%s=%v", k, item))
+			}
+			sort.Strings(pairs)
+			s = strings.Join(pairs, ",")
+		case []interface{}:
+			return fmt.Errorf("%s: lists are not supported", name)
+		default:
+			s = fmt.Sprint(v)
+		}
+		if err := fs.Set(name, s); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// 读取TODOLIST_*环境变量
+func applyConfigEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		env := CONFIG_ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(env); ok && err == nil {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("%s: %w", env, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// 把未指定的文件路径解析为数据目录下的默认文件
+func (c *Config) resolve() {
+	if c.SQLitePath == "" {
+		c.SQLitePath = filepath.Join(c.DataDir, SQLITE_FILE)
+	}
+	if c.AuditLog == "" {
+		c.AuditLog = filepath.Join(c.DataDir, AUDIT_FILE)
+	}
+}
+
+// Validate 检查配置是否有效，返回所有问题
+func (c Config) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("addr: %w", err))
+	}
+	if c.DataDir == "" {
+		errs = append(errs, errors.New("data-dir: must not be empty"))
+	}
+	if c.Storage != STORAGE_JSON && c.Storage != STORAGE_SQLITE {
+		errs = append(errs, fmt.Errorf("storage: unknown storage %q", c.Storage))
+	}
+	if c.FlushInterval < 0 {
+		errs = append(errs, errors.New("flush-interval: must not be negative"))
+	}
+	if c.SessionTTL <= 0 {
+		errs = append(errs, errors.New("session-ttl: must be positive"))
+	}
+	if c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 {
+		errs = append(errs, errors.New("read-timeout, write-timeout and idle-timeout must not be negative"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown-timeout: must be positive"))
+	}
+
+	if c.Cookie.SameSite == http.SameSiteNoneMode && !c.Cookie.Secure && !c.TLS.Enabled() {
+		errs = append(errs, errors.New("cookie-samesite: none requires cookie-secure or TLS"))
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls-cert and tls-key must be set together"))
+	}
+	if c.TLS.SelfSigned && c.TLS.CertFile != "" {
+		errs = append(errs, errors.New("tls-self-signed cannot be combined with tls-cert"))
+	}
+	if c.TLS.RedirectAddr != "" {
+		if !c.TLS.Enabled() {
+			errs = append(errs, errors.New("tls-redirect-addr requires tls-cert or tls-self-signed"))
+		} else if _, _, err := net.SplitHostPort(c.TLS.RedirectAddr); err != nil {
+			errs = append(errs, fmt.Errorf("tls-redirect-addr: %w", err))
+		}
+	}
+	if c.TLS.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("tls-hsts-max-age: must not be negative"))
+	}
+
+	if c.Password.MinLength < 1 {
+		errs = append(errs, errors.New("password-min-length: must be at least 1"))
+	}
+	if c.Password.MaxLength != 0 && c.Password.MaxLength < c.Password.MinLength {
+		errs = append(errs, errors.New("password-max-length: must not be less than password-min-length"))
+	}
+
+	if c.Login.MaxPerIP < 0 || c.Login.MaxPerUsername < 0 || c.Login.MaxRegisterPerIP < 0 || c.Login.LockoutThreshold < 0 {
+		errs = append(errs, errors.New("login limits must not be negative"))
+	}
+	if c.Login.LockoutThreshold > 0 && c.Login.LockoutDuration <= 0 {
+		errs = append(errs, errors.New("lockout-duration: must be positive when lockout-threshold is set"))
+	}
+
+	if err := c.OIDC.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// 以YAML格式输出配置，可以直接用作配置文件。密钥不会输出
+func writeConfig(w io.Writer, c Config) error {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	registerConfigFlags(fs, &c)
+
+	values := make(map[string]interface{})
+	fs.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.(flag.Getter).Get()
+	})
+	if c.OIDC.ClientSecret != "" {
+		values["oidc-client-secret"] = "******"
+	}
+	return yaml.NewEncoder(w).Encode(values)
+}
+
+// 按配置设置Cookie的Secure、Domain和SameSite属性
+func (c CookieConfig) apply(cookie *http.Cookie) *http.Cookie {
+	cookie.Secure = c.Secure
+	cookie.Domain = c.Domain
+	cookie.SameSite = c.SameSite
+	return cookie
+}
+
+// 中间件：功能被关闭时返回404
+func requireFeature(enabled bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !enabled {
+			writeProblem(w, r, http.StatusNotFound, "页面不存在")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// sameSiteValue 是SameSite属性的命令行参数
+type sameSiteValue http.SameSite
+
+var sameSiteNames = map[http.SameSite]string{
+	http.SameSiteLaxMode:    "lax",
+	http.SameSiteStrictMode: "strict",
+	http.SameSiteNoneMode:   "none",
+}
+
+func (v *sameSiteValue) String() string {
+	return sameSiteNames[http.SameSite(*v)]
+}
+
+func (v *sameSiteValue) Set(s string) error {
+	for mode, name := range sameSiteNames {
+		if strings.EqualFold(s, name) {
+			*v = sameSiteValue(mode)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid SameSite mode %q", s)
+}
+
+func (v *sameSiteValue) Get() interface{} {
+	return v.String()
+}
+
+// roleMapValue 是用户组到角色映射的命令行参数，格式为 group=role,group=role
+type roleMapValue map[string]string
+
+func (v *roleMapValue) String() string {
+	pairs := make([]string, 0, len(*v))
+	for group, role := range *v {
+		pairs = append(pairs, group+"="+role)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v *roleMapValue) Set(s string) error {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		group, role, found := strings.Cut(pair, "=")
+		if !found {
+			return fmt.Errorf("invalid mapping %q, expected group=role", pair)
+		}
+		m[strings.TrimSpace(group)] = strings.TrimSpace(role)
+	}
+	*v = m
+	return nil
+}
+
+func (v *roleMapValue) Get() interface{} {
+	return map[string]string(*v)
+}
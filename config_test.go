@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 在临时目录中写入配置文件，返回其路径
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 命令行参数优先于环境变量，环境变量优先于配置文件，配置文件优先于默认值
+func TestConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+addr: ":7001"
+data-dir: /srv/file
+session_ttl: 2h
+flush-interval: 3s
+`)
+	t.Setenv(CONFIG_ENV_FILE, path)
+	t.Setenv("TODOLIST_ADDR", ":7002")
+	t.Setenv("TODOLIST_DATA_DIR", "/srv/env")
+
+	config, _, err := loadConfig([]string{"-addr", ":7003"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Addr != ":7003" {
+		t.Errorf("addr = %s, want the flag value", config.Addr)
+	}
+	if config.DataDir != "/srv/env" {
+		t.Errorf("data-dir = %s, want the environment value", config.DataDir)
+	}
+	if config.SessionTTL != 2*time.Hour || config.FlushInterval != 3*time.Second {
+		t.Errorf("session-ttl = %v, flush-interval = %v, want the file values", config.SessionTTL, config.FlushInterval)
+	}
+	if config.Storage != STORAGE_JSON {
+		t.Errorf("storage = %s, want the default", config.Storage)
+	}
+	// 未指定的文件路径在数据目录下
+	if config.SQLitePath != filepath.Join("/srv/env", SQLITE_FILE) {
+		t.Errorf("sqlite-path = %s", config.SQLitePath)
+	}
+}
+
+// 三种配置文件格式都可以使用，嵌套的表按前缀展开
+func TestConfigFileFormats(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": "cookie:\n  secure: true\nlogin:\n  max-per-ip: 7\n",
+		"config.toml": "[cookie]\nsecure = true\n[login]\nmax_per_ip = 7\n",
+		"config.json": `{"cookie": {"secure": true}, "login-max-per-ip": 7}`,
+	}
+
+	for name, content := range files {
+		t.Setenv(CONFIG_ENV_FILE, writeConfigFile(t, name, content))
+
+		config, _, err := loadConfig(nil)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !config.Cookie.Secure || config.Login.MaxPerIP != 7 {
+			t.Errorf("%s: cookie-secure = %v, login-max-per-ip = %d", name, config.Cookie.Secure, config.Login.MaxPerIP)
+		}
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     string
+		args    []string
+		message string
+	}{
+		{name: "unknown key", file: "unknown: 1\n", message: `unknown config key "unknown"`},
+		{name: "bad env value", env: "soon", message: "TODOLIST_SESSION_TTL"},
+		{name: "invalid storage", args: []string{"-storage", "mysql"}, message: "storage"},
+		{name: "tls files", args: []string{"-tls-cert", "cert.pem"}, message: "tls-cert and tls-key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.file != "" {
+				t.Setenv(CONFIG_ENV_FILE, writeConfigFile(t, "config.yaml", tt.file))
+			}
+			if tt.env != "" {
+				t.Setenv("TODOLIST_SESSION_TTL", tt.env)
+			}
+
+			_, _, err := loadConfig(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("err = %v, want an error mentioning %q", err, tt.message)
+			}
+		})
+	}
+}
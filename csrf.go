@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+)
+
+// CSRF防护采用双重提交：令牌同时保存在脚本可读的Cookie中，
+// 修改数据的请求必须在请求头或表单字段中带上相同的值。其他站点无法读取Cookie，也就无法伪造请求
+const (
+	CSRF_COOKIE     = "csrf_token"
+	CSRF_HEADER     = "X-CSRF-Token"
+	CSRF_FORM_FIELD = "csrf_token"
+)
+
+// 请求上下文中保存CSRF令牌的键
+type csrfKey struct{}
+
+// 判断请求方法是否只读，只读请求不检查CSRF令牌
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// 中间件：为每个客户端签发CSRF令牌，并检查修改数据的请求是否带有正确的令牌
+func csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if cookie, err := r.Cookie(CSRF_COOKIE); err == nil && cookie.Value != "" {
+			token = cookie.Value
+		}
+
+		// 使用Bearer访问令牌的请求不依赖Cookie，其他站点也无法设置该请求头，不需要检查
+		_, hasBearer := bearerToken(r)
+
+		if !isSafeMethod(r.Method) && !hasBearer {
+			// 表单提交时从表单字段读取令牌，其他请求从请求头读取
+			submitted := r.Header.Get(CSRF_HEADER)
+			if submitted == "" {
+				submitted = r.PostFormValue(CSRF_FORM_FIELD)
+			}
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(submitted)) != 1 {
+				writeProblem(w, r, http.StatusForbidden, "CSRF令牌无效，请刷新页面后重试")
+				return
+			}
+		}
+
+		// 还没有令牌时签发一个新的
+		if token == "" {
+			var err error
+			token, err = generateToken()
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			http.SetCookie(w, cookieConfig.apply(&http.Cookie{
+				Name:  CSRF_COOKIE,
+				Value: token,
+				Path:  "/",
+			}))
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, token)))
+	})
+}
+
+// 获取当前请求的CSRF令牌，用于在模板的表单中输出
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
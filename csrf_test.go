@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 使用Cookie认证的修改请求必须带有与Cookie相同的CSRF令牌，使用Bearer令牌的请求不检查
+func TestCSRFDoubleSubmit(t *testing.T) {
+	var reached bool
+	handler := csrfMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	const token = "csrf-token"
+
+	tests := []struct {
+		name    string
+		method  string
+		cookie  string // csrf_token Cookie，空字符串表示没有
+		header  string // X-CSRF-Token请求头
+		form    string // csrf_token表单字段
+		bearer  bool
+		allowed bool
+	}{
+		{name: "safe method", method: http.MethodGet, allowed: true},
+		{name: "missing token", method: http.MethodPost, cookie: token},
+		{name: "missing cookie", method: http.MethodPost, header: token},
+		{name: "mismatched header", method: http.MethodPost, cookie: token, header: "other"},
+		{name: "mismatched form field", method: http.MethodPost, cookie: token, form: "other"},
+		{name: "matching header", method: http.MethodDelete, cookie: token, header: token, allowed: true},
+		{name: "matching form field", method: http.MethodPost, cookie: token, form: token, allowed: true},
+		{name: "bearer without token", method: http.MethodPost, bearer: true, allowed: true},
+	}
+
+	for _, tt := range tests {
+		var r *http.Request
+		if tt.form != "" {
+			r = httptest.NewRequest(tt.method, "/api/todos", strings.NewReader(CSRF_FORM_FIELD+"="+tt.form))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest(tt.method, "/api/todos", nil)
+		}
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: CSRF_COOKIE, Value: tt.cookie})
+		}
+		if tt.header != "" {
+			r.Header.Set(CSRF_HEADER, tt.header)
+		}
+		if tt.bearer {
+			r.Header.Set("Authorization", "Bearer "+TOKEN_PREFIX+"test")
+		}
+
+		reached = false
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if reached != tt.allowed {
+			t.Errorf("%s: reached handler = %v, want %v (status %d)", tt.name, reached, tt.allowed, w.Code)
+		}
+		if !tt.allowed && w.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want 403", tt.name, w.Code)
+		}
+	}
+}
+
+// 没有CSRF Cookie的客户端会得到一个新的令牌
+func TestCSRFIssuesToken(t *testing.T) {
+	var seen string
+	handler := csrfMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = csrfToken(r)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+
+	var issued *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == CSRF_COOKIE {
+			issued = cookie
+		}
+	}
+	if issued == nil || issued.Value == "" {
+		t.Fatal("no CSRF cookie issued")
+	}
+	if seen != issued.Value {
+		t.Errorf("csrfToken(r) = %q, want the issued cookie value %q", seen, issued.Value)
+	}
+}
@@ -1,25 +1,28 @@
 package main
 
 import (
-	"encoding/json"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"sync"
-	"time"
 )
 
-// 数据文件路径
+// 数据文件名，位于数据目录下
 const (
-	USERS_FILE = "data/users.json"
-	TODOS_FILE = "data/todos.json"
-	BLOGS_FILE = "data/blogs.json"
+	USERS_FILE    = "users.json"
+	SESSIONS_FILE = "sessions.json"
+	TODOS_FILE    = "todos.json"
+	BLOGS_FILE    = "blogs.json"
+	TAGS_FILE     = "tags.json"
+	LISTS_FILE    = "lists.json"
+	TOKENS_FILE   = "tokens.json"
 )
 
+// 所有数据文件，启动时依次读取，写入检查点时依次重写
+var dataFiles = []string{USERS_FILE, SESSIONS_FILE, TODOS_FILE, BLOGS_FILE, TAGS_FILE, LISTS_FILE, TOKENS_FILE}
+
 // 确保数据目录存在
-func ensureDataDir() error {
-	dataDir := filepath.Dir(USERS_FILE)
+func ensureDataDir(dataDir string) error {
 	if _, err := os.Stat(dataDir); os.IsNotExist(err) {
 		err = os.MkdirAll(dataDir, 0755)
 		if err != nil {
@@ -29,161 +32,463 @@ func ensureDataDir() error {
 	return nil
 }
 
-// 保存用户数据到文件
-func (s *UserStore) SaveToFile() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// 各数据文件的结构
+type usersFile struct {
+	Users  []User `json:"users"`
+	NextID int    `json:"next_id"`
+}
 
-	// 确保数据目录存在
-	if err := ensureDataDir(); err != nil {
-		return err
-	}
+type sessionsFile struct {
+	Sessions []Session `json:"sessions"`
+}
 
-	// 创建要保存的数据结构
-	data := struct {
-		Users  []User           `json:"users"`
-		NextID int              `json:"next_id"`
-	}{s.users, s.nextID}
+type todosFile struct {
+	Todos  []Todo `json:"todos"`
+	NextID int    `json:"next_id"`
+}
 
-	// 将数据编码为JSON
-	jsonData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		return err
-	}
+type blogsFile struct {
+	Blogs         []Blog `json:"blogs"`
+	NextID        int    `json:"next_id"`
+	NextCommentID int    `json:"next_comment_id"`
+}
+
+type tagsFile struct {
+	Tags   []Tag `json:"tags"`
+	NextID int   `json:"next_id"`
+}
+
+type listsFile struct {
+	Lists  []TodoList `json:"lists"`
+	NextID int        `json:"next_id"`
+}
+
+type tokensFile struct {
+	Tokens []AccessToken `json:"tokens"`
+	NextID int           `json:"next_id"`
+}
 
-	// 写入文件
-	return ioutil.WriteFile(USERS_FILE, jsonData, 0644)
+// JSONStorage 把数据保存在数据目录下的JSON文件中。
+// 每次修改立即追加到预写日志，被修改的文件只做标记，在Sync时才整体重写
+type JSONStorage struct {
+	mu       sync.Mutex
+	dir      string
+	users    usersFile
+	sessions sessionsFile
+	todos    todosFile
+	blogs    blogsFile
+	tags     tagsFile
+	lists    listsFile
+	tokens   tokensFile
+	journal  *journal
+	dirty    map[string]bool
 }
 
-// 从文件加载用户数据
-func (s *UserStore) LoadFromFile() error {
+// NewJSONStorage 创建JSONStorage，读取已有的数据文件并回放预写日志
+func NewJSONStorage(dir string) (*JSONStorage, error) {
 	// 确保数据目录存在
-	if err := ensureDataDir(); err != nil {
-		return err
+	if err := ensureDataDir(dir); err != nil {
+		return nil, err
 	}
 
-	// 检查文件是否存在
-	if _, err := os.Stat(USERS_FILE); os.IsNotExist(err) {
-		// 文件不存在，使用默认数据
-		return nil
+	s := &JSONStorage{dir: dir, dirty: make(map[string]bool)}
+	for _, path := range dataFiles {
+		if err := readJSONFile(filepath.Join(dir, path), s.file(path)); err != nil {
+			return nil, err
+		}
+	}
+
+	// 旧文件或空文件中可能没有下一个ID
+	if s.users.NextID < 1 {
+		s.users.NextID = 1
+	}
+	if s.todos.NextID < 1 {
+		s.todos.NextID = 1
+	}
+	if s.blogs.NextID < 1 {
+		s.blogs.NextID = 1
+	}
+	if s.blogs.NextCommentID < 1 {
+		s.blogs.NextCommentID = 1
+	}
+	if s.tags.NextID < 1 {
+		s.tags.NextID = 1
+	}
+	if s.lists.NextID < 1 {
+		s.lists.NextID = 1
+	}
+	if s.tokens.NextID < 1 {
+		s.tokens.NextID = 1
 	}
 
-	// 读取文件
-	jsonData, err := ioutil.ReadFile(USERS_FILE)
+	// 回放上次退出前没有写入数据文件的修改
+	j, entries, err := openJournal(filepath.Join(dir, JOURNAL_FILE))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	s.journal = j
+	if len(entries) > 0 {
+		log.Printf("从预写日志恢复%d条修改", len(entries))
+		for _, entry := range entries {
+			s.apply(entry)
+		}
+		if err := s.checkpoint(); err != nil {
+			j.close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
 
-	// 解码JSON数据
-	var data struct {
-		Users  []User `json:"users"`
-		NextID int    `json:"next_id"`
+// 返回数据文件对应的内存数据
+func (s *JSONStorage) file(path string) interface{} {
+	switch path {
+	case USERS_FILE:
+		return &s.users
+	case SESSIONS_FILE:
+		return &s.sessions
+	case TODOS_FILE:
+		return &s.todos
+	case TAGS_FILE:
+		return &s.tags
+	case LISTS_FILE:
+		return &s.lists
+	case TOKENS_FILE:
+		return &s.tokens
+	default:
+		return &s.blogs
 	}
+}
 
-	if err := json.Unmarshal(jsonData, &data); err != nil {
-		return err
+// 把一次修改应用到内存数据，返回需要重写的数据文件
+func (s *JSONStorage) apply(entry journalEntry) string {
+	switch entry.Op {
+	case OP_PUT_USER:
+		user := *entry.User
+		s.users.Users = putByID(s.users.Users, user, func(u User) bool { return u.ID == user.ID })
+		if user.ID >= s.users.NextID {
+			s.users.NextID = user.ID + 1
+		}
+		return USERS_FILE
+
+	case OP_DELETE_USER:
+		s.users.Users = deleteByID(s.users.Users, func(u User) bool { return u.ID == entry.ID })
+		return USERS_FILE
+
+	case OP_PUT_SESSION:
+		session := *entry.Session
+		s.sessions.Sessions = putByID(s.sessions.Sessions, session, func(ss Session) bool { return ss.Token == session.Token })
+		return SESSIONS_FILE
+
+	case OP_DELETE_SESSION:
+		s.sessions.Sessions = deleteByID(s.sessions.Sessions, func(ss Session) bool { return ss.Token == entry.Token })
+		return SESSIONS_FILE
+
+	case OP_PUT_TODO:
+		todo := *entry.Todo
+		s.todos.Todos = putByID(s.todos.Todos, todo, func(t Todo) bool { return t.ID == todo.ID })
+		if todo.ID >= s.todos.NextID {
+			s.todos.NextID = todo.ID + 1
+		}
+		return TODOS_FILE
+
+	case OP_DELETE_TODO:
+		s.todos.Todos = deleteByID(s.todos.Todos, func(t Todo) bool { return t.ID == entry.ID })
+		return TODOS_FILE
+
+	case OP_PUT_BLOG:
+		blog := *entry.Blog
+		blog.Comments = append([]Comment{}, blog.Comments...)
+		s.blogs.Blogs = putByID(s.blogs.Blogs, blog, func(b Blog) bool { return b.ID == blog.ID })
+		if blog.ID >= s.blogs.NextID {
+			s.blogs.NextID = blog.ID + 1
+		}
+		return BLOGS_FILE
+
+	case OP_DELETE_BLOG:
+		s.blogs.Blogs = deleteByID(s.blogs.Blogs, func(b Blog) bool { return b.ID == entry.ID })
+		return BLOGS_FILE
+
+	case OP_PUT_COMMENT:
+		comment := *entry.Comment
+		for i, blog := range s.blogs.Blogs {
+			if blog.ID == comment.BlogID {
+				s.blogs.Blogs[i].Comments = putByID(blog.Comments, comment, func(c Comment) bool { return c.ID == comment.ID })
+				break
+			}
+		}
+		if comment.ID >= s.blogs.NextCommentID {
+			s.blogs.NextCommentID = comment.ID + 1
+		}
+		return BLOGS_FILE
+
+	case OP_DELETE_COMMENT:
+		for i, blog := range s.blogs.Blogs {
+			if blog.ID == entry.BlogID {
+				s.blogs.Blogs[i].Comments = deleteByID(blog.Comments, func(c Comment) bool { return c.ID == entry.ID })
+				break
+			}
+		}
+		return BLOGS_FILE
+
+	case OP_PUT_TAG:
+		tag := *entry.Tag
+		s.tags.Tags = putByID(s.tags.Tags, tag, func(t Tag) bool { return t.ID == tag.ID })
+		if tag.ID >= s.tags.NextID {
+			s.tags.NextID = tag.ID + 1
+		}
+		return TAGS_FILE
+
+	case OP_DELETE_TAG:
+		s.tags.Tags = deleteByID(s.tags.Tags, func(t Tag) bool { return t.ID == entry.ID })
+		return TAGS_FILE
+
+	case OP_PUT_LIST:
+		list := *entry.List
+		s.lists.Lists = putByID(s.lists.Lists, list, func(l TodoList) bool { return l.ID == list.ID })
+		if list.ID >= s.lists.NextID {
+			s.lists.NextID = list.ID + 1
+		}
+		return LISTS_FILE
+
+	case OP_DELETE_LIST:
+		s.lists.Lists = deleteByID(s.lists.Lists, func(l TodoList) bool { return l.ID == entry.ID })
+		return LISTS_FILE
+
+	case OP_PUT_TOKEN:
+		token := *entry.AccessToken
+		s.tokens.Tokens = putByID(s.tokens.Tokens, token, func(t AccessToken) bool { return t.ID == token.ID })
+		if token.ID >= s.tokens.NextID {
+			s.tokens.NextID = token.ID + 1
+		}
+		return TOKENS_FILE
+
+	case OP_DELETE_TOKEN:
+		s.tokens.Tokens = deleteByID(s.tokens.Tokens, func(t AccessToken) bool { return t.ID == entry.ID })
+		return TOKENS_FILE
 	}
 
-	// 更新存储
+	log.Printf("未知的日志操作: %s", entry.Op)
+	return ""
+}
+
+// 记录并应用一次修改，受影响的数据文件在下一次Sync时重写
+func (s *JSONStorage) commit(entry journalEntry) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.users = data.Users
-	s.nextID = data.NextID
+	if err := s.journal.append(entry); err != nil {
+		return err
+	}
 
+	if path := s.apply(entry); path != "" {
+		s.dirty[path] = true
+	}
 	return nil
 }
 
-// 保存待办事项数据到文件
-func (s *TodoStore) SaveToFile() error {
+// Sync 重写有修改的数据文件，全部成功后清空预写日志
+func (s *JSONStorage) Sync() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// 确保数据目录存在
-	if err := ensureDataDir(); err != nil {
-		return err
+	for path := range s.dirty {
+		if err := writeJSONFile(filepath.Join(s.dir, path), s.file(path)); err != nil {
+			// 修改仍保留在日志中，下次Sync或启动时会重试
+			return err
+		}
+		delete(s.dirty, path)
 	}
 
-	// 创建要保存的数据结构
-	data := struct {
-		Todos  []Todo `json:"todos"`
-		NextID int    `json:"next_id"`
-	}{s.todos, s.nextID}
+	if s.journal.count == 0 {
+		return nil
+	}
+	return s.journal.reset()
+}
 
-	// 将数据编码为JSON
-	jsonData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		return err
+// 把所有数据文件写入磁盘后清空预写日志
+func (s *JSONStorage) checkpoint() error {
+	for _, path := range dataFiles {
+		if err := writeJSONFile(filepath.Join(s.dir, path), s.file(path)); err != nil {
+			return err
+		}
+		delete(s.dirty, path)
 	}
 
-	// 写入文件
-	return ioutil.WriteFile(TODOS_FILE, jsonData, 0644)
+	return s.journal.reset()
 }
 
-// 从文件加载待办事项数据
-func (s *TodoStore) LoadFromFile() error {
-	// 确保数据目录存在
-	if err := ensureDataDir(); err != nil {
-		return err
-	}
+// LoadUsers 返回所有用户
+func (s *JSONStorage) LoadUsers() ([]User, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// 检查文件是否存在
-	if _, err := os.Stat(TODOS_FILE); os.IsNotExist(err) {
-		// 文件不存在，使用默认数据
-		return nil
-	}
+	return append([]User(nil), s.users.Users...), s.users.NextID, nil
+}
 
-	// 读取文件
-	jsonData, err := ioutil.ReadFile(TODOS_FILE)
-	if err != nil {
-		return err
-	}
+// PutUser 新增或更新用户
+func (s *JSONStorage) PutUser(user User) error {
+	return s.commit(journalEntry{Op: OP_PUT_USER, User: &user})
+}
 
-	// 解码JSON数据
-	var data struct {
-		Todos  []Todo `json:"todos"`
-		NextID int    `json:"next_id"`
-	}
+// DeleteUser 删除用户
+func (s *JSONStorage) DeleteUser(id int) error {
+	return s.commit(journalEntry{Op: OP_DELETE_USER, ID: id})
+}
 
-	if err := json.Unmarshal(jsonData, &data); err != nil {
-		return err
+// LoadSessions 返回所有会话
+func (s *JSONStorage) LoadSessions() ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Session(nil), s.sessions.Sessions...), nil
+}
+
+// PutSession 新增或更新会话
+func (s *JSONStorage) PutSession(session Session) error {
+	return s.commit(journalEntry{Op: OP_PUT_SESSION, Session: &session})
+}
+
+// DeleteSession 删除会话
+func (s *JSONStorage) DeleteSession(token string) error {
+	return s.commit(journalEntry{Op: OP_DELETE_SESSION, Token: token})
+}
+
+// LoadTodos 返回所有待办事项
+func (s *JSONStorage) LoadTodos() ([]Todo, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Todo(nil), s.todos.Todos...), s.todos.NextID, nil
+}
+
+// PutTodo 新增或更新待办事项
+func (s *JSONStorage) PutTodo(todo Todo) error {
+	return s.commit(journalEntry{Op: OP_PUT_TODO, Todo: &todo})
+}
+
+// DeleteTodo 删除待办事项
+func (s *JSONStorage) DeleteTodo(id int) error {
+	return s.commit(journalEntry{Op: OP_DELETE_TODO, ID: id})
+}
+
+// LoadBlogs 返回所有博客及其评论
+func (s *JSONStorage) LoadBlogs() ([]Blog, int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blogs := make([]Blog, 0, len(s.blogs.Blogs))
+	for _, blog := range s.blogs.Blogs {
+		blog.Comments = append([]Comment{}, blog.Comments...)
+		blogs = append(blogs, blog)
 	}
 
-	// 更新存储
+	return blogs, s.blogs.NextID, s.blogs.NextCommentID, nil
+}
+
+// PutBlog 新增或更新博客，博客的评论随博客一起保存
+func (s *JSONStorage) PutBlog(blog Blog) error {
+	return s.commit(journalEntry{Op: OP_PUT_BLOG, Blog: &blog})
+}
+
+// DeleteBlog 删除博客及其评论
+func (s *JSONStorage) DeleteBlog(id int) error {
+	return s.commit(journalEntry{Op: OP_DELETE_BLOG, ID: id})
+}
+
+// PutComment 新增或更新评论
+func (s *JSONStorage) PutComment(comment Comment) error {
+	return s.commit(journalEntry{Op: OP_PUT_COMMENT, Comment: &comment})
+}
+
+// DeleteComment 删除评论
+func (s *JSONStorage) DeleteComment(blogID, id int) error {
+	return s.commit(journalEntry{Op: OP_DELETE_COMMENT, BlogID: blogID, ID: id})
+}
+
+// LoadTags 返回所有标签
+func (s *JSONStorage) LoadTags() ([]Tag, int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.todos = data.Todos
-	s.nextID = data.NextID
+	return append([]Tag(nil), s.tags.Tags...), s.tags.NextID, nil
+}
+
+// PutTag 新增或更新标签
+func (s *JSONStorage) PutTag(tag Tag) error {
+	return s.commit(journalEntry{Op: OP_PUT_TAG, Tag: &tag})
+}
 
-	return nil
+// DeleteTag 删除标签
+func (s *JSONStorage) DeleteTag(id int) error {
+	return s.commit(journalEntry{Op: OP_DELETE_TAG, ID: id})
 }
 
-// 定期保存数据的函数
-func startAutoSave(wg *sync.WaitGroup, quit chan struct{}) {
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		ticker := time.NewTicker(5 * time.Minute) // 每5分钟保存一次
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				// 保存数据
-				if err := userStore.SaveToFile(); err != nil {
-					log.Printf("保存用户数据失败: %v\n", err)
-				}
-				if err := todoStore.SaveToFile(); err != nil {
-					log.Printf("保存待办事项数据失败: %v\n", err)
-				}
-				if err := blogStore.SaveToFile(); err != nil {
-					log.Printf("保存博客数据失败: %v\n", err)
-				}
-			case <-quit:
-				// 退出信号
-				return
-			}
+// LoadLists 返回所有待办事项列表
+func (s *JSONStorage) LoadLists() ([]TodoList, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]TodoList(nil), s.lists.Lists...), s.lists.NextID, nil
+}
+
+// PutList 新增或更新待办事项列表
+func (s *JSONStorage) PutList(list TodoList) error {
+	return s.commit(journalEntry{Op: OP_PUT_LIST, List: &list})
+}
+
+// DeleteList 删除待办事项列表
+func (s *JSONStorage) DeleteList(id int) error {
+	return s.commit(journalEntry{Op: OP_DELETE_LIST, ID: id})
+}
+
+// LoadTokens 返回所有个人访问令牌
+func (s *JSONStorage) LoadTokens() ([]AccessToken, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]AccessToken(nil), s.tokens.Tokens...), s.tokens.NextID, nil
+}
+
+// PutToken 新增或更新个人访问令牌
+func (s *JSONStorage) PutToken(token AccessToken) error {
+	return s.commit(journalEntry{Op: OP_PUT_TOKEN, AccessToken: &token})
+}
+
+// DeleteToken 删除个人访问令牌
+func (s *JSONStorage) DeleteToken(id int) error {
+	return s.commit(journalEntry{Op: OP_DELETE_TOKEN, ID: id})
+}
+
+// Close 写入检查点并关闭预写日志
+func (s *JSONStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkpoint(); err != nil {
+		return err
+	}
+	return s.journal.close()
+}
+
+// 替换切片中匹配的元素，没有匹配时追加到末尾
+func putByID[T any](items []T, item T, match func(T) bool) []T {
+	for i := range items {
+		if match(items[i]) {
+			items[i] = item
+			return items
+		}
+	}
+	return append(items, item)
+}
+
+// 删除切片中匹配的元素
+func deleteByID[T any](items []T, match func(T) bool) []T {
+	for i := range items {
+		if match(items[i]) {
+			return append(items[:i], items[i+1:]...)
 		}
-	}()
-}
\ No newline at end of file
+	}
+	return items
+}
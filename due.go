@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 开始日期和截止日期的格式
+const DATE_LAYOUT = "2006-01-02"
+
+// 返回服务器所在时区的今天日期
+func today() string {
+	return time.Now().Format(DATE_LAYOUT)
+}
+
+// 检查日期格式，空字符串表示未设置
+func validateDate(name, date string) error {
+	if date == "" {
+		return nil
+	}
+	if _, err := time.Parse(DATE_LAYOUT, date); err != nil {
+		return fmt.Errorf("%s格式错误，应为YYYY-MM-DD", name)
+	}
+	return nil
+}
+
+// IsOverdue 判断待办事项在指定日期是否已逾期
+func (t Todo) IsOverdue(today string) bool {
+	return t.DueDate != "" && !t.Completed && t.DueDate < today
+}
+
+// dueFilter 是GET /api/todos支持的截止日期筛选条件
+type dueFilter struct {
+	before  string // 截止日期早于该日期
+	after   string // 截止日期晚于该日期
+	overdue bool   // 只返回已逾期的待办事项
+	today   bool   // 只返回今天到期的待办事项
+}
+
+// 从查询参数中解析筛选条件：due_before、due_after、overdue=true、today=true
+func parseDueFilter(query url.Values) (dueFilter, error) {
+	var filter dueFilter
+
+	filter.before = query.Get("due_before")
+	if err := validateDate("due_before", filter.before); err != nil {
+		return filter, ValidationErrors{"due_before": err.Error()}
+	}
+	filter.after = query.Get("due_after")
+	if err := validateDate("due_after", filter.after); err != nil {
+		return filter, ValidationErrors{"due_after": err.Error()}
+	}
+
+	if overdueStr := query.Get("overdue"); overdueStr != "" {
+		filter.overdue, _ = strconv.ParseBool(overdueStr)
+	}
+	if todayStr := query.Get("today"); todayStr != "" {
+		filter.today, _ = strconv.ParseBool(todayStr)
+	}
+
+	return filter, nil
+}
+
+// 是否设置了任何筛选条件
+func (f dueFilter) empty() bool {
+	return f.before == "" && f.after == "" && !f.overdue && !f.today
+}
+
+// 判断待办事项是否满足筛选条件，设置了条件时没有截止日期的待办事项会被排除
+func (f dueFilter) match(todo Todo, today string) bool {
+	if f.empty() {
+		return true
+	}
+	if todo.DueDate == "" {
+		return false
+	}
+	if f.before != "" && todo.DueDate >= f.before {
+		return false
+	}
+	if f.after != "" && todo.DueDate <= f.after {
+		return false
+	}
+	if f.overdue && !todo.IsOverdue(today) {
+		return false
+	}
+	if f.today && todo.DueDate != today {
+		return false
+	}
+	return true
+}
+
+// 返回满足筛选条件的待办事项
+func (f dueFilter) apply(todos []Todo) []Todo {
+	if f.empty() {
+		return todos
+	}
+
+	today := today()
+	filtered := make([]Todo, 0, len(todos))
+	for _, todo := range todos {
+		if f.match(todo, today) {
+			filtered = append(filtered, todo)
+		}
+	}
+	return filtered
+}
+
+// 处理待办事项日期更新，与PATCH /api/todos/{id}使用相同的校验和更新逻辑
+func handleUpdateTodoDates(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户
+	actor, err := currentActor(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// 解析请求体
+	var datesUpdate struct {
+		TodoID    int    `json:"todo_id"`
+		StartDate string `json:"start_date"`
+		DueDate   string `json:"due_date"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&datesUpdate); err != nil {
+		writeError(w, r, ValidationErrors{"body": err.Error()})
+		return
+	}
+
+	// 空字符串表示清除日期
+	patch := TodoPatch{StartDate: &datesUpdate.StartDate, DueDate: &datesUpdate.DueDate}
+	if errs := patch.Validate(); errs != nil {
+		writeError(w, r, errs)
+		return
+	}
+
+	// 更新待办事项日期，管理员可以操作所有待办事项
+	todo, err := todoStore.Update(datesUpdate.TodoID, actor, patch)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(todo)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestDueFilterMatch(t *testing.T) {
+	const today = "2024-03-10"
+	todos := []Todo{
+		{ID: 1, DueDate: "2024-03-01"},
+		{ID: 2, DueDate: "2024-03-01", Completed: true},
+		{ID: 3, DueDate: today},
+		{ID: 4, DueDate: "2024-03-20"},
+		{ID: 5},
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"", []int{1, 2, 3, 4, 5}},
+		{"overdue=true", []int{1}},
+		{"today=true", []int{3}},
+		{"due_before=2024-03-10", []int{1, 2}},
+		{"due_after=2024-03-10", []int{4}},
+		{"due_after=2024-03-01&due_before=2024-03-20", []int{3}},
+		{"overdue=false", []int{1, 2, 3, 4, 5}},
+	}
+
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		filter, err := parseDueFilter(query)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+
+		var got []int
+		for _, todo := range todos {
+			if filter.match(todo, today) {
+				got = append(got, todo.ID)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: matched %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseDueFilterInvalidDate(t *testing.T) {
+	for _, field := range []string{"due_before", "due_after"} {
+		query := url.Values{field: {"2024-13-01"}}
+		_, err := parseDueFilter(query)
+		errs, ok := err.(ValidationErrors)
+		if !ok || errs[field] == "" {
+			t.Errorf("%s: err = %v, want a ValidationErrors for %s", field, err, field)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// 业务错误的类别，存储层的错误通过%w包装其中之一，处理器据此决定状态码
+var (
+	ErrValidation   = errors.New("validation failed")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("permission denied") // 用户能看到资源，但没有执行该操作的权限
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict") // 与资源当前的状态冲突
+)
+
+// 错误类别对应的状态码和默认错误码
+var errorKinds = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{ErrValidation, http.StatusBadRequest, "validation_failed"},
+	{ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrNotFound, http.StatusNotFound, "not_found"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+}
+
+// ErrNotAuthenticated 表示请求上下文中没有当前用户
+var ErrNotAuthenticated = domainError(ErrUnauthorized, "unauthorized", "未找到用户ID")
+
+// codedError 是属于某个类别、带有固定错误码的业务错误
+type codedError struct {
+	kind    error
+	code    string
+	message string
+}
+
+func (e *codedError) Error() string { return e.message }
+func (e *codedError) Unwrap() error { return e.kind }
+
+// 创建属于kind类别的业务错误，code是返回给客户端的错误码，不应随版本变化
+func domainError(kind error, code, message string) error {
+	return &codedError{kind: kind, code: code, message: message}
+}
+
+// Problem 是RFC 7807定义的问题详情，code和fields是扩展字段
+type Problem struct {
+	Type     string           `json:"type"`
+	Title    string           `json:"title"`
+	Status   int              `json:"status"`
+	Detail   string           `json:"detail,omitempty"`
+	Instance string           `json:"instance,omitempty"`
+	Code     string           `json:"code"`
+	Fields   ValidationErrors `json:"fields,omitempty"` // 校验失败的字段
+}
+
+// 返回错误对应的状态码和错误码，未分类的错误为500
+func problemFor(err error) (int, string) {
+	status, code := http.StatusInternalServerError, statusCode(http.StatusInternalServerError)
+	for _, k := range errorKinds {
+		if errors.Is(err, k.kind) {
+			status, code = k.status, k.code
+			break
+		}
+	}
+
+	var coded *codedError
+	if errors.As(err, &coded) {
+		code = coded.code
+	}
+	return status, code
+}
+
+// 由状态码生成默认的错误码，如404为not_found
+func statusCode(status int) string {
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+// 按错误的类别返回问题详情，未分类的错误只记录日志，不把内部信息返回给客户端
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code := problemFor(err)
+	problem := Problem{Status: status, Code: code, Detail: err.Error()}
+	if status == http.StatusInternalServerError {
+		log.Printf("处理请求%s %s失败: %v", r.Method, r.URL.Path, err)
+		problem.Detail = "服务器内部错误"
+	}
+
+	var fields ValidationErrors
+	if errors.As(err, &fields) {
+		problem.Fields = fields
+	}
+
+	writeProblemDetails(w, r, problem)
+}
+
+// 返回不属于业务错误的问题详情，如请求格式错误，错误码由状态码生成
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	writeProblemDetails(w, r, Problem{Status: status, Code: statusCode(status), Detail: detail})
+}
+
+// 写入问题详情。API请求返回application/problem+json，表单和页面请求返回纯文本
+func writeProblemDetails(w http.ResponseWriter, r *http.Request, problem Problem) {
+	if problem.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="todolist"`)
+	}
+	if !isAPIRequest(r) {
+		http.Error(w, problem.Detail, problem.Status)
+		return
+	}
+
+	problem.Type = "about:blank"
+	problem.Title = http.StatusText(problem.Status)
+	problem.Instance = r.URL.Path
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
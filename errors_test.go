@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 处理函数返回的错误应按照错误类型映射为对应的状态码、错误码和字段
+func TestHandlerErrorProblems(t *testing.T) {
+	setupTestStores(t)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+	_, plain, err := userStore.CreateToken(user.ID, "ci", TOKEN_SCOPE_WRITE, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := todoStore.AddTag(user.ID, "工作", ""); err != nil {
+		t.Fatal(err)
+	}
+	handler := newRouter()
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		code   string
+		field  string
+	}{
+		{"duplicate tag", http.MethodPost, "/api/tags", `{"name":"工作"}`, http.StatusConflict, "tag_exists", ""},
+		{"bad due filter", http.MethodGet, "/api/todos?due_before=2024-13-01", "", http.StatusBadRequest, "validation_failed", "due_before"},
+		{"bad due date", http.MethodPost, "/api/todos", `{"title":"写周报","due_date":"明天"}`, http.StatusBadRequest, "validation_failed", "due_date"},
+		{"missing list", http.MethodPost, "/api/todos", `{"title":"写周报","list_id":999}`, http.StatusNotFound, "not_found", ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		r.Header.Set("Authorization", "Bearer "+plain)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.status, w.Body.String())
+			continue
+		}
+		var problem Problem
+		if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if problem.Code != tt.code {
+			t.Errorf("%s: code = %q, want %q", tt.name, problem.Code, tt.code)
+		}
+		if tt.field != "" && problem.Fields[tt.field] == "" {
+			t.Errorf("%s: fields = %v, want an error for %s", tt.name, problem.Fields, tt.field)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// 请求上下文中保存当前用户的键，使用未导出的类型，避免与其他值冲突
+type identityKey struct{}
+
+// Identity 是通过认证的当前用户，由authMiddleware放入请求上下文。
+// 客户端无法伪造，处理函数只能通过identityFrom读取
+type Identity struct {
+	UserID     int
+	Username   string
+	Roles      []string
+	TokenID    int    // 通过个人访问令牌认证时的令牌ID
+	TokenScope string // 通过个人访问令牌认证时的权限范围，浏览器会话为空
+}
+
+// 返回带有当前用户的请求
+func withIdentity(r *http.Request, identity Identity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+}
+
+// 从请求上下文中获取当前用户，未经过authMiddleware的请求返回false
+func identityFrom(r *http.Request) (Identity, bool) {
+	identity, ok := r.Context().Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// 删除客户端发送的身份相关请求头（X-User-*、X-Username、X-Is-Admin）。
+// 旧版本通过这些请求头传递当前用户，删除后即使有代码误读也拿不到伪造的值
+func stripIdentityHeaders(h http.Header) {
+	for name := range h {
+		if strings.HasPrefix(name, "X-User-") || name == "X-Username" || name == "X-Is-Admin" {
+			delete(h, name)
+		}
+	}
+}
+
+// 获取当前用户ID
+func getCurrentUserID(r *http.Request) (int, error) {
+	identity, ok := identityFrom(r)
+	if !ok {
+		return 0, ErrNotAuthenticated
+	}
+	return identity.UserID, nil
+}
+
+// 获取当前用户名，未登录时返回空字符串
+func currentUsername(r *http.Request) string {
+	identity, _ := identityFrom(r)
+	return identity.Username
+}
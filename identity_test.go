@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 设置旧版本用于传递当前用户的请求头，伪装成管理员
+func forgeAdminHeaders(r *http.Request, admin User) {
+	r.Header.Set("X-Is-Admin", "true")
+	r.Header.Set("X-User-Id", strconv.Itoa(admin.ID))
+	r.Header.Set("X-User-Roles", ROLE_ADMIN)
+	r.Header.Set("X-Username", admin.Username)
+}
+
+// 伪造的身份请求头不能让普通用户或匿名用户访问管理接口
+func TestForgedIdentityHeadersRejected(t *testing.T) {
+	setupTestStores(t)
+	admin := registerTestUser(t, "root", ROLE_ADMIN)
+	member := registerTestUser(t, "alice", ROLE_MEMBER)
+	session, err := userStore.createSession(member, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, plain, err := userStore.CreateToken(member.ID, "ci", TOKEN_SCOPE_WRITE, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := csrfMiddleware(newRouter())
+
+	tests := []struct {
+		name    string
+		cookie  *http.Cookie
+		request func() *http.Request
+		want    int
+	}{
+		{
+			// 使用访问令牌时不经过CSRF检查，直接由权限检查拒绝
+			name: "member token delete admin",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodDelete, "/api/admin/users/"+strconv.Itoa(admin.ID), nil)
+				r.Header.Set("Authorization", "Bearer "+plain)
+				return r
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name:    "member list users",
+			cookie:  &http.Cookie{Name: "session_token", Value: session.Token},
+			request: func() *http.Request { return httptest.NewRequest(http.MethodGet, "/api/admin/users", nil) },
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "anonymous list users",
+			request: func() *http.Request { return httptest.NewRequest(http.MethodGet, "/api/admin/users", nil) },
+			want:    http.StatusUnauthorized,
+		},
+		{
+			name:   "member user detail",
+			cookie: &http.Cookie{Name: "session_token", Value: session.Token},
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/api/admin/users/"+strconv.Itoa(admin.ID), nil)
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "anonymous user detail",
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/api/admin/users/"+strconv.Itoa(admin.ID), nil)
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:    "member roles",
+			cookie:  &http.Cookie{Name: "session_token", Value: session.Token},
+			request: func() *http.Request { return httptest.NewRequest(http.MethodGet, "/api/admin/roles", nil) },
+			want:    http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.request()
+			forgeAdminHeaders(r, admin)
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if _, err := userStore.GetUser(admin.ID); err != nil {
+				t.Errorf("admin account was removed: %v", err)
+			}
+		})
+	}
+}
+
+// 经过认证的处理器看不到客户端发送的身份请求头
+func TestAuthMiddlewareStripsIdentityHeaders(t *testing.T) {
+	setupTestStores(t)
+	admin := registerTestUser(t, "root", ROLE_ADMIN)
+	member := registerTestUser(t, "alice", ROLE_MEMBER)
+	session, err := userStore.createSession(member, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seen http.Header
+	var identity Identity
+	handler := authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		identity, _ = identityFrom(r)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/current-user", nil)
+	forgeAdminHeaders(r, admin)
+	r.AddCookie(&http.Cookie{Name: "session_token", Value: session.Token})
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	for _, name := range []string{"X-Is-Admin", "X-User-Id", "X-User-Roles", "X-Username"} {
+		if v := seen.Get(name); v != "" {
+			t.Errorf("handler saw %s: %q", name, v)
+		}
+	}
+	if identity.UserID != member.ID {
+		t.Errorf("identity user = %d, want the session user %d", identity.UserID, member.ID)
+	}
+	if hasRole(identity.Roles, ROLE_ADMIN) {
+		t.Errorf("identity roles = %v, forged admin role leaked in", identity.Roles)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// 预写日志文件名，位于数据目录下
+const JOURNAL_FILE = "journal.log"
+
+// 日志中记录的操作类型
+const (
+	OP_PUT_USER       = "put_user"
+	OP_DELETE_USER    = "delete_user"
+	OP_PUT_SESSION    = "put_session"
+	OP_DELETE_SESSION = "delete_session"
+	OP_PUT_TODO       = "put_todo"
+	OP_DELETE_TODO    = "delete_todo"
+	OP_PUT_BLOG       = "put_blog"
+	OP_DELETE_BLOG    = "delete_blog"
+	OP_PUT_COMMENT    = "put_comment"
+	OP_DELETE_COMMENT = "delete_comment"
+	OP_PUT_TAG        = "put_tag"
+	OP_DELETE_TAG     = "delete_tag"
+	OP_PUT_LIST       = "put_list"
+	OP_DELETE_LIST    = "delete_list"
+	OP_PUT_TOKEN      = "put_token"
+	OP_DELETE_TOKEN   = "delete_token"
+)
+
+// journalEntry 是预写日志中的一条记录，对应一次修改操作。
+// 所有操作都是按ID覆盖或删除，重复回放不会改变结果。
+type journalEntry struct {
+	Op          string       `json:"op"`
+	User        *User        `json:"user,omitempty"`
+	Session     *Session     `json:"session,omitempty"`
+	Todo        *Todo        `json:"todo,omitempty"`
+	Blog        *Blog        `json:"blog,omitempty"`
+	Comment     *Comment     `json:"comment,omitempty"`
+	Tag         *Tag         `json:"tag,omitempty"`
+	List        *TodoList    `json:"list,omitempty"`
+	AccessToken *AccessToken `json:"access_token,omitempty"`
+	ID          int          `json:"id,omitempty"`
+	BlogID      int          `json:"blog_id,omitempty"`
+	Token       string       `json:"token,omitempty"`
+}
+
+// journal 是只追加的预写日志，每条记录占一行JSON
+type journal struct {
+	file  *os.File
+	count int
+}
+
+// openJournal 打开预写日志，并返回其中尚未进入数据文件的记录。
+// 崩溃时写了一半的行和之后的内容会被截掉，否则新追加的记录会接在残缺的行后面，下次启动时无法回放。
+func openJournal(path string) (*journal, []journalEntry, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := make([]journalEntry, 0)
+	reader := bufio.NewReader(file)
+	var offset int64 // 最后一条完整记录之后的位置
+	torn := false
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// 最后一行没有换行符，说明写入时发生了崩溃
+			if len(line) > 0 {
+				log.Printf("预写日志第%d行不完整，已丢弃", lineNo)
+				torn = true
+			}
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			log.Printf("预写日志第%d行损坏，已丢弃之后的内容: %v", lineNo, err)
+			torn = true
+			break
+		}
+		entries = append(entries, entry)
+		offset += int64(len(line))
+	}
+
+	if torn {
+		if err := file.Truncate(offset); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	}
+
+	return &journal{file: file, count: len(entries)}, entries, nil
+}
+
+// append 追加一条记录并同步到磁盘
+func (j *journal) append(entry journalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	j.count++
+
+	return j.file.Sync()
+}
+
+// reset 清空日志，只能在所有数据文件都已落盘之后调用
+func (j *journal) reset() error {
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	j.count = 0
+
+	return j.file.Sync()
+}
+
+// close 关闭日志文件
+func (j *journal) close() error {
+	return j.file.Close()
+}
+
+// 读取JSON文件，文件不存在时保持默认数据。
+// 主文件损坏或缺失时使用上一次的.bak备份，两者都不可用时返回错误，避免静默地从空数据开始。
+func readJSONFile(path string, v interface{}) error {
+	err := decodeJSONFile(path, v)
+	if err == nil {
+		return nil
+	}
+
+	backupErr := decodeJSONFile(path+".bak", v)
+	if backupErr == nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取%s失败: %v，已使用备份文件", path, err)
+		}
+		return nil
+	}
+
+	// 主文件和备份都不存在，使用默认数据
+	if os.IsNotExist(err) && os.IsNotExist(backupErr) {
+		return nil
+	}
+	if os.IsNotExist(err) {
+		return fmt.Errorf("读取%s.bak失败: %v", path, backupErr)
+	}
+
+	return fmt.Errorf("读取%s失败: %v", path, err)
+}
+
+// 读取并解码JSON文件
+func decodeJSONFile(path string, v interface{}) error {
+	jsonData, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(jsonData, v)
+}
+
+// 将数据编码为JSON并以崩溃安全的方式写入文件：
+// 先写入同目录下的临时文件并同步，再把旧文件保留为.bak，最后原子地重命名为目标文件
+func writeJSONFile(path string, v interface{}) error {
+	jsonData, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(jsonData); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	if err := backupFile(path); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// 把现有文件保留为.bak，目标文件在整个过程中一直存在
+func backupFile(path string) error {
+	backup := path + ".bak"
+	backupTmp := backup + ".tmp"
+	os.Remove(backupTmp)
+
+	if err := os.Link(path, backupTmp); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		// 不支持硬链接的文件系统上退回到复制
+		data, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return readErr
+		}
+		if err := os.WriteFile(backupTmp, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(backupTmp, backup)
+}
+
+// 同步目录，确保重命名操作已落盘
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	// 部分平台不支持同步目录，忽略该错误
+	d.Sync()
+	return nil
+}
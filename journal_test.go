@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 崩溃时写了一半的行应在打开时截掉，之后追加的记录在重新打开后仍能回放
+func TestJournalTruncatesTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), JOURNAL_FILE)
+	data := `{"op":"delete_todo","id":1}` + "\n" + `{"op":"put_todo","todo":{"id":2,"ti`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	j, entries, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Op != OP_DELETE_TODO || entries[0].ID != 1 {
+		t.Fatalf("entries = %+v, want the single complete entry", entries)
+	}
+	if err := j.append(journalEntry{Op: OP_DELETE_TAG, ID: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, entries, err = openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.close()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries after reopen, want 2: %+v", len(entries), entries)
+	}
+	if entries[1].Op != OP_DELETE_TAG || entries[1].ID != 3 {
+		t.Errorf("entries[1] = %+v, want the appended entry", entries[1])
+	}
+	if j.count != 2 {
+		t.Errorf("count = %d, want 2", j.count)
+	}
+}
+
+// 损坏的行和之后的内容都会被截掉
+func TestJournalTruncatesCorruptLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), JOURNAL_FILE)
+	valid := `{"op":"delete_todo","id":1}` + "\n"
+	data := valid + "not json\n" + `{"op":"delete_todo","id":2}` + "\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	j, entries, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.close()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(valid)) {
+		t.Errorf("journal size = %d, want %d", info.Size(), len(valid))
+	}
+}
+
+// 只有一行残缺记录时，JSONStorage不会写检查点，之后的修改仍需在重启后回放
+func TestJSONStorageReplaysAfterTornJournal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, JOURNAL_FILE)
+	if err := os.WriteFile(path, []byte(`{"op":"put_todo","todo":{"id":7`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewJSONStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutTodo(Todo{ID: 1, UserID: 1, Title: "恢复"}); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟崩溃：不写检查点直接关闭日志
+	s.journal.close()
+
+	s, err = NewJSONStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	todos, _, err := s.LoadTodos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(todos) != 1 || todos[0].Title != "恢复" {
+		t.Fatalf("todos = %+v, want the todo written after the torn line", todos)
+	}
+}
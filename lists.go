@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 列表名称的长度限制
+const LIST_NAME_MAX_LENGTH = 50
+
+// TodoList 是用户创建的待办事项列表（如"工作"、"家庭"）。
+// ListID为0的待办事项属于默认列表，默认列表不需要单独保存
+type TodoList struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Name      string    `json:"name"`
+	Archived  bool      `json:"archived"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Shares []ListShare `json:"shares,omitempty"` // 共享给的其他用户
+	Role   string      `json:"role,omitempty"`   // 当前用户的角色，只在返回给客户端时计算
+}
+
+// ErrListArchived 表示不能向已归档的列表添加待办事项
+var ErrListArchived = domainError(ErrConflict, "list_archived", "list is archived")
+
+// 检查列表名称，返回去掉首尾空白后的值
+func validateListName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("列表名称不能为空")
+	}
+	if utf8.RuneCountInString(name) > LIST_NAME_MAX_LENGTH {
+		return "", fmt.Errorf("列表名称不能超过%d个字符", LIST_NAME_MAX_LENGTH)
+	}
+	return name, nil
+}
+
+// 查找列表的下标，调用时需持有s.mu
+func (s *TodoStore) listIndex(id int) int {
+	for i, list := range s.lists {
+		if list.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// 检查用户能否向指定列表放入待办事项，0表示默认列表，调用时需持有s.mu
+func (s *TodoStore) checkListWritable(listID int, actor Actor) error {
+	if listID == 0 {
+		return nil
+	}
+	i, err := s.authorizeList(listID, actor, ACCESS_EDIT)
+	if err != nil {
+		return err
+	}
+	if s.lists[i].Archived {
+		return ErrListArchived
+	}
+	return nil
+}
+
+// 判断待办事项是否与指定位置在同一组中排序：同一列表中同一父任务下。
+// 共享列表中的待办事项不区分创建者，默认列表的顶层待办事项按用户区分
+func inSameGroup(todo Todo, userID, listID, parentID int) bool {
+	if todo.ListID != listID || todo.ParentID != parentID {
+		return false
+	}
+	return listID != 0 || parentID != 0 || todo.UserID == userID
+}
+
+// 返回列表中（或父任务的子任务中）下一个排序顺序（放在最后），调用时需持有s.mu
+func (s *TodoStore) nextOrder(userID, listID, parentID int) int {
+	maxOrder := 0
+	for _, todo := range s.todos {
+		if inSameGroup(todo, userID, listID, parentID) && !todo.Deleted && todo.Order > maxOrder {
+			maxOrder = todo.Order
+		}
+	}
+	return maxOrder + 1
+}
+
+// 判断待办事项是否属于已归档的列表，调用时需持有s.mu
+func (s *TodoStore) inArchivedList(todo Todo) bool {
+	if todo.ListID == 0 {
+		return false
+	}
+	for _, list := range s.lists {
+		if list.ID == todo.ListID {
+			return list.Archived
+		}
+	}
+	return false
+}
+
+// 返回属于指定列表的待办事项
+func filterByList(todos []Todo, listID int) []Todo {
+	filtered := make([]Todo, 0, len(todos))
+	for _, todo := range todos {
+		if todo.ListID == listID {
+			filtered = append(filtered, todo)
+		}
+	}
+	return filtered
+}
+
+// ExcludeArchived 去掉属于已归档列表的待办事项
+func (s *TodoStore) ExcludeArchived(todos []Todo) []Todo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filtered := make([]Todo, 0, len(todos))
+	for _, todo := range todos {
+		if !s.inArchivedList(todo) {
+			filtered = append(filtered, todo)
+		}
+	}
+	return filtered
+}
+
+// GetLists 返回用户可以查看的列表，按创建顺序排列。
+// 包括用户自己的列表和共享给用户的列表，可以管理所有待办事项的用户能看到所有列表
+func (s *TodoStore) GetLists(actor Actor, includeArchived bool) []TodoList {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lists := make([]TodoList, 0)
+	for _, list := range s.lists {
+		access := listAccess(actor, list)
+		if access != ACCESS_NONE && (includeArchived || !list.Archived) {
+			list.Role = access.role()
+			lists = append(lists, list)
+		}
+	}
+	return lists
+}
+
+// GetList 返回用户可以查看的列表
+func (s *TodoStore) GetList(id int, actor Actor) (TodoList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.authorizeList(id, actor, ACCESS_VIEW)
+	if err != nil {
+		return TodoList{}, err
+	}
+	list := s.lists[i]
+	list.Role = listAccess(actor, list).role()
+	return list, nil
+}
+
+// AddList 为用户创建新列表
+func (s *TodoStore) AddList(userID int, name string) (TodoList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := TodoList{
+		ID:        s.nextListID,
+		UserID:    userID,
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+	s.lists = append(s.lists, list)
+	s.nextListID++
+
+	// 保存数据
+	if err := s.persister.save(func() error { return s.storage.PutList(list) }); err != nil {
+		return TodoList{}, err
+	}
+
+	return list, nil
+}
+
+// UpdateList 修改列表名称或归档状态，nil表示不修改，只有列表的所有者可以修改
+func (s *TodoStore) UpdateList(id int, actor Actor, name *string, archived *bool) (TodoList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.authorizeList(id, actor, ACCESS_OWNER)
+	if err != nil {
+		return TodoList{}, err
+	}
+
+	if name != nil {
+		s.lists[i].Name = *name
+	}
+	if archived != nil {
+		s.lists[i].Archived = *archived
+	}
+
+	// 保存数据
+	list := s.lists[i]
+	if err := s.persister.save(func() error { return s.storage.PutList(list) }); err != nil {
+		return TodoList{}, err
+	}
+
+	return list, nil
+}
+
+// DeleteList 删除列表，列表中的待办事项移到各自创建者默认列表的末尾，只有列表的所有者可以删除
+func (s *TodoStore) DeleteList(id int, actor Actor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.authorizeList(id, actor, ACCESS_OWNER)
+	if err != nil {
+		return err
+	}
+
+	err = s.moveToDefaultList(id)
+	s.lists = append(s.lists[:i], s.lists[i+1:]...)
+
+	// 保存数据
+	return errors.Join(err, s.persister.save(func() error { return s.storage.DeleteList(id) }))
+}
+
+// 把列表中的待办事项移到各自创建者默认列表的末尾，调用时需持有s.mu
+func (s *TodoStore) moveToDefaultList(listID int) error {
+	// 按原有顺序移动，保持相对顺序不变
+	moved := make([]int, 0)
+	for k, todo := range s.todos {
+		if todo.ListID == listID {
+			moved = append(moved, k)
+		}
+	}
+	sort.SliceStable(moved, func(a, b int) bool {
+		return s.todos[moved[a]].Order < s.todos[moved[b]].Order
+	})
+
+	// 顶层待办事项排在默认列表的末尾，子任务保持在父任务中的顺序
+	next := make(map[int]int)
+	var err error
+	for _, k := range moved {
+		s.todos[k].ListID = 0
+		if s.todos[k].ParentID == 0 {
+			userID := s.todos[k].UserID
+			if _, ok := next[userID]; !ok {
+				next[userID] = s.nextOrder(userID, 0, 0)
+			}
+			s.todos[k].Order = next[userID]
+			next[userID]++
+		}
+		err = errors.Join(err, s.persistTodo(s.todos[k]))
+	}
+	return err
+}
+
+// 把待办事项放到所在列表（子任务为同一父任务下）的第position个位置（从0开始），并重新编号，调用时需持有s.mu
+func (s *TodoStore) reorderList(todoIndex, position int) error {
+	moving := s.todos[todoIndex]
+
+	// 同一列表中其他未删除的待办事项，按现有顺序排列
+	siblings := make([]int, 0)
+	for k, todo := range s.todos {
+		if k != todoIndex && inSameGroup(todo, moving.UserID, moving.ListID, moving.ParentID) && !todo.Deleted {
+			siblings = append(siblings, k)
+		}
+	}
+	sort.SliceStable(siblings, func(a, b int) bool {
+		return s.todos[siblings[a]].Order < s.todos[siblings[b]].Order
+	})
+
+	if position < 0 {
+		position = 0
+	}
+	if position > len(siblings) {
+		position = len(siblings)
+	}
+	ordered := make([]int, 0, len(siblings)+1)
+	ordered = append(ordered, siblings[:position]...)
+	ordered = append(ordered, todoIndex)
+	ordered = append(ordered, siblings[position:]...)
+
+	// 只保存顺序发生变化的待办事项
+	var err error
+	for order, k := range ordered {
+		if s.todos[k].Order != order+1 || k == todoIndex {
+			s.todos[k].Order = order + 1
+			err = errors.Join(err, s.persistTodo(s.todos[k]))
+		}
+	}
+	return err
+}
+
+// 处理列表的请求
+// GET  /api/lists 列出当前用户的列表，include_archived=true时包含已归档的列表
+// POST /api/lists 创建列表
+func handleLists(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// 获取当前用户
+	actor, err := currentActor(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		includeArchived, _ := strconv.ParseBool(r.URL.Query().Get("include_archived"))
+		json.NewEncoder(w).Encode(todoStore.GetLists(actor, includeArchived))
+
+	case http.MethodPost:
+		var input struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, r, ValidationErrors{"body": err.Error()})
+			return
+		}
+
+		name, err := validateListName(input.Name)
+		if err != nil {
+			writeError(w, r, ValidationErrors{"name": err.Error()})
+			return
+		}
+
+		list, err := todoStore.AddList(actor.UserID, name)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// 处理单个列表的请求
+// GET    /api/lists/{id} 获取列表
+// PATCH  /api/lists/{id} 修改名称或归档状态
+// DELETE /api/lists/{id} 删除列表，其中的待办事项移到默认列表
+func handleList(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户
+	actor, err := currentActor(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	id, err := pathInt(r, "id")
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		list, err := todoStore.GetList(id, actor)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPatch:
+		var input struct {
+			Name     *string `json:"name"`
+			Archived *bool   `json:"archived"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, r, ValidationErrors{"body": err.Error()})
+			return
+		}
+
+		if input.Name != nil {
+			name, err := validateListName(*input.Name)
+			if err != nil {
+				writeError(w, r, ValidationErrors{"name": err.Error()})
+				return
+			}
+			input.Name = &name
+		}
+
+		list, err := todoStore.UpdateList(id, actor, input.Name, input.Archived)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodDelete:
+		if err := todoStore.DeleteList(id, actor); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// 处理列表页面，使用与首页相同的模板
+func handleListPage(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户
+	actor, err := currentActor(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// 获取列表ID
+	id, err := pathInt(r, "id")
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+
+	list, err := todoStore.GetList(id, actor)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// 传递数据到模板
+	data := map[string]interface{}{
+		"Username":     currentUsername(r),
+		"List":         list,
+		"BlogsEnabled": features.Blogs,
+	}
+
+	err = templates.ExecuteTemplate(w, "index.html", data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// 返回列表中顶层待办事项的标题，按排序顺序排列
+func listTitles(actor Actor, listID int) []string {
+	todos := filterByList(todoStore.GetVisible(actor, false), listID)
+	sort.Slice(todos, func(i, j int) bool { return todos[i].Order < todos[j].Order })
+
+	titles := make([]string, 0, len(todos))
+	for _, todo := range todos {
+		titles = append(titles, todo.Title)
+	}
+	return titles
+}
+
+// 每个列表单独排序；归档的列表不能再添加待办事项，删除列表时待办事项按原有顺序移到默认列表的末尾
+func TestListOrderingArchiveAndDelete(t *testing.T) {
+	setupTestStores(t)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+	actor := Actor{UserID: user.ID, Roles: user.Roles}
+
+	list, err := todoStore.AddList(user.ID, "工作")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]int)
+	for _, input := range []TodoInput{
+		{Title: "周报", ListID: list.ID},
+		{Title: "例会", ListID: list.ID},
+		{Title: "买菜"},
+		{Title: "评审", ListID: list.ID},
+	} {
+		todo, err := todoStore.Add(actor, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[todo.Title] = todo.ID
+	}
+
+	if _, err := todoStore.UpdateOrder(ids["评审"], 0, nil, actor); err != nil {
+		t.Fatal(err)
+	}
+	if got := listTitles(actor, list.ID); !slices.Equal(got, []string{"评审", "周报", "例会"}) {
+		t.Errorf("list order = %v", got)
+	}
+	if got := listTitles(actor, 0); !slices.Equal(got, []string{"买菜"}) {
+		t.Errorf("default list = %v", got)
+	}
+
+	archived := true
+	if _, err := todoStore.UpdateList(list.ID, actor, nil, &archived); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := todoStore.Add(actor, TodoInput{Title: "复盘", ListID: list.ID}); !errors.Is(err, ErrListArchived) {
+		t.Errorf("add to archived list: err = %v, want ErrListArchived", err)
+	}
+	if lists := todoStore.GetLists(actor, false); len(lists) != 0 {
+		t.Errorf("GetLists without archived = %+v", lists)
+	}
+	if lists := todoStore.GetLists(actor, true); len(lists) != 1 {
+		t.Errorf("GetLists with archived returned %d lists, want 1", len(lists))
+	}
+
+	if err := todoStore.DeleteList(list.ID, actor); err != nil {
+		t.Fatal(err)
+	}
+	if got := listTitles(actor, 0); !slices.Equal(got, []string{"买菜", "评审", "周报", "例会"}) {
+		t.Errorf("default list after delete = %v", got)
+	}
+}
+
+// GetLists按完整的角色判断权限，管理员能看到其他用户的列表
+func TestGetListsUsesActorRoles(t *testing.T) {
+	setupTestStores(t)
+	alice := registerTestUser(t, "alice", ROLE_MEMBER)
+	bob := registerTestUser(t, "bob", ROLE_MEMBER)
+	admin := registerTestUser(t, "root", ROLE_ADMIN)
+
+	list, err := todoStore.AddList(alice.ID, "工作")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		actor Actor
+		want  bool
+	}{
+		{"owner", Actor{UserID: alice.ID, Roles: alice.Roles}, true},
+		{"other member", Actor{UserID: bob.ID, Roles: bob.Roles}, false},
+		{"admin", Actor{UserID: admin.ID, Roles: admin.Roles}, true},
+	}
+	for _, tt := range tests {
+		found := false
+		for _, l := range todoStore.GetLists(tt.actor, false) {
+			if l.ID == list.ID {
+				found = true
+				if l.Role != "owner" {
+					t.Errorf("%s: role = %q, want owner", tt.name, l.Role)
+				}
+			}
+		}
+		if found != tt.want {
+			t.Errorf("%s: list visible = %v, want %v", tt.name, found, tt.want)
+		}
+	}
+}
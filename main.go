@@ -1,17 +1,20 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -19,78 +22,204 @@ import (
 
 // User 表示一个用户
 type User struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"password"` // 实际应用中应该存储密码哈希
-	IsAdmin  bool   `json:"is_admin"` // 是否为管理员
+	ID       int      `json:"id"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`           // argon2id密码哈希，旧数据中可能是明文，登录时自动迁移
+	Roles    []string `json:"roles"`              // 用户角色，见rbac.go
+	IsAdmin  bool     `json:"is_admin,omitempty"` // 旧版本的管理员标记，加载时迁移为Roles
+
+	Disabled           bool      `json:"disabled,omitempty"`             // 被管理员禁用的账号不能登录
+	MustChangePassword bool      `json:"must_change_password,omitempty"` // 管理员重置密码后，下次登录必须先修改密码
+	LastLoginAt        time.Time `json:"last_login_at"`
+
+	// 两步验证，见totp.go
+	TOTPSecret        string   `json:"totp_secret,omitempty"`         // 已启用的TOTP密钥（base32），为空表示未启用
+	TOTPPendingSecret string   `json:"totp_pending_secret,omitempty"` // 正在绑定、尚未确认的密钥
+	TOTPLastCounter   int64    `json:"totp_last_counter,omitempty"`   // 最后一次使用的时间步，防止验证码被重复使用
+	RecoveryCodes     []string `json:"recovery_codes,omitempty"`      // 恢复码的哈希，每个只能使用一次
+
+	// 单点登录，见oidc.go
+	OIDCIssuer  string `json:"oidc_issuer,omitempty"`  // 自动创建该用户的身份提供方
+	OIDCSubject string `json:"oidc_subject,omitempty"` // 用户在身份提供方的唯一标识（sub）
 }
 
 // Session 表示用户会话
 type Session struct {
-	Token     string    `json:"token"`
-	UserID    int       `json:"user_id"`
-	Username  string    `json:"username"`
-	IsAdmin   bool      `json:"is_admin"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Token      string    `json:"token"`
+	ID         string    `json:"id"` // 对外展示的会话标识，由令牌派生，不能反推出令牌
+	UserID     int       `json:"user_id"`
+	Username   string    `json:"username"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
 }
 
 // Todo 表示一个待办事项
 type Todo struct {
-	ID        int    `json:"id"`
-	UserID    int    `json:"user_id"`
-	Username  string `json:"username"` // 添加用户名字段，方便前端显示
+	ID        int      `json:"id"`
+	UserID    int      `json:"user_id"`
+	Username  string   `json:"username"` // 添加用户名字段，方便前端显示
+	Title     string   `json:"title"`
+	Completed bool     `json:"completed"`
+	Deleted   bool     `json:"deleted"`              // 标记待办事项是否已被删除（进入已完成状态）
+	Priority  int      `json:"priority"`             // 优先级: 0=低, 1=中, 2=高
+	Order     int      `json:"order"`                // 在所属列表中的排序顺序
+	ListID    int      `json:"list_id"`              // 所属列表，0表示默认列表
+	ParentID  int      `json:"parent_id,omitempty"`  // 父任务，0表示顶层待办事项
+	StartDate string   `json:"start_date,omitempty"` // 开始日期，格式为YYYY-MM-DD
+	DueDate   string   `json:"due_date,omitempty"`   // 截止日期，格式为YYYY-MM-DD
+	Notes     string   `json:"notes,omitempty"`      // 备注
+	Tags      []string `json:"tags,omitempty"`       // 标签
+
+	// 指派给的用户，被指派的用户可以修改和完成该待办事项
+	AssigneeID int    `json:"assignee_id,omitempty"`
+	Assignee   string `json:"assignee,omitempty"` // 被指派用户的用户名，方便前端显示
+
+	// 重复待办事项：完成一次后自动生成下一次，同一系列的实例共享SeriesID
+	Recurrence *Recurrence `json:"recurrence,omitempty"`
+	SeriesID   int         `json:"series_id,omitempty"`  // 系列中第一个待办事项的ID
+	Occurrence int         `json:"occurrence,omitempty"` // 在系列中的序号，从1开始
+	NextID     int         `json:"next_id,omitempty"`    // 已生成的下一次实例的ID
+
+	Progress *Progress `json:"progress,omitempty"` // 子任务进度，只在返回给客户端时计算
+}
+
+// TodoInput 是创建待办事项时由客户端提供的字段
+type TodoInput struct {
 	Title     string `json:"title"`
-	Completed bool   `json:"completed"`
-	Deleted   bool   `json:"deleted"` // 标记待办事项是否已被删除（进入已完成状态）
-	Priority  int    `json:"priority"` // 优先级: 0=低, 1=中, 2=高
-	Order     int    `json:"order"`    // 排序顺序
+	Priority  int    `json:"priority"`
+	StartDate string `json:"start_date"`
+	DueDate   string `json:"due_date"`
+
+	Recurrence *Recurrence `json:"recurrence"` // 可以是JSON对象或RRULE字符串
+	Tags       []string    `json:"tags"`
+	ListID     int         `json:"list_id"`
+	ParentID   int         `json:"parent_id"` // 作为该待办事项的子任务，所属列表与父任务相同
 }
 
 // UserStore 管理用户的存储
 type UserStore struct {
-	mu       sync.Mutex
-	users    []User
-	nextID   int
-	sessions map[string]Session
+	mu        sync.Mutex
+	users     []User
+	nextID    int
+	sessions  map[string]Session
+	storage   Storage
+	persister *persister
+
+	tokens      []AccessToken // 个人访问令牌
+	nextTokenID int
+
+	challenges map[string]loginChallenge // 等待两步验证的登录，只保存在内存中
 }
 
 // NewUserStore 创建一个新的UserStore
-func NewUserStore() *UserStore {
+func NewUserStore(storage Storage, flushInterval time.Duration) *UserStore {
 	store := &UserStore{
 		users:    make([]User, 0),
 		nextID:   1,
 		sessions: make(map[string]Session),
+		storage:  storage,
+
+		challenges: make(map[string]loginChallenge),
+		persister:  newPersister("用户", storage.Sync, flushInterval),
 	}
 
-	// 尝试从文件加载数据
-	err := store.LoadFromFile()
+	// 从存储加载数据。加载失败时不能继续运行，否则会创建默认管理员并覆盖已有的数据
+	users, nextID, err := storage.LoadUsers()
 	if err != nil {
-		log.Printf("加载用户数据失败: %v，将使用默认数据", err)
+		log.Fatalf("加载用户数据失败: %v", err)
+	}
+	store.users = users
+	store.nextID = nextID
+
+	// 旧数据中的管理员标记迁移为角色
+	if migrated, err := store.migrateRoles(); err != nil {
+		log.Printf("迁移用户角色失败: %v", err)
+	} else if migrated {
+		log.Printf("已将用户的管理员标记迁移为角色")
+	}
 
+	if len(store.users) == 0 {
 		// 创建默认的admin用户
+		passwordHash, err := hashPassword(DEFAULT_ADMIN_PASSWORD)
+		if err != nil {
+			log.Fatalf("创建默认管理员失败: %v", err)
+		}
 		admin := User{
-			ID:       store.nextID,
-			Username: "admin",
-			Password: "admin", // 实际应用中应该使用安全的密码
-			IsAdmin:  true,
+			ID:                 store.nextID,
+			Username:           DEFAULT_ADMIN_USERNAME,
+			Password:           passwordHash,
+			Roles:              []string{ROLE_ADMIN},
+			MustChangePassword: true, // 首次登录后必须修改默认密码
 		}
 
 		store.users = append(store.users, admin)
 		store.nextID++
+		if err := store.persistUser(admin); err != nil {
+			log.Printf("保存默认管理员失败: %v", err)
+		}
+	} else if store.requireDefaultPasswordChange() {
+		log.Printf("默认管理员仍在使用默认密码，登录后必须修改")
+	}
+
+	// 加载个人访问令牌
+	store.loadTokens()
+
+	// 恢复未过期的会话
+	sessions, err := storage.LoadSessions()
+	if err != nil {
+		log.Printf("加载会话数据失败: %v", err)
+	}
+	for _, session := range sessions {
+		if time.Now().After(session.ExpiresAt) {
+			if err := store.deleteSession(session.Token); err != nil {
+				log.Printf("删除过期会话失败: %v", err)
+			}
+			continue
+		}
+		// 旧版本保存的会话没有标识
+		if session.ID == "" {
+			session.ID = sessionID(session.Token)
+		}
+		store.sessions[session.Token] = session
 	}
 
 	return store
 }
 
-// Register 注册新用户
-func (s *UserStore) Register(username, password string, isAdmin bool) (User, error) {
+// 保存用户到存储后端
+func (s *UserStore) persistUser(user User) error {
+	return s.persister.save(func() error { return s.storage.PutUser(user) })
+}
+
+// 删除会话并同步到存储后端
+func (s *UserStore) deleteSession(token string) error {
+	delete(s.sessions, token)
+	return s.persister.save(func() error { return s.storage.DeleteSession(token) })
+}
+
+// Flush 等待所有用户和会话的修改写入存储
+func (s *UserStore) Flush() error {
+	return s.persister.Flush()
+}
+
+// Register 以指定角色注册新用户
+func (s *UserStore) Register(username, password string, role string) (User, error) {
+	// 计算密码哈希比较耗时，放在加锁之前
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// 检查用户名是否已存在
 	for _, user := range s.users {
 		if user.Username == username {
-			return User{}, fmt.Errorf("用户名已存在")
+			return User{}, ErrUsernameTaken
 		}
 	}
 
@@ -98,137 +227,185 @@ func (s *UserStore) Register(username, password string, isAdmin bool) (User, err
 	user := User{
 		ID:       s.nextID,
 		Username: username,
-		Password: password, // 实际应用中应该存储密码哈希
-		IsAdmin:  isAdmin,
+		Password: passwordHash,
+		Roles:    []string{role},
 	}
 
 	s.users = append(s.users, user)
 	s.nextID++
 
-	// 保存数据到文件
-	go s.SaveToFile()
+	// 保存数据
+	if err := s.persistUser(user); err != nil {
+		return User{}, err
+	}
 
 	return user, nil
 }
 
-// Login 用户登录
-func (s *UserStore) Login(username, password string) (Session, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// 注册和登录的错误
+var (
+	ErrUsernameTaken      = domainError(ErrConflict, "username_taken", "用户名已存在")
+	ErrInvalidCredentials = domainError(ErrUnauthorized, "invalid_credentials", "用户名或密码错误")
+	ErrAccountDisabled    = domainError(ErrForbidden, "account_disabled", "账号已被禁用")
+)
 
+// Login 用户登录，userAgent和ip记录在会话中供用户查看
+func (s *UserStore) Login(username, password, userAgent, ip string) (Session, error) {
 	// 查找用户
+	s.mu.Lock()
+	stored := dummyPasswordHash
+	found := false
 	for _, user := range s.users {
-		if user.Username == username && user.Password == password {
-			// 生成会话令牌
-			token, err := generateToken()
-			if err != nil {
-				return Session{}, err
-			}
-
-			// 创建会话
-			session := Session{
-				Token:     token,
-				UserID:    user.ID,
-				Username:  user.Username,
-				IsAdmin:   user.IsAdmin,
-				ExpiresAt: time.Now().Add(24 * time.Hour), // 会话有效期24小时
-			}
+		if user.Username == username {
+			stored = user.Password
+			found = true
+			break
+		}
+	}
+	s.mu.Unlock()
 
-			// 存储会话
-			s.sessions[token] = session
+	// 校验密码比较耗时，不持有锁；用户不存在时也做一次校验，避免通过耗时判断用户名是否存在
+	ok, needsRehash := verifyPassword(stored, password)
+	if !found || !ok {
+		return Session{}, ErrInvalidCredentials
+	}
 
-			return session, nil
+	var newHash string
+	if needsRehash {
+		var err error
+		newHash, err = hashPassword(password)
+		if err != nil {
+			return Session{}, err
 		}
 	}
 
-	return Session{}, fmt.Errorf("用户名或密码错误")
-}
-
-// GetSession 获取会话信息
-func (s *UserStore) GetSession(token string) (Session, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	session, exists := s.sessions[token]
-	if !exists || time.Now().After(session.ExpiresAt) {
-		return Session{}, false
+	for i, user := range s.users {
+		// 校验期间密码可能已被修改
+		if user.Username != username || user.Password != stored {
+			continue
+		}
+
+		if user.Disabled {
+			return Session{}, ErrAccountDisabled
+		}
+
+		// 明文或旧参数的密码在登录成功后迁移为新的哈希
+		if newHash != "" {
+			s.users[i].Password = newHash
+			if err := s.persistUser(s.users[i]); err != nil {
+				return Session{}, err
+			}
+		}
+
+		// 启用了两步验证时，先不创建会话，等待用户输入验证码
+		if user.TOTPSecret != "" {
+			challenge, err := s.createLoginChallenge(user, userAgent, ip)
+			if err != nil {
+				return Session{}, err
+			}
+			return Session{}, &TwoFactorRequired{Challenge: challenge}
+		}
+
+		s.users[i].LastLoginAt = time.Now()
+		if err := s.persistUser(s.users[i]); err != nil {
+			return Session{}, err
+		}
+
+		return s.createSession(user, userAgent, ip)
 	}
 
-	return session, true
+	return Session{}, ErrInvalidCredentials
 }
 
 // Logout 用户登出
-func (s *UserStore) Logout(token string) {
+func (s *UserStore) Logout(token string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	delete(s.sessions, token)
+	return s.deleteSession(token)
 }
 
 // TodoStore 管理待办事项的存储
 type TodoStore struct {
-	mu     sync.Mutex
-	todos  []Todo
-	nextID int
+	mu         sync.Mutex
+	todos      []Todo
+	nextID     int
+	tags       []Tag // 所有用户的标签目录
+	nextTagID  int
+	lists      []TodoList // 所有用户的待办事项列表
+	nextListID int
+	storage    Storage
+	persister  *persister
 }
 
 // NewTodoStore 创建一个新的TodoStore
-func NewTodoStore() *TodoStore {
+func NewTodoStore(storage Storage, flushInterval time.Duration) *TodoStore {
 	store := &TodoStore{
-		todos:  make([]Todo, 0),
-		nextID: 1,
+		todos:      make([]Todo, 0),
+		nextID:     1,
+		tags:       make([]Tag, 0),
+		nextTagID:  1,
+		lists:      make([]TodoList, 0),
+		nextListID: 1,
+		storage:    storage,
+		persister:  newPersister("待办事项", storage.Sync, flushInterval),
+	}
+
+	// 从存储加载数据。加载失败时不能继续运行，否则新记录会复用已有的ID并覆盖原数据
+	todos, nextID, err := storage.LoadTodos()
+	if err != nil {
+		log.Fatalf("加载待办事项数据失败: %v", err)
 	}
+	store.todos = todos
+	store.nextID = nextID
 
-	// 尝试从文件加载数据
-	err := store.LoadFromFile()
+	tags, nextTagID, err := storage.LoadTags()
 	if err != nil {
-		log.Printf("加载待办事项数据失败: %v，将使用默认数据", err)
+		log.Fatalf("加载标签数据失败: %v", err)
 	}
+	store.tags = tags
+	store.nextTagID = nextTagID
+
+	lists, nextListID, err := storage.LoadLists()
+	if err != nil {
+		log.Fatalf("加载列表数据失败: %v", err)
+	}
+	store.lists = lists
+	store.nextListID = nextListID
 
 	return store
 }
 
-// GetAllByUserID 返回指定用户的所有待办事项
-func (s *TodoStore) GetAllByUserID(userID int, includeDeleted bool) []Todo {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// 获取用户名
-	username := getUsernameByID(userID)
-
-	userTodos := make([]Todo, 0)
-	for _, todo := range s.todos {
-		if todo.UserID == userID {
-			// 根据includeDeleted参数决定是否包含已删除的待办事项
-			if !includeDeleted && todo.Deleted {
-				continue
-			}
-
-			// 确保待办事项有用户名
-			todoCopy := todo
-			if todoCopy.Username == "" {
-				todoCopy.Username = username
-			}
-			userTodos = append(userTodos, todoCopy)
-		}
-	}
+// 保存待办事项到存储后端
+func (s *TodoStore) persistTodo(todo Todo) error {
+	return s.persister.save(func() error { return s.storage.PutTodo(todo) })
+}
 
-	return userTodos
+// Flush 等待所有待办事项的修改写入存储
+func (s *TodoStore) Flush() error {
+	return s.persister.Flush()
 }
 
-// GetAllTodos 返回所有待办事项，用于管理员
-func (s *TodoStore) GetAllTodos(includeDeleted bool) []Todo {
+// GetVisible 返回用户可以查看的所有待办事项：自己创建的、共享列表中的和指派给自己的。
+// 管理员可以查看所有用户的待办事项
+func (s *TodoStore) GetVisible(actor Actor, includeDeleted bool) []Todo {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// 返回所有待办事项的副本，并确保每个待办事项都有用户名
-	allTodos := make([]Todo, 0, len(s.todos))
+	// 返回待办事项的副本，并确保每个待办事项都有用户名
+	visible := make([]Todo, 0)
 
 	for _, todo := range s.todos {
 		// 根据includeDeleted参数决定是否包含已删除的待办事项
 		if !includeDeleted && todo.Deleted {
 			continue
 		}
+		if s.todoAccess(actor, todo) == ACCESS_NONE {
+			continue
+		}
 
 		// 创建副本并确保有用户名
 		todoCopy := todo
@@ -236,143 +413,207 @@ func (s *TodoStore) GetAllTodos(includeDeleted bool) []Todo {
 			todoCopy.Username = getUsernameByID(todo.UserID)
 		}
 
-		allTodos = append(allTodos, todoCopy)
+		visible = append(visible, todoCopy)
 	}
 
-	return allTodos
+	return s.withProgress(visible)
 }
 
-// Add 添加一个新的待办事项
-func (s *TodoStore) Add(userID int, title string, priority int) Todo {
+// Add 在指定列表中添加一个新的待办事项，列表可以是共享给用户的可编辑列表
+func (s *TodoStore) Add(actor Actor, input TodoInput) (Todo, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	userID := actor.UserID
+
+	// 子任务与父任务属于同一列表
+	if input.ParentID != 0 {
+		p, err := s.checkParent(input.ParentID, actor)
+		if err != nil {
+			return Todo{}, ValidationErrors{"parent_id": "父任务不存在"}
+		}
+		input.ListID = s.todos[p].ListID
+	}
+
+	// 检查列表是否存在、可编辑且未归档
+	if err := s.checkListWritable(input.ListID, actor); err != nil {
+		return Todo{}, err
+	}
+
 	// 获取用户名
 	username := getUsernameByID(userID)
 
-	// 计算新的排序顺序（放在最前面）
-	maxOrder := 0
-	for _, todo := range s.todos {
-		if todo.UserID == userID && !todo.Deleted && todo.Order > maxOrder {
-			maxOrder = todo.Order
-		}
+	tags, err := s.ensureTags(userID, input.Tags)
+	if err != nil {
+		return Todo{}, err
 	}
 
 	todo := Todo{
 		ID:        s.nextID,
 		UserID:    userID,
 		Username:  username,
-		Title:     title,
+		Title:     input.Title,
 		Completed: false,
 		Deleted:   false,
-		Priority:  priority,
-		Order:     maxOrder + 1,
+		Priority:  input.Priority,
+		Order:     s.nextOrder(userID, input.ListID, input.ParentID),
+		ListID:    input.ListID,
+		ParentID:  input.ParentID,
+		StartDate: input.StartDate,
+		DueDate:   input.DueDate,
+		Tags:      tags,
+	}
+
+	// 重复待办事项以自身作为系列的第一个实例
+	if input.Recurrence != nil {
+		recurrence := *input.Recurrence
+		if recurrence.Start == "" {
+			recurrence.Start = input.DueDate
+		}
+		todo.Recurrence = &recurrence
+		todo.SeriesID = todo.ID
+		todo.Occurrence = 1
 	}
 
 	s.todos = append(s.todos, todo)
 	s.nextID++
 
-	// 保存数据到文件
-	go s.SaveToFile()
+	// 保存数据
+	if err := s.persistTodo(todo); err != nil {
+		return Todo{}, err
+	}
 
-	return todo
+	return todo, nil
 }
 
 // Toggle 切换待办事项的完成状态
-func (s *TodoStore) Toggle(id int, userID int, isAdmin bool) (Todo, error) {
+func (s *TodoStore) Toggle(id int, actor Actor) (Todo, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for i, todo := range s.todos {
-		// 如果是管理员，可以操作任何待办事项
-		// 如果不是管理员，只能操作自己的待办事项
-		if todo.ID == id && (isAdmin || todo.UserID == userID) {
-			s.todos[i].Completed = !s.todos[i].Completed
+	i, err := s.authorizeTodo(id, actor, ACCESS_EDIT)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	s.todos[i].Completed = !s.todos[i].Completed
 
-			// 保存数据到文件
-			go s.SaveToFile()
+	// 保存数据
+	err = s.persistTodo(s.todos[i])
 
-			return s.todos[i], nil
-		}
+	if s.todos[i].Completed {
+		// 完成重复待办事项时生成下一次
+		err = errors.Join(err, s.spawnNextOccurrence(i))
+		// 完成父任务时同时完成所有子任务
+		err = errors.Join(err, s.completeDescendants(id))
+	} else {
+		// 重新打开子任务时，父任务也不再是已完成状态
+		err = errors.Join(err, s.reopenAncestors(i))
+	}
+	if err != nil {
+		return Todo{}, err
 	}
 
-	return Todo{}, fmt.Errorf("todo with ID %d not found or not owned by user", id)
+	return s.todos[i], nil
 }
 
 // MarkAsDeleted 将待办事项标记为已删除（进入已完成状态）
-func (s *TodoStore) MarkAsDeleted(id int, userID int, isAdmin bool) (Todo, error) {
+func (s *TodoStore) MarkAsDeleted(id int, actor Actor) (Todo, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for i, todo := range s.todos {
-		// 如果是管理员，可以操作任何待办事项
-		// 如果不是管理员，只能操作自己的待办事项
-		if todo.ID == id && (isAdmin || todo.UserID == userID) {
-			// 标记为已删除
-			s.todos[i].Deleted = true
-			// 同时标记为已完成
-			s.todos[i].Completed = true
+	i, err := s.authorizeTodo(id, actor, ACCESS_EDIT)
+	if err != nil {
+		return Todo{}, err
+	}
 
-			// 保存数据到文件
-			go s.SaveToFile()
+	// 标记为已删除
+	s.todos[i].Deleted = true
+	// 同时标记为已完成
+	s.todos[i].Completed = true
 
-			return s.todos[i], nil
-		}
+	// 保存数据
+	err = s.persistTodo(s.todos[i])
+
+	// 完成重复待办事项时生成下一次
+	err = errors.Join(err, s.spawnNextOccurrence(i))
+
+	// 子任务随父任务一起标记为已删除
+	err = errors.Join(err, s.markDescendantsDeleted(id))
+	if err != nil {
+		return Todo{}, err
 	}
 
-	return Todo{}, fmt.Errorf("todo with ID %d not found or not owned by user", id)
+	return s.todos[i], nil
 }
 
-// Delete 永久删除一个待办事项
-func (s *TodoStore) Delete(id int, userID int, isAdmin bool) error {
+// Delete 永久删除一个待办事项，只有创建者、所在列表的所有者和管理员可以永久删除
+func (s *TodoStore) Delete(id int, actor Actor) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for i, todo := range s.todos {
-		// 如果是管理员，可以操作任何待办事项
-		// 如果不是管理员，只能操作自己的待办事项
-		if todo.ID == id && (isAdmin || todo.UserID == userID) {
-			// 只有已标记为删除的待办事项才能被永久删除
-			if !todo.Deleted {
-				return fmt.Errorf("todo with ID %d must be marked as deleted first", id)
-			}
+	i, err := s.authorizeTodo(id, actor, ACCESS_OWNER)
+	if err != nil {
+		return err
+	}
 
-			s.todos = append(s.todos[:i], s.todos[i+1:]...)
+	// 只有已标记为删除的待办事项才能被永久删除
+	if !s.todos[i].Deleted {
+		return fmt.Errorf("todo with ID %d must be marked as deleted first: %w", id, ErrConflict)
+	}
 
-			// 保存数据到文件
-			go s.SaveToFile()
+	// 子任务随父任务一起永久删除
+	removed := map[int]bool{id: true}
+	for _, k := range s.descendants(id) {
+		removed[s.todos[k].ID] = true
+	}
 
-			return nil
+	remaining := make([]Todo, 0, len(s.todos)-len(removed))
+	for _, t := range s.todos {
+		if !removed[t.ID] {
+			remaining = append(remaining, t)
 		}
 	}
+	s.todos = remaining
+
+	// 保存数据
+	for removedID := range removed {
+		removedID := removedID
+		err = errors.Join(err, s.persister.save(func() error { return s.storage.DeleteTodo(removedID) }))
+	}
 
-	return fmt.Errorf("todo with ID %d not found or not owned by user", id)
+	return err
 }
 
-// UpdateOrder 更新待办事项的排序顺序
-func (s *TodoStore) UpdateOrder(id int, order int, userID int) (Todo, error) {
+// UpdateOrder 把待办事项移动到列表中的第order个位置（从0开始），并重新为列表编号。
+// listID不为nil时先把待办事项移到该列表
+func (s *TodoStore) UpdateOrder(id int, order int, listID *int, actor Actor) (Todo, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	var todoIndex = -1
-
 	// 查找待办事项
-	for i, t := range s.todos {
-		if t.ID == id && t.UserID == userID && !t.Deleted {
-			todoIndex = i
-			break
-		}
+	todoIndex, err := s.authorizeTodo(id, actor, ACCESS_EDIT)
+	if err != nil {
+		return Todo{}, err
 	}
-
-	if todoIndex == -1 {
-		return Todo{}, fmt.Errorf("todo with ID %d not found or not owned by user", id)
+	if s.todos[todoIndex].Deleted {
+		return Todo{}, fmt.Errorf("todo with ID %d is deleted: %w", id, ErrConflict)
 	}
 
-	// 更新排序顺序
-	s.todos[todoIndex].Order = order
+	// 移动到其他列表，子任务跟随移动；单独移动的子任务变为顶层待办事项
+	if listID != nil && *listID != s.todos[todoIndex].ListID {
+		if err := s.checkListWritable(*listID, actor); err != nil {
+			return Todo{}, err
+		}
+		s.todos[todoIndex].ListID = *listID
+		s.todos[todoIndex].ParentID = 0
+		err = s.moveDescendants(id, *listID)
+	}
 
-	// 保存数据到文件
-	go s.SaveToFile()
+	// 更新排序顺序，同时保存数据
+	if err := errors.Join(err, s.reorderList(todoIndex, order)); err != nil {
+		return Todo{}, err
+	}
 
 	return s.todos[todoIndex], nil
 }
@@ -391,6 +632,20 @@ func getUsernameByID(userID int) string {
 	return "未知用户"
 }
 
+// 通过用户名获取用户ID
+func getUserIDByUsername(username string) (int, bool) {
+	userStore.mu.Lock()
+	defer userStore.mu.Unlock()
+
+	for _, user := range userStore.users {
+		if user.Username == username {
+			return user.ID, true
+		}
+	}
+
+	return 0, false
+}
+
 // 生成随机令牌
 func generateToken() (string, error) {
 	b := make([]byte, 32)
@@ -430,91 +685,40 @@ type BlogStore struct {
 	blogs         []Blog
 	nextID        int
 	nextCommentID int
+	storage       Storage
+	persister     *persister
 }
 
 // NewBlogStore 创建一个新的BlogStore
-func NewBlogStore() *BlogStore {
+func NewBlogStore(storage Storage, flushInterval time.Duration) *BlogStore {
 	store := &BlogStore{
 		blogs:         make([]Blog, 0),
 		nextID:        1,
 		nextCommentID: 1,
+		storage:       storage,
+		persister:     newPersister("博客", storage.Sync, flushInterval),
 	}
 
-	// 尝试从文件加载数据
-	err := store.LoadFromFile()
+	// 从存储加载数据。加载失败时不能继续运行，否则新记录会复用已有的ID并覆盖原数据
+	blogs, nextID, nextCommentID, err := storage.LoadBlogs()
 	if err != nil {
-		log.Printf("加载博客数据失败: %v，将使用默认数据", err)
+		log.Fatalf("加载博客数据失败: %v", err)
 	}
+	store.blogs = blogs
+	store.nextID = nextID
+	store.nextCommentID = nextCommentID
 
 	return store
 }
 
-// SaveToFile 保存博客数据到文件
-func (s *BlogStore) SaveToFile() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// 确保数据目录存在
-	if err := ensureDataDir(); err != nil {
-		return err
-	}
-
-	// 创建要保存的数据结构
-	data := struct {
-		Blogs         []Blog `json:"blogs"`
-		NextID        int    `json:"next_id"`
-		NextCommentID int    `json:"next_comment_id"`
-	}{s.blogs, s.nextID, s.nextCommentID}
-
-	// 将数据编码为JSON
-	jsonData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	// 写入文件
-	return os.WriteFile("data/blogs.json", jsonData, 0644)
+// 保存博客到存储后端
+func (s *BlogStore) persistBlog(blog Blog) error {
+	return s.persister.save(func() error { return s.storage.PutBlog(blog) })
 }
 
-// LoadFromFile 从文件加载博客数据
-func (s *BlogStore) LoadFromFile() error {
-	// 确保数据目录存在
-	if err := ensureDataDir(); err != nil {
-		return err
-	}
-
-	// 检查文件是否存在
-	if _, err := os.Stat(BLOGS_FILE); os.IsNotExist(err) {
-		// 文件不存在，使用默认数据
-		return nil
-	}
-
-	// 读取文件
-	jsonData, err := os.ReadFile("data/blogs.json")
-	if err != nil {
-		return err
-	}
-
-	// 解码JSON数据
-	var data struct {
-		Blogs         []Blog `json:"blogs"`
-		NextID        int    `json:"next_id"`
-		NextCommentID int    `json:"next_comment_id"`
-	}
-
-	if err := json.Unmarshal(jsonData, &data); err != nil {
-		return err
-	}
-
-	// 更新存储
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	s.blogs = data.Blogs
-	s.nextID = data.NextID
-	s.nextCommentID = data.NextCommentID
-
-	return nil
+// Flush 等待所有博客和评论的修改写入存储
+func (s *BlogStore) Flush() error {
+	return s.persister.Flush()
 }
 
 // GetAllBlogs 返回所有公开博客
@@ -561,7 +765,7 @@ func (s *BlogStore) GetBlogByID(id int, currentUserID int) (Blog, error) {
 		if blog.ID == id {
 			// 如果是私有博客，只有作者本人可以查看
 			if blog.IsPrivate && blog.UserID != currentUserID {
-				return Blog{}, fmt.Errorf("blog with ID %d is private", id)
+				return Blog{}, fmt.Errorf("blog with ID %d: %w", id, ErrNotFound)
 			}
 
 			// 创建副本
@@ -570,11 +774,11 @@ func (s *BlogStore) GetBlogByID(id int, currentUserID int) (Blog, error) {
 		}
 	}
 
-	return Blog{}, fmt.Errorf("blog with ID %d not found", id)
+	return Blog{}, fmt.Errorf("blog with ID %d: %w", id, ErrNotFound)
 }
 
 // AddBlog 添加一篇新博客
-func (s *BlogStore) AddBlog(userID int, title, content string, isPrivate bool) Blog {
+func (s *BlogStore) AddBlog(userID int, title, content string, isPrivate bool) (Blog, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -596,10 +800,12 @@ func (s *BlogStore) AddBlog(userID int, title, content string, isPrivate bool) B
 	s.blogs = append(s.blogs, blog)
 	s.nextID++
 
-	// 保存数据到文件
-	go s.SaveToFile()
+	// 保存数据
+	if err := s.persistBlog(blog); err != nil {
+		return Blog{}, err
+	}
 
-	return blog
+	return blog, nil
 }
 
 // UpdateBlog 更新博客
@@ -611,7 +817,7 @@ func (s *BlogStore) UpdateBlog(id, userID int, title, content string, isPrivate
 		if blog.ID == id {
 			// 只有作者本人可以更新博客
 			if blog.UserID != userID {
-				return Blog{}, fmt.Errorf("only the author can update the blog")
+				return Blog{}, fmt.Errorf("only the author can update the blog: %w", ErrForbidden)
 			}
 
 			// 更新博客
@@ -620,14 +826,16 @@ func (s *BlogStore) UpdateBlog(id, userID int, title, content string, isPrivate
 			s.blogs[i].IsPrivate = isPrivate
 			s.blogs[i].UpdatedAt = time.Now()
 
-			// 保存数据到文件
-			go s.SaveToFile()
+			// 保存数据
+			if err := s.persistBlog(s.blogs[i]); err != nil {
+				return Blog{}, err
+			}
 
 			return s.blogs[i], nil
 		}
 	}
 
-	return Blog{}, fmt.Errorf("blog with ID %d not found", id)
+	return Blog{}, fmt.Errorf("blog with ID %d: %w", id, ErrNotFound)
 }
 
 // DeleteBlog 删除博客
@@ -639,20 +847,18 @@ func (s *BlogStore) DeleteBlog(id, userID int) error {
 		if blog.ID == id {
 			// 只有作者本人可以删除博客
 			if blog.UserID != userID {
-				return fmt.Errorf("only the author can delete the blog")
+				return fmt.Errorf("only the author can delete the blog: %w", ErrForbidden)
 			}
 
 			// 删除博客
 			s.blogs = append(s.blogs[:i], s.blogs[i+1:]...)
 
-			// 保存数据到文件
-			go s.SaveToFile()
-
-			return nil
+			// 保存数据
+			return s.persister.save(func() error { return s.storage.DeleteBlog(id) })
 		}
 	}
 
-	return fmt.Errorf("blog with ID %d not found", id)
+	return fmt.Errorf("blog with ID %d: %w", id, ErrNotFound)
 }
 
 // AddComment 添加评论
@@ -670,12 +876,12 @@ func (s *BlogStore) AddComment(blogID, userID int, content string) (Comment, err
 	}
 
 	if blogIndex == -1 {
-		return Comment{}, fmt.Errorf("blog with ID %d not found", blogID)
+		return Comment{}, fmt.Errorf("blog with ID %d: %w", blogID, ErrNotFound)
 	}
 
 	// 如果是私有博客，只有作者本人可以评论
 	if s.blogs[blogIndex].IsPrivate && s.blogs[blogIndex].UserID != userID {
-		return Comment{}, fmt.Errorf("cannot comment on private blog")
+		return Comment{}, fmt.Errorf("cannot comment on private blog: %w", ErrForbidden)
 	}
 
 	// 获取用户名
@@ -695,14 +901,16 @@ func (s *BlogStore) AddComment(blogID, userID int, content string) (Comment, err
 	s.blogs[blogIndex].Comments = append(s.blogs[blogIndex].Comments, comment)
 	s.nextCommentID++
 
-	// 保存数据到文件
-	go s.SaveToFile()
+	// 保存数据
+	if err := s.persister.save(func() error { return s.storage.PutComment(comment) }); err != nil {
+		return Comment{}, err
+	}
 
 	return comment, nil
 }
 
-// DeleteComment 删除评论
-func (s *BlogStore) DeleteComment(blogID, commentID, userID int) error {
+// DeleteComment 删除评论，moderator为true时可以删除任何评论
+func (s *BlogStore) DeleteComment(blogID, commentID, userID int, moderator bool) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -716,7 +924,7 @@ func (s *BlogStore) DeleteComment(blogID, commentID, userID int) error {
 	}
 
 	if blogIndex == -1 {
-		return fmt.Errorf("blog with ID %d not found", blogID)
+		return fmt.Errorf("blog with ID %d: %w", blogID, ErrNotFound)
 	}
 
 	// 查找评论
@@ -729,13 +937,13 @@ func (s *BlogStore) DeleteComment(blogID, commentID, userID int) error {
 	}
 
 	if commentIndex == -1 {
-		return fmt.Errorf("comment with ID %d not found", commentID)
+		return fmt.Errorf("comment with ID %d: %w", commentID, ErrNotFound)
 	}
 
-	// 只有评论作者或博客作者可以删除评论
+	// 只有评论作者、博客作者或版主可以删除评论
 	comment := s.blogs[blogIndex].Comments[commentIndex]
-	if comment.UserID != userID && s.blogs[blogIndex].UserID != userID {
-		return fmt.Errorf("only the comment author or blog author can delete the comment")
+	if !moderator && comment.UserID != userID && s.blogs[blogIndex].UserID != userID {
+		return fmt.Errorf("only the comment author or blog author can delete the comment: %w", ErrForbidden)
 	}
 
 	// 删除评论
@@ -744,162 +952,259 @@ func (s *BlogStore) DeleteComment(blogID, commentID, userID int) error {
 		s.blogs[blogIndex].Comments[commentIndex+1:]...,
 	)
 
-	// 保存数据到文件
-	go s.SaveToFile()
-
-	return nil
+	// 保存数据
+	return s.persister.save(func() error { return s.storage.DeleteComment(blogID, commentID) })
 }
 
 var (
-	userStore = NewUserStore()
-	todoStore = NewTodoStore()
-	blogStore = NewBlogStore()
+	userStore *UserStore
+	todoStore *TodoStore
+	blogStore *BlogStore
 	templates = template.Must(template.ParseGlob("templates/*.html"))
 )
 
-// 中间件：检查用户是否已登录
+// 中间件：检查用户是否已登录。浏览器通过会话Cookie认证，脚本和命令行工具通过Bearer访问令牌认证
 func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// 从Cookie中获取会话令牌
-		cookie, err := r.Cookie("session_token")
-		if err != nil {
-			// 未找到会话令牌，重定向到登录页面
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		// 身份只能来自会话或访问令牌，忽略客户端发送的身份请求头
+		stripIdentityHeaders(r.Header)
+
+		// 认证失败时，API客户端返回JSON格式的401，浏览器重定向到登录页面
+		deny := func(message string) {
+			if isAPIRequest(r) {
+				writeProblem(w, r, http.StatusUnauthorized, message)
+			} else {
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+			}
+		}
+
+		var identity Identity
+		if plain, ok := bearerToken(r); ok {
+			// 验证访问令牌
+			token, err := userStore.AuthenticateToken(plain)
+			if err != nil {
+				deny(err.Error())
+				return
+			}
+			identity = Identity{UserID: token.UserID, TokenID: token.ID, TokenScope: token.Scope}
+		} else {
+			// 从Cookie中获取会话令牌
+			cookie, err := r.Cookie("session_token")
+			if err != nil {
+				deny("未登录")
+				return
+			}
+
+			// 验证会话令牌，用户有活动时顺延会话有效期
+			session, renewed, valid := userStore.TouchSession(cookie.Value, clientIP(r))
+			if !valid {
+				deny("会话已过期，请重新登录")
+				return
+			}
+			if renewed {
+				setSessionCookie(w, session)
+			}
+			identity = Identity{UserID: session.UserID}
+		}
+
+		// 每个请求都读取用户当前的状态，禁用账号或修改角色后立即生效
+		user, err := userStore.GetUser(identity.UserID)
+		if err != nil || user.Disabled {
+			deny("账号不存在或已被禁用")
 			return
 		}
 
-		// 验证会话令牌
-		session, valid := userStore.GetSession(cookie.Value)
-		if !valid {
-			// 会话无效，重定向到登录页面
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		// 管理员重置密码后，必须先修改密码才能访问其他页面
+		if user.MustChangePassword && r.URL.Path != "/change-password" {
+			if isAPIRequest(r) {
+				writeProblem(w, r, http.StatusForbidden, "请先修改密码")
+			} else {
+				http.Redirect(w, r, "/change-password", http.StatusSeeOther)
+			}
+			return
+		}
+
+		// 只读令牌只能发送只读请求
+		if identity.TokenScope == TOKEN_SCOPE_READ && !isSafeMethod(r.Method) {
+			writeProblem(w, r, http.StatusForbidden, "访问令牌没有写入权限")
 			return
 		}
 
 		// 将用户信息存储在请求上下文中
-		r.Header.Set("X-User-ID", strconv.Itoa(session.UserID))
-		r.Header.Set("X-Username", session.Username)
-		r.Header.Set("X-Is-Admin", strconv.FormatBool(session.IsAdmin))
+		identity.Username = user.Username
+		identity.Roles = user.Roles
+		r = withIdentity(r, identity)
 
 		// 调用下一个处理函数
 		next(w, r)
 	}
 }
 
-// 获取当前用户ID
-func getCurrentUserID(r *http.Request) (int, error) {
-	userIDStr := r.Header.Get("X-User-ID")
-	if userIDStr == "" {
-		return 0, fmt.Errorf("未找到用户ID")
-	}
-
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil {
-		return 0, err
-	}
-
-	return userID, nil
-}
-
 // 添加API路由获取当前用户信息
 func handleCurrentUser(w http.ResponseWriter, r *http.Request) {
-	userID, err := getCurrentUserID(r)
+	actor, err := currentActor(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
-	username := r.Header.Get("X-Username")
-	isAdminStr := r.Header.Get("X-Is-Admin")
-	isAdmin, _ := strconv.ParseBool(isAdminStr)
+	username := currentUsername(r)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":       userID,
-		"username": username,
-		"is_admin": isAdmin,
+		"id":          actor.UserID,
+		"username":    username,
+		"is_admin":    hasRole(actor.Roles, ROLE_ADMIN),
+		"roles":       actor.Roles,
+		"permissions": rolesPermissions(actor.Roles),
 	})
 }
 
 func main() {
-	// 设置优雅关闭
-	quit := make(chan struct{})
-	var wg sync.WaitGroup
-
-	// 启动自动保存
-	startAutoSave(&wg, quit)
+	// 读取配置
+	config, printConfig, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("配置无效: %v", err)
+	}
+	if printConfig {
+		if err := writeConfig(os.Stdout, config); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	passwordPolicy = config.Password
+	loginLimits = config.Login
+	sessionTTL = config.SessionTTL
+	cookieConfig = config.Cookie
+	if config.TLS.Enabled() {
+		// 使用HTTPS时Cookie总是只通过HTTPS发送
+		cookieConfig.Secure = true
+	}
+	features = config.Features
 
-	// 捕获系统信号
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		fmt.Println("\n正在关闭服务器...")
+	// 打开审计日志
+	auditLog, err = openAuditLog(config.AuditLog)
+	if err != nil {
+		log.Fatalf("打开审计日志失败: %v", err)
+	}
 
-		// 停止自动保存
-		close(quit)
+	// 按配置创建登录保护，定期清理过期的计数
+	background := newBackgroundTasks()
+	loginGuard = newLoginGuard(loginLimits)
+	background.Go(func(ctx context.Context) { loginGuard.reap(ctx, RATE_LIMIT_REAP_INTERVAL) })
 
-		// 等待所有goroutine完成
-		wg.Wait()
+	// 启用单点登录
+	if config.OIDC.Enabled() {
+		oidcProvider = NewOIDCProvider(config.OIDC)
+		log.Printf("已启用单点登录: %s", config.OIDC.Issuer)
+	}
 
-		// 保存数据
-		fmt.Println("正在保存数据...")
-		if err := userStore.SaveToFile(); err != nil {
-			log.Printf("保存用户数据失败: %v\n", err)
+	// 打开存储后端并加载数据
+	storage, err := openStorage(config.Storage, config.DataDir, config.SQLitePath)
+	if err != nil {
+		log.Fatalf("打开存储失败: %v", err)
+	}
+	userStore = NewUserStore(storage, config.FlushInterval)
+	todoStore = NewTodoStore(storage, config.FlushInterval)
+	blogStore = NewBlogStore(storage, config.FlushInterval)
+
+	// 定期清理过期会话
+	background.Go(func(ctx context.Context) { userStore.reapSessions(ctx, SESSION_REAP_INTERVAL) })
+
+	// 创建HTTP服务器
+	handler := csrfMiddleware(newRouter())
+	server := newHTTPServer(config.Addr, handler, config)
+	serve := server.ListenAndServe
+	servers := []*http.Server{server}
+
+	if config.TLS.Enabled() {
+		// 读取证书，收到SIGHUP时重新读取，自签名证书即将过期时同时重新生成
+		var certs *certReloader
+		if config.TLS.SelfSigned {
+			certs, err = newSelfSignedCertReloader(config.DataDir)
+		} else {
+			certs, err = newCertReloader(config.TLS.CertFile, config.TLS.KeyFile)
 		}
-		if err := todoStore.SaveToFile(); err != nil {
-			log.Printf("保存待办事项数据失败: %v\n", err)
+		if err != nil {
+			log.Fatalf("读取证书失败: %v", err)
 		}
-		if err := blogStore.SaveToFile(); err != nil {
-			log.Printf("保存博客数据失败: %v\n", err)
+		go certs.watchSIGHUP()
+
+		server.Handler = hstsMiddleware(config.TLS.HSTSMaxAge, handler)
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
 		}
+		serve = func() error { return server.ListenAndServeTLS("", "") }
+	}
 
-		fmt.Println("服务器已安全关闭")
-		os.Exit(0)
-	}()
+	// 启动服务器
+	serverErr := make(chan error, 2)
+	go func() { serverErr <- serve() }()
 
-	// 静态文件服务
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	// 把HTTP请求重定向到HTTPS
+	if config.TLS.RedirectAddr != "" {
+		redirect := newHTTPServer(config.TLS.RedirectAddr, redirectToHTTPS(config.Addr), config)
+		servers = append(servers, redirect)
+		go func() { serverErr <- redirect.ListenAndServe() }()
+	}
 
-	// 用户相关路由
-	http.HandleFunc("/register", handleRegister)
-	http.HandleFunc("/login", handleLogin)
-	http.HandleFunc("/logout", handleLogout)
+	scheme := "http"
+	if config.TLS.Enabled() {
+		scheme = "https"
+	}
+	host, port, _ := net.SplitHostPort(config.Addr)
+	if host == "" {
+		host = "localhost"
+	}
+	fmt.Printf("服务器启动在 %s://%s\n", scheme, net.JoinHostPort(host, port))
 
-	// 待办事项 API 路由（需要认证）
-http.HandleFunc("/api/current-user", authMiddleware(handleCurrentUser))
-http.HandleFunc("/api/todos", authMiddleware(handleTodos))
-http.HandleFunc("/api/completed-todos", authMiddleware(handleCompletedTodos))
-http.HandleFunc("/api/todos/toggle/", authMiddleware(handleToggleTodo))
-http.HandleFunc("/api/todos/mark-deleted/", authMiddleware(handleMarkTodoAsDeleted))
-http.HandleFunc("/api/todos/delete/", authMiddleware(handleDeleteTodo))
-http.HandleFunc("/api/todos/update-order", authMiddleware(handleUpdateTodoOrder))
+	// 等待退出信号，服务器异常退出时同样保存数据后再退出
+	exitCode := 0
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-sigChan:
+		fmt.Println("\n正在关闭服务器...")
+	case err := <-serverErr:
+		log.Printf("服务器异常退出: %v", err)
+		exitCode = 1
+	}
 
-	// 博客 API 路由（需要认证）
-	http.HandleFunc("/api/blogs", authMiddleware(handleBlogs))
-	http.HandleFunc("/api/blogs/", authMiddleware(handleBlog))
-	http.HandleFunc("/api/blogs/user/", authMiddleware(handleUserBlogs))
-	http.HandleFunc("/api/blogs/comments/", authMiddleware(handleBlogComments))
+	// 停止接受新请求，等待正在处理的请求完成，再停止后台清理任务，之后不会再有修改
+	shutdownServers(servers, config.ShutdownTimeout)
+	background.Stop()
 
-	// 页面路由
-	http.HandleFunc("/", authMiddleware(handleIndex))
-	http.HandleFunc("/blogs", authMiddleware(handleBlogsPage))
-	http.HandleFunc("/blogs/", authMiddleware(handleBlogPage))
-	http.HandleFunc("/blogs/new", authMiddleware(handleNewBlogPage))
-	http.HandleFunc("/blogs/edit/", authMiddleware(handleEditBlogPage))
+	// 等待尚未写入的修改落盘
+	fmt.Println("正在保存数据...")
+	if err := userStore.Flush(); err != nil {
+		log.Printf("保存用户数据失败: %v\n", err)
+	}
+	if err := todoStore.Flush(); err != nil {
+		log.Printf("保存待办事项数据失败: %v\n", err)
+	}
+	if err := blogStore.Flush(); err != nil {
+		log.Printf("保存博客数据失败: %v\n", err)
+	}
+	if err := storage.Close(); err != nil {
+		log.Printf("关闭存储失败: %v\n", err)
+	}
+	if err := auditLog.Close(); err != nil {
+		log.Printf("关闭审计日志失败: %v\n", err)
+	}
 
-	// 启动服务器
-	fmt.Println("服务器启动在 http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":9090", nil))
+	fmt.Println("服务器已安全关闭")
+	os.Exit(exitCode)
 }
 
 func handleIndex(w http.ResponseWriter, r *http.Request) {
 	// 获取当前用户名
-	username := r.Header.Get("X-Username")
+	username := currentUsername(r)
 
 	// 传递用户名到模板
 	data := map[string]interface{}{
-		"Username": username,
+		"Username":     username,
+		"BlogsEnabled": features.Blogs,
 	}
 
 	err := templates.ExecuteTemplate(w, "index.html", data)
@@ -908,20 +1213,29 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// 处理已完成待办事项页面
+func handleCompletedPage(w http.ResponseWriter, r *http.Request) {
+	// 传递用户名到模板
+	data := map[string]interface{}{
+		"Username": currentUsername(r),
+	}
+
+	err := templates.ExecuteTemplate(w, "completed.html", data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func handleTodos(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// 获取当前用户ID
-	userID, err := getCurrentUserID(r)
+	// 获取当前用户
+	actor, err := currentActor(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
-	// 检查用户是否为管理员
-	isAdminStr := r.Header.Get("X-Is-Admin")
-	isAdmin, _ := strconv.ParseBool(isAdminStr)
-
 	// 默认不包含已删除的待办事项
 	includeDeleted := false
 
@@ -932,32 +1246,76 @@ func handleTodos(w http.ResponseWriter, r *http.Request) {
 	}
 
 	switch r.Method {
-	case http.MethodGet:
-		// 如果是管理员，获取所有用户的待办事项
-		if isAdmin {
-			json.NewEncoder(w).Encode(todoStore.GetAllTodos(includeDeleted))
+	case http.MethodGet, http.MethodHead:
+		// 解析截止日期相关的筛选条件
+		filter, err := parseDueFilter(r.URL.Query())
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// 管理员获取所有用户的待办事项，其他用户获取自己可以查看的待办事项
+		todos := todoStore.GetVisible(actor, includeDeleted)
+
+		// assigned=me时只返回指派给当前用户的待办事项
+		switch r.URL.Query().Get("assigned") {
+		case "":
+		case "me":
+			todos = filterAssignedTo(todos, actor.UserID)
+		default:
+			writeProblem(w, r, http.StatusBadRequest, "Invalid assigned filter")
+			return
+		}
+
+		// 指定list_id时只返回该列表（0为默认列表），否则返回未归档列表中的待办事项
+		if listIDStr := r.URL.Query().Get("list_id"); listIDStr != "" {
+			listID, err := strconv.Atoi(listIDStr)
+			if err != nil {
+				writeProblem(w, r, http.StatusBadRequest, "Invalid list ID")
+				return
+			}
+			todos = filterByList(todos, listID)
 		} else {
-			// 否则只获取当前用户的待办事项
-			json.NewEncoder(w).Encode(todoStore.GetAllByUserID(userID, includeDeleted))
+			todos = todoStore.ExcludeArchived(todos)
 		}
 
+		todos = parseTagFilter(r.URL.Query()).apply(filter.apply(todos))
+
+		// aggregate=tags时同时返回每个标签的待办事项数量
+		if r.URL.Query().Get("aggregate") == "tags" {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"todos":      todos,
+				"tag_counts": countTags(todos),
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(todos)
+
 	case http.MethodPost:
-		var todo struct {
-			Title    string `json:"title"`
-			Priority int    `json:"priority"`
+		var input TodoInput
+
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, r, ValidationErrors{"body": err.Error()})
+			return
 		}
 
-		if err := json.NewDecoder(r.Body).Decode(&todo); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		// 按与PATCH相同的规则检查各字段
+		if errs := input.Validate(); errs != nil {
+			writeError(w, r, errs)
 			return
 		}
 
 		// 添加待办事项，关联到当前用户
-		newTodo := todoStore.Add(userID, todo.Title, todo.Priority)
+		newTodo, err := todoStore.Add(actor, input)
+		if errors.Is(err, ErrListArchived) {
+			writeError(w, r, ValidationErrors{"list_id": "列表已归档"})
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 		json.NewEncoder(w).Encode(newTodo)
-
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
@@ -965,75 +1323,41 @@ func handleTodos(w http.ResponseWriter, r *http.Request) {
 func handleCompletedTodos(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// 获取当前用户ID
-	userID, err := getCurrentUserID(r)
+	// 获取当前用户
+	actor, err := currentActor(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
-	// 检查用户是否为管理员
-	isAdminStr := r.Header.Get("X-Is-Admin")
-	isAdmin, _ := strconv.ParseBool(isAdminStr)
-
-	switch r.Method {
-	case http.MethodGet:
-		// 获取已删除（已完成）的待办事项
-		if isAdmin {
-			// 管理员可以查看所有用户的已完成待办事项
-			var completedTodos []Todo
-			allTodos := todoStore.GetAllTodos(true)
-			for _, todo := range allTodos {
-				if todo.Deleted {
-					completedTodos = append(completedTodos, todo)
-				}
-			}
-			json.NewEncoder(w).Encode(completedTodos)
-		} else {
-			// 普通用户只能查看自己的已完成待办事项
-			var completedTodos []Todo
-			userTodos := todoStore.GetAllByUserID(userID, true)
-			for _, todo := range userTodos {
-				if todo.Deleted {
-					completedTodos = append(completedTodos, todo)
-				}
-			}
-			json.NewEncoder(w).Encode(completedTodos)
+	// 获取已删除（已完成）的待办事项，管理员可以查看所有用户的已完成待办事项
+	var completedTodos []Todo
+	for _, todo := range todoStore.GetVisible(actor, true) {
+		if todo.Deleted {
+			completedTodos = append(completedTodos, todo)
 		}
-
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
+	json.NewEncoder(w).Encode(completedTodos)
 }
 
 func handleToggleTodo(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// 获取当前用户ID
-	userID, err := getCurrentUserID(r)
+	// 获取当前用户
+	actor, err := currentActor(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
-	// 检查用户是否为管理员
-	isAdminStr := r.Header.Get("X-Is-Admin")
-	isAdmin, _ := strconv.ParseBool(isAdminStr)
-
-	idStr := r.URL.Path[len("/api/todos/toggle/"):]
-	id, err := strconv.Atoi(idStr)
+	id, err := pathInt(r, "id")
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
 		return
 	}
 
 	// 切换待办事项状态，管理员可以操作所有待办事项
-	todo, err := todoStore.Toggle(id, userID, isAdmin)
+	todo, err := todoStore.Toggle(id, actor)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
@@ -1042,33 +1366,23 @@ func handleToggleTodo(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleMarkTodoAsDeleted(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// 获取当前用户ID
-	userID, err := getCurrentUserID(r)
+	// 获取当前用户
+	actor, err := currentActor(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
-	// 检查用户是否为管理员
-	isAdminStr := r.Header.Get("X-Is-Admin")
-	isAdmin, _ := strconv.ParseBool(isAdminStr)
-
-	idStr := r.URL.Path[len("/api/todos/mark-deleted/"):]
-	id, err := strconv.Atoi(idStr)
+	id, err := pathInt(r, "id")
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
 		return
 	}
 
 	// 标记待办事项为已删除（已完成），管理员可以操作所有待办事项
-	todo, err := todoStore.MarkAsDeleted(id, userID, isAdmin)
+	todo, err := todoStore.MarkAsDeleted(id, actor)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
@@ -1083,12 +1397,12 @@ func handleBlogs(w http.ResponseWriter, r *http.Request) {
 	// 获取当前用户ID
 	userID, err := getCurrentUserID(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
 	switch r.Method {
-	case http.MethodGet:
+	case http.MethodGet, http.MethodHead:
 		// 获取所有公开博客
 		json.NewEncoder(w).Encode(blogStore.GetAllBlogs())
 
@@ -1101,16 +1415,17 @@ func handleBlogs(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&blog); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
 
 		// 添加博客，关联到当前用户
-		newBlog := blogStore.AddBlog(userID, blog.Title, blog.Content, blog.IsPrivate)
+		newBlog, err := blogStore.AddBlog(userID, blog.Title, blog.Content, blog.IsPrivate)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 		json.NewEncoder(w).Encode(newBlog)
-
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
@@ -1121,24 +1436,23 @@ func handleBlog(w http.ResponseWriter, r *http.Request) {
 	// 获取当前用户ID
 	userID, err := getCurrentUserID(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
 	// 获取博客ID
-	idStr := r.URL.Path[len("/api/blogs/"):]
-	id, err := strconv.Atoi(idStr)
+	id, err := pathInt(r, "id")
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
 		return
 	}
 
 	switch r.Method {
-	case http.MethodGet:
+	case http.MethodGet, http.MethodHead:
 		// 获取单个博客
 		blog, err := blogStore.GetBlogByID(id, userID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			writeError(w, r, err)
 			return
 		}
 		json.NewEncoder(w).Encode(blog)
@@ -1152,14 +1466,14 @@ func handleBlog(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&blogUpdate); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
 
 		// 更新博客
 		updatedBlog, err := blogStore.UpdateBlog(id, userID, blogUpdate.Title, blogUpdate.Content, blogUpdate.IsPrivate)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			writeError(w, r, err)
 			return
 		}
 		json.NewEncoder(w).Encode(updatedBlog)
@@ -1168,13 +1482,10 @@ func handleBlog(w http.ResponseWriter, r *http.Request) {
 		// 删除博客
 		err := blogStore.DeleteBlog(id, userID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			writeError(w, r, err)
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
-
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
@@ -1185,49 +1496,38 @@ func handleUserBlogs(w http.ResponseWriter, r *http.Request) {
 	// 获取当前用户ID
 	currentUserID, err := getCurrentUserID(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
 	// 获取目标用户ID
-	idStr := r.URL.Path[len("/api/blogs/user/"):]
-	userID, err := strconv.Atoi(idStr)
+	userID, err := pathInt(r, "id")
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
 		return
 	}
 
-	if r.Method == http.MethodGet {
-		// 获取用户的博客
-		blogs := blogStore.GetBlogsByUserID(userID, currentUserID)
-		json.NewEncoder(w).Encode(blogs)
-	} else {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
+	// 获取用户的博客
+	blogs := blogStore.GetBlogsByUserID(userID, currentUserID)
+	json.NewEncoder(w).Encode(blogs)
 }
 
 // 处理博客评论的请求
 func handleBlogComments(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// 获取当前用户ID
-	userID, err := getCurrentUserID(r)
+	// 获取当前用户
+	actor, err := currentActor(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
-	}
-
-	// 解析路径
-	pathParts := strings.Split(r.URL.Path[len("/api/blogs/comments/"):], "/")
-	if len(pathParts) < 1 {
-		http.Error(w, "Invalid path", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
+	userID := actor.UserID
 
 	// 获取博客ID
-	blogID, err := strconv.Atoi(pathParts[0])
+	blogID, err := pathInt(r, "id")
 	if err != nil {
-		http.Error(w, "Invalid blog ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Invalid blog ID")
 		return
 	}
 
@@ -1239,49 +1539,40 @@ func handleBlogComments(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
 
 		// 添加评论
 		newComment, err := blogStore.AddComment(blogID, userID, comment.Content)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			writeError(w, r, err)
 			return
 		}
 		json.NewEncoder(w).Encode(newComment)
 
 	case http.MethodDelete:
-		// 删除评论
-		if len(pathParts) < 2 {
-			http.Error(w, "Invalid comment ID", http.StatusBadRequest)
-			return
-		}
-
 		// 获取评论ID
-		commentID, err := strconv.Atoi(pathParts[1])
+		commentID, err := pathInt(r, "comment_id")
 		if err != nil {
-			http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+			writeProblem(w, r, http.StatusBadRequest, "Invalid comment ID")
 			return
 		}
 
-		// 删除评论
-		err = blogStore.DeleteComment(blogID, commentID, userID)
+		// 删除评论，版主可以删除任何评论
+		err = blogStore.DeleteComment(blogID, commentID, userID, actor.Can(PERM_COMMENT_MODERATE))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			writeError(w, r, err)
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
-
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 // 处理博客页面
 func handleBlogsPage(w http.ResponseWriter, r *http.Request) {
 	// 获取当前用户名
-	username := r.Header.Get("X-Username")
+	username := currentUsername(r)
 
 	// 传递用户名到模板
 	data := map[string]interface{}{
@@ -1299,28 +1590,27 @@ func handleBlogPage(w http.ResponseWriter, r *http.Request) {
 	// 获取当前用户ID
 	userID, err := getCurrentUserID(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
 	// 获取博客ID
-	idStr := r.URL.Path[len("/blogs/"):]
-	id, err := strconv.Atoi(idStr)
+	id, err := pathInt(r, "id")
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
 		return
 	}
 
 	// 获取博客
 	blog, err := blogStore.GetBlogByID(id, userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
 	// 传递数据到模板
 	data := map[string]interface{}{
-		"Username": r.Header.Get("X-Username"),
+		"Username": currentUsername(r),
 		"Blog":     blog,
 		"UserID":   userID,
 	}
@@ -1334,7 +1624,7 @@ func handleBlogPage(w http.ResponseWriter, r *http.Request) {
 // 处理新建博客页面
 func handleNewBlogPage(w http.ResponseWriter, r *http.Request) {
 	// 获取当前用户名
-	username := r.Header.Get("X-Username")
+	username := currentUsername(r)
 
 	// 传递用户名到模板
 	data := map[string]interface{}{
@@ -1352,34 +1642,33 @@ func handleEditBlogPage(w http.ResponseWriter, r *http.Request) {
 	// 获取当前用户ID
 	userID, err := getCurrentUserID(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
 	// 获取博客ID
-	idStr := r.URL.Path[len("/blogs/edit/"):]
-	id, err := strconv.Atoi(idStr)
+	id, err := pathInt(r, "id")
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
 		return
 	}
 
 	// 获取博客
 	blog, err := blogStore.GetBlogByID(id, userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
 	// 检查是否为博客作者
 	if blog.UserID != userID {
-		http.Error(w, "Only the author can edit the blog", http.StatusForbidden)
+		writeProblem(w, r, http.StatusForbidden, "Only the author can edit the blog")
 		return
 	}
 
 	// 传递数据到模板
 	data := map[string]interface{}{
-		"Username": r.Header.Get("X-Username"),
+		"Username": currentUsername(r),
 		"Blog":     blog,
 	}
 
@@ -1390,33 +1679,23 @@ func handleEditBlogPage(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleDeleteTodo(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// 获取当前用户ID
-	userID, err := getCurrentUserID(r)
+	// 获取当前用户
+	actor, err := currentActor(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
-	// 检查用户是否为管理员
-	isAdminStr := r.Header.Get("X-Is-Admin")
-	isAdmin, _ := strconv.ParseBool(isAdminStr)
-
-	idStr := r.URL.Path[len("/api/todos/delete/"):]
-	id, err := strconv.Atoi(idStr)
+	id, err := pathInt(r, "id")
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
 		return
 	}
 
 	// 永久删除待办事项，管理员可以操作所有待办事项
-	err = todoStore.Delete(id, userID, isAdmin)
+	err = todoStore.Delete(id, actor)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
@@ -1425,33 +1704,29 @@ func handleDeleteTodo(w http.ResponseWriter, r *http.Request) {
 
 // 处理待办事项排序更新
 func handleUpdateTodoOrder(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// 获取当前用户ID
-	userID, err := getCurrentUserID(r)
+	// 获取当前用户
+	actor, err := currentActor(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
 	// 解析请求体
 	var orderUpdate struct {
-		TodoID int `json:"todo_id"`
-		Order  int `json:"order"`
+		TodoID int  `json:"todo_id"`
+		Order  int  `json:"order"`
+		ListID *int `json:"list_id"` // 可选，移动到其他列表
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&orderUpdate); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// 更新待办事项顺序
-	todo, err := todoStore.UpdateOrder(orderUpdate.TodoID, orderUpdate.Order, userID)
+	todo, err := todoStore.UpdateOrder(orderUpdate.TodoID, orderUpdate.Order, orderUpdate.ListID, actor)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
@@ -1462,14 +1737,22 @@ func handleUpdateTodoOrder(w http.ResponseWriter, r *http.Request) {
 // 处理用户注册
 func handleRegister(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
-	case http.MethodGet:
+	case http.MethodGet, http.MethodHead:
 		// 显示注册页面
-		err := templates.ExecuteTemplate(w, "register.html", nil)
+		err := templates.ExecuteTemplate(w, "register.html", map[string]interface{}{
+			"CSRFToken": csrfToken(r),
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 
 	case http.MethodPost:
+		// 限制每个IP的注册频率
+		if wait, err := loginGuard.CheckRegister(clientIP(r)); err != nil {
+			writeTooManyRequests(w, r, wait, err)
+			return
+		}
+
 		// 检查Content-Type，处理不同格式的请求
 		contentType := r.Header.Get("Content-Type")
 
@@ -1484,9 +1767,7 @@ func handleRegister(w http.ResponseWriter, r *http.Request) {
 
 			decoder := json.NewDecoder(r.Body)
 			if err := decoder.Decode(&data); err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(map[string]string{"error": "无效的JSON数据"})
+				writeProblem(w, r, http.StatusBadRequest, "无效的JSON数据")
 				return
 			}
 
@@ -1496,7 +1777,7 @@ func handleRegister(w http.ResponseWriter, r *http.Request) {
 			// 处理表单格式的请求
 			err := r.ParseForm()
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				writeProblem(w, r, http.StatusBadRequest, err.Error())
 				return
 			}
 
@@ -1505,25 +1786,19 @@ func handleRegister(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if username == "" || password == "" {
-			if contentType == "application/json" {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(map[string]string{"error": "用户名和密码不能为空"})
-			} else {
-				http.Error(w, "用户名和密码不能为空", http.StatusBadRequest)
-			}
+			writeProblem(w, r, http.StatusBadRequest, "用户名和密码不能为空")
 			return
 		}
 
-		_, err := userStore.Register(username, password, false) // 普通用户注册，非管理员
+		// 检查密码是否满足密码策略
+		if err := passwordPolicy.Validate(username, password); err != nil {
+			writeError(w, r, ValidationErrors{"password": err.Error()})
+			return
+		}
+
+		_, err := userStore.Register(username, password, ROLE_MEMBER) // 注册用户默认为普通成员
 		if err != nil {
-			if contentType == "application/json" {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-			} else {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-			}
+			writeError(w, r, err)
 			return
 		}
 
@@ -1535,18 +1810,19 @@ func handleRegister(w http.ResponseWriter, r *http.Request) {
 			// 注册成功，重定向到登录页面
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 		}
-
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 // 处理用户登录
 func handleLogin(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
-	case http.MethodGet:
+	case http.MethodGet, http.MethodHead:
 		// 显示登录页面
-		err := templates.ExecuteTemplate(w, "login.html", nil)
+		err := templates.ExecuteTemplate(w, "login.html", map[string]interface{}{
+			"CSRFToken":           csrfToken(r),
+			"OIDCEnabled":         oidcProvider != nil,
+			"RegistrationEnabled": features.Registration,
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -1566,9 +1842,7 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 
 			decoder := json.NewDecoder(r.Body)
 			if err := decoder.Decode(&data); err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(map[string]string{"error": "无效的JSON数据"})
+				writeProblem(w, r, http.StatusBadRequest, "无效的JSON数据")
 				return
 			}
 
@@ -1578,7 +1852,7 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 			// 处理表单格式的请求
 			err := r.ParseForm()
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				writeProblem(w, r, http.StatusBadRequest, err.Error())
 				return
 			}
 
@@ -1586,26 +1860,40 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 			password = r.Form.Get("password")
 		}
 
-		session, err := userStore.Login(username, password)
-		if err != nil {
+		// 按IP和用户名限制登录频率，锁定的账号直接拒绝
+		ip := clientIP(r)
+		if wait, err := loginGuard.CheckLogin(ip, username); err != nil {
+			writeTooManyRequests(w, r, wait, err)
+			return
+		}
+
+		session, err := userStore.Login(username, password, r.UserAgent(), ip)
+		if errors.Is(err, ErrInvalidCredentials) {
+			loginGuard.LoginFailed(ip, username)
+		} else if err == nil {
+			loginGuard.LoginSucceeded(username)
+		}
+
+		// 启用了两步验证，在第二步输入验证码后才设置会话Cookie
+		var twoFactor *TwoFactorRequired
+		if errors.As(err, &twoFactor) {
+			setChallengeCookie(w, twoFactor.Challenge)
 			if contentType == "application/json" {
 				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				json.NewEncoder(w).Encode(map[string]interface{}{"two_factor_required": true})
 			} else {
-				http.Error(w, err.Error(), http.StatusUnauthorized)
+				http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
 			}
 			return
 		}
 
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
 		// 设置会话Cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "session_token",
-			Value:    session.Token,
-			Path:     "/",
-			Expires:  session.ExpiresAt,
-			HttpOnly: true,
-		})
+		setSessionCookie(w, session)
 
 		if contentType == "application/json" {
 			w.Header().Set("Content-Type", "application/json")
@@ -1615,29 +1903,42 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 			// 登录成功，重定向到首页
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 		}
-
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 // 处理用户登出
+// GET  /logout 显示确认页面，旧的登出链接仍然可用，但不会直接退出登录
+// POST /logout 删除会话并清除Cookie
 func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// GET请求不经过CSRF检查，只显示带有CSRF令牌的确认表单
+		err := templates.ExecuteTemplate(w, "logout.html", map[string]interface{}{
+			"CSRFToken": csrfToken(r),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
 	// 从Cookie中获取会话令牌
 	cookie, err := r.Cookie("session_token")
 	if err == nil {
 		// 删除会话
-		userStore.Logout(cookie.Value)
+		if err := userStore.Logout(cookie.Value); err != nil {
+			writeError(w, r, err)
+			return
+		}
 	}
 
 	// 清除Cookie
-	http.SetCookie(w, &http.Cookie{
+	http.SetCookie(w, cookieConfig.apply(&http.Cookie{
 		Name:     "session_token",
 		Value:    "",
 		Path:     "/",
 		MaxAge:   -1,
 		HttpOnly: true,
-	})
+	}))
 
 	// 重定向到登录页面
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+// 用临时目录中的JSON存储替换全局的Store，测试结束后恢复
+func setupTestStores(t *testing.T) Storage {
+	t.Helper()
+
+	storage, err := NewJSONStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldUsers, oldTodos, oldBlogs := userStore, todoStore, blogStore
+	userStore = NewUserStore(storage, 0)
+	todoStore = NewTodoStore(storage, 0)
+	blogStore = NewBlogStore(storage, 0)
+	t.Cleanup(func() {
+		userStore, todoStore, blogStore = oldUsers, oldTodos, oldBlogs
+		storage.Close()
+	})
+
+	return storage
+}
+
+// 注册一个测试用户
+func registerTestUser(t *testing.T, username, role string) User {
+	t.Helper()
+
+	user, err := userStore.Register(username, "password-"+username, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
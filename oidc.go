@@ -0,0 +1,719 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDC单点登录相关的参数
+const (
+	OIDC_STATE_COOKIE  = "oidc_state"
+	OIDC_STATE_TTL     = 10 * time.Minute // 跳转到身份提供方后完成登录的时限
+	OIDC_CLOCK_SKEW    = time.Minute      // 校验ID令牌时间时允许的时钟误差
+	OIDC_HTTP_TIMEOUT  = 10 * time.Second
+	OIDC_USERNAME_MAX  = 50 // 自动创建用户时用户名的最大长度
+	OIDC_GROUPS_CLAIM  = "groups"
+	OIDC_DEFAULT_SCOPE = "openid profile email"
+)
+
+// OIDCConfig 是OIDC单点登录的配置，作为服务器配置的一部分读取，见config.go
+type OIDCConfig struct {
+	Issuer       string            // 身份提供方的地址，为空表示不启用单点登录
+	ClientID     string            //
+	ClientSecret string            // 公共客户端可以为空，只使用PKCE
+	RedirectURL  string            // 如 http://localhost:8080/auth/oidc/callback
+	Scopes       string            // 空格分隔
+	GroupsClaim  string            // ID令牌中表示用户组的声明
+	GroupRoles   map[string]string // 用户组到角色的映射
+	DefaultRole  string            // 没有匹配任何用户组时的角色
+}
+
+// 默认的OIDC配置，不启用单点登录
+var defaultOIDCConfig = OIDCConfig{
+	Scopes:      OIDC_DEFAULT_SCOPE,
+	GroupsClaim: OIDC_GROUPS_CLAIM,
+	DefaultRole: ROLE_MEMBER,
+}
+
+// Enabled 判断是否配置了单点登录
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != ""
+}
+
+// 检查配置是否完整
+func (c OIDCConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.ClientID == "" {
+		return errors.New("oidc: client_id is required")
+	}
+	if c.RedirectURL == "" {
+		return errors.New("oidc: redirect_url is required")
+	}
+	if !validRole(c.DefaultRole) {
+		return fmt.Errorf("oidc: unknown default_role %q", c.DefaultRole)
+	}
+	for group, role := range c.GroupRoles {
+		if !validRole(role) {
+			return fmt.Errorf("oidc: unknown role %q for group %q", role, group)
+		}
+	}
+	return nil
+}
+
+// 根据用户组计算角色，没有匹配时使用默认角色。按allRoles的顺序返回，便于比较
+func (c OIDCConfig) rolesForGroups(groups []string) []string {
+	matched := make(map[string]bool)
+	for _, group := range groups {
+		if role, ok := c.GroupRoles[group]; ok {
+			matched[role] = true
+		}
+	}
+	if len(matched) == 0 {
+		matched[c.DefaultRole] = true
+	}
+
+	roles := make([]string, 0, len(matched))
+	for _, role := range allRoles {
+		if matched[role] {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// 身份提供方的发现文档（/.well-known/openid-configuration）
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// 跳转到身份提供方、等待回调的登录
+type oidcPending struct {
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// OIDCProvider 实现授权码+PKCE流程，发现文档和签名公钥在第一次使用时获取并缓存
+type OIDCProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey // 按kid索引的签名公钥
+	pending   map[string]oidcPending      // 按state索引
+}
+
+// 全局OIDC提供方，未配置单点登录时为nil
+var oidcProvider *OIDCProvider
+
+// NewOIDCProvider 创建OIDC提供方，不会立即访问身份提供方
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	config.Issuer = strings.TrimRight(config.Issuer, "/")
+	return &OIDCProvider{
+		config:  config,
+		client:  &http.Client{Timeout: OIDC_HTTP_TIMEOUT},
+		keys:    make(map[string]crypto.PublicKey),
+		pending: make(map[string]oidcPending),
+	}
+}
+
+// 发送GET请求并解析JSON响应
+func (p *OIDCProvider) getJSON(rawURL string, v interface{}) error {
+	resp, err := p.client.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// 获取发现文档
+func (p *OIDCProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	cached := p.discovery
+	p.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(p.config.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", discovery.Issuer, p.config.Issuer)
+	}
+
+	p.mu.Lock()
+	p.discovery = &discovery
+	p.mu.Unlock()
+	return &discovery, nil
+}
+
+// JWKS中的一个公钥
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// 把JWK转换为公钥，不支持的类型返回nil
+func (k jsonWebKey) publicKey() crypto.PublicKey {
+	decode := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil
+		}
+		return new(big.Int).SetBytes(b)
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, e := decode(k.N), decode(k.E)
+		if n == nil || e == nil || !e.IsInt64() {
+			return nil
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil
+		}
+		x, y := decode(k.X), decode(k.Y)
+		if x == nil || y == nil {
+			return nil
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	}
+	return nil
+}
+
+// 获取签名公钥。kid不在缓存中时重新获取JWKS，身份提供方轮换密钥后无需重启
+func (p *OIDCProvider) publicKey(kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		if pub := k.publicKey(); pub != nil {
+			keys[k.Kid] = pub
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: no signing key with kid %q", kid)
+}
+
+// 校验JWT的签名，返回其中的声明。支持RS256和ES256
+func (p *OIDCProvider) verifyJWT(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed id_token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("oidc: malformed signature: %w", err)
+	}
+
+	key, err := p.publicKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return nil, errors.New("oidc: invalid id_token signature")
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return nil, errors.New("oidc: invalid id_token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return nil, errors.New("oidc: invalid id_token signature")
+		}
+	default:
+		return nil, fmt.Errorf("oidc: unsupported signing algorithm %q", header.Alg)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// 解码JWT中base64url编码的JSON部分
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("oidc: malformed token: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("oidc: malformed token: %w", err)
+	}
+	return nil
+}
+
+// 校验ID令牌的签发者、受众、有效期和nonce
+func (p *OIDCProvider) validateClaims(claims map[string]interface{}, nonce string, now time.Time) error {
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != p.config.Issuer {
+		return fmt.Errorf("oidc: unexpected issuer %q", iss)
+	}
+
+	audienceOK := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audienceOK = aud == p.config.ClientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == p.config.ClientID {
+				audienceOK = true
+			}
+		}
+	}
+	if !audienceOK {
+		return errors.New("oidc: id_token was not issued for this client")
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(OIDC_CLOCK_SKEW)) {
+		return errors.New("oidc: id_token has expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(OIDC_CLOCK_SKEW)) {
+		return errors.New("oidc: id_token issued in the future")
+	}
+
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return errors.New("oidc: nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return errors.New("oidc: id_token has no subject")
+	}
+	return nil
+}
+
+// 读取字符串数组声明，也接受单个字符串
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// 生成PKCE的code_verifier和对应的S256 code_challenge
+func pkcePair() (verifier, challenge string, err error) {
+	verifier, err = generateToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier = strings.TrimRight(verifier, "=")
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// AuthURL 创建一次待完成的登录，返回身份提供方的授权地址和state
+func (p *OIDCProvider) AuthURL() (string, string, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := generateToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := generateToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, challenge, err := pkcePair()
+	if err != nil {
+		return "", "", err
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	for s, pending := range p.pending {
+		if now.After(pending.ExpiresAt) {
+			delete(p.pending, s)
+		}
+	}
+	p.pending[state] = oidcPending{Nonce: nonce, CodeVerifier: verifier, ExpiresAt: now.Add(OIDC_STATE_TTL)}
+	p.mu.Unlock()
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", p.config.Scopes)
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", challenge)
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return discovery.AuthorizationEndpoint + sep + params.Encode(), state, nil
+}
+
+// OIDCIdentity 是身份提供方确认的用户信息
+type OIDCIdentity struct {
+	Issuer   string
+	Subject  string
+	Username string // 建议的用户名，来自preferred_username、email或sub
+	Groups   []string
+}
+
+// Exchange 用授权码换取ID令牌并校验，返回用户信息。每个state只能使用一次
+func (p *OIDCProvider) Exchange(state, code string) (OIDCIdentity, error) {
+	p.mu.Lock()
+	pending, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !ok || time.Now().After(pending.ExpiresAt) {
+		return OIDCIdentity{}, errors.New("oidc: unknown or expired state")
+	}
+
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	// 用授权码换取令牌
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", pending.CodeVerifier)
+
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return OIDCIdentity{}, fmt.Errorf("oidc: token endpoint returned %s: %s", resp.Status, body)
+	}
+
+	var tokens struct {
+		IDToken     string `json:"id_token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return OIDCIdentity{}, err
+	}
+	if tokens.IDToken == "" {
+		return OIDCIdentity{}, errors.New("oidc: token response has no id_token")
+	}
+
+	// 校验ID令牌
+	claims, err := p.verifyJWT(tokens.IDToken)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+	if err := p.validateClaims(claims, pending.Nonce, time.Now()); err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	// 有些身份提供方只在userinfo中返回用户组
+	if _, ok := claims[p.config.GroupsClaim]; !ok && discovery.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		if info, err := p.userinfo(discovery.UserinfoEndpoint, tokens.AccessToken); err != nil {
+			log.Printf("获取OIDC用户信息失败: %v", err)
+		} else if info["sub"] == claims["sub"] {
+			claims[p.config.GroupsClaim] = info[p.config.GroupsClaim]
+		}
+	}
+
+	identity := OIDCIdentity{
+		Issuer:  p.config.Issuer,
+		Subject: claims["sub"].(string),
+		Groups:  claimStrings(claims, p.config.GroupsClaim),
+	}
+	for _, name := range []string{"preferred_username", "email", "sub"} {
+		if value, _ := claims[name].(string); value != "" {
+			identity.Username = value
+			break
+		}
+	}
+	return identity, nil
+}
+
+// 获取userinfo
+func (p *OIDCProvider) userinfo(endpoint, accessToken string) (map[string]interface{}, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", endpoint, resp.Status)
+	}
+	var info map[string]interface{}
+	return info, json.NewDecoder(resp.Body).Decode(&info)
+}
+
+// 按身份提供方和subject查找用户，调用时需持有s.mu
+func (s *UserStore) oidcUserIndex(issuer, subject string) int {
+	for i, user := range s.users {
+		if user.OIDCIssuer == issuer && user.OIDCSubject == subject {
+			return i
+		}
+	}
+	return -1
+}
+
+// 根据建议的用户名生成一个未被使用的用户名，调用时需持有s.mu
+func (s *UserStore) availableUsername(suggested string) string {
+	base := strings.TrimSpace(suggested)
+	if len([]rune(base)) > OIDC_USERNAME_MAX {
+		base = string([]rune(base)[:OIDC_USERNAME_MAX])
+	}
+
+	taken := make(map[string]bool, len(s.users))
+	for _, user := range s.users {
+		taken[user.Username] = true
+	}
+	username := base
+	for n := 2; taken[username]; n++ {
+		username = fmt.Sprintf("%s-%d", base, n)
+	}
+	return username
+}
+
+// LoginOIDC 为身份提供方确认的用户创建会话。第一次登录时以roles自动创建用户；
+// syncRoles为true时每次登录都把已有用户的角色同步为roles，但不会撤销最后一个管理员的管理员角色。
+// 返回会话以及用户是否是新创建的
+func (s *UserStore) LoginOIDC(identity OIDCIdentity, roles []string, syncRoles bool, userAgent, ip string) (Session, bool, error) {
+	// 单点登录用户不使用密码登录，设置为随机密码。计算密码哈希比较耗时，放在加锁之前
+	random, err := generateToken()
+	if err != nil {
+		return Session{}, false, err
+	}
+	passwordHash, err := hashPassword(random)
+	if err != nil {
+		return Session{}, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := false
+	i := s.oidcUserIndex(identity.Issuer, identity.Subject)
+	if i < 0 {
+		user := User{
+			ID:          s.nextID,
+			Username:    s.availableUsername(identity.Username),
+			Password:    passwordHash,
+			Roles:       roles,
+			OIDCIssuer:  identity.Issuer,
+			OIDCSubject: identity.Subject,
+		}
+		s.users = append(s.users, user)
+		s.nextID++
+		i = len(s.users) - 1
+		created = true
+	} else if s.users[i].Disabled {
+		return Session{}, false, ErrAccountDisabled
+	} else if syncRoles {
+		if hasRole(s.users[i].Roles, ROLE_ADMIN) && !hasRole(roles, ROLE_ADMIN) && !s.users[i].Disabled && s.countAdmins() <= 1 {
+			roles = append([]string{ROLE_ADMIN}, roles...)
+		}
+		s.users[i].Roles = roles
+	}
+
+	s.users[i].LastLoginAt = time.Now()
+	if err := s.persistUser(s.users[i]); err != nil {
+		return Session{}, false, err
+	}
+
+	session, err := s.createSession(s.users[i], userAgent, ip)
+	return session, created, err
+}
+
+// 设置或清除保存state的Cookie
+func setOIDCStateCookie(w http.ResponseWriter, state string) {
+	cookie := cookieConfig.apply(&http.Cookie{
+		Name:     OIDC_STATE_COOKIE,
+		Value:    state,
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+	})
+	// 身份提供方的回调是跨站跳转，SameSite=Strict时浏览器不会带上Cookie
+	if cookie.SameSite == http.SameSiteStrictMode {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	if state == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = time.Now().Add(OIDC_STATE_TTL)
+	}
+	http.SetCookie(w, cookie)
+}
+
+// 处理单点登录的入口，跳转到身份提供方
+// GET /auth/oidc/login
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		writeProblem(w, r, http.StatusNotFound, "未启用单点登录")
+		return
+	}
+
+	authURL, state, err := oidcProvider.AuthURL()
+	if err != nil {
+		log.Printf("OIDC登录失败: %v", err)
+		writeProblem(w, r, http.StatusBadGateway, "无法连接身份提供方，请稍后再试")
+		return
+	}
+
+	// state同时保存在Cookie中，回调时校验，防止登录CSRF
+	setOIDCStateCookie(w, state)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// 处理身份提供方的回调，创建会话后跳转到首页
+// GET /auth/oidc/callback?code=...&state=...
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		writeProblem(w, r, http.StatusNotFound, "未启用单点登录")
+		return
+	}
+
+	// 清除state Cookie
+	setOIDCStateCookie(w, "")
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		writeProblem(w, r, http.StatusUnauthorized, "单点登录失败: "+errCode+" "+query.Get("error_description"))
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(OIDC_STATE_COOKIE)
+	if err != nil || state == "" || cookie.Value != state {
+		writeProblem(w, r, http.StatusBadRequest, "单点登录已过期，请重新登录")
+		return
+	}
+
+	identity, err := oidcProvider.Exchange(state, query.Get("code"))
+	if err != nil {
+		log.Printf("OIDC登录失败: %v", err)
+		writeProblem(w, r, http.StatusUnauthorized, "单点登录失败，请重新登录")
+		return
+	}
+
+	// 配置了用户组映射时，每次登录都按用户组同步角色；否则只在创建用户时使用默认角色
+	config := oidcProvider.config
+	roles := config.rolesForGroups(identity.Groups)
+	syncRoles := len(config.GroupRoles) > 0
+
+	session, created, err := userStore.LoginOIDC(identity, roles, syncRoles, r.UserAgent(), clientIP(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if created {
+		auditLog.Record(AuditEvent{Event: AUDIT_OIDC_PROVISIONED, Username: session.Username, IP: clientIP(r), Detail: identity.Issuer + " " + identity.Subject})
+	}
+
+	// 设置会话Cookie
+	setSessionCookie(w, session)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
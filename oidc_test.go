@@ -0,0 +1,449 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testOIDCClientID = "todolist-test"
+
+// testIdP 是用于测试的身份提供方，提供发现文档、JWKS和令牌端点，用RS256签发ID令牌
+type testIdP struct {
+	server *httptest.Server
+
+	mu           sync.Mutex
+	key          *rsa.PrivateKey
+	kid          string
+	codes        map[string]testAuthRequest // 按授权码索引
+	subject      string
+	groups       []string
+	mutate       func(claims map[string]interface{}) // 签名前修改声明
+	jwksRequests int
+}
+
+// 授权端点收到的请求中需要在令牌端点校验的部分
+type testAuthRequest struct {
+	nonce         string
+	codeChallenge string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+
+	idp := &testIdP{codes: make(map[string]testAuthRequest), subject: "user-1"}
+	idp.rotateKey(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", idp.handleJWKS)
+	mux.HandleFunc("POST /token", idp.handleToken)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+// 生成新的签名密钥，之后签发的令牌使用新的kid
+func (idp *testIdP) rotateKey(t *testing.T, kid string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.key, idp.kid = key, kid
+	idp.mu.Unlock()
+}
+
+func (idp *testIdP) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.jwksRequests++
+
+	pub := idp.key.PublicKey
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []jsonWebKey{{
+			Kty: "RSA",
+			Kid: idp.kid,
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (idp *testIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	request, ok := idp.codes[r.FormValue("code")]
+	delete(idp.codes, r.FormValue("code"))
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || r.FormValue("client_id") != testOIDCClientID ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != request.codeChallenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":                idp.server.URL,
+		"aud":                testOIDCClientID,
+		"sub":                idp.subject,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              request.nonce,
+		"preferred_username": "sso-" + idp.subject,
+		"groups":             idp.groups,
+	}
+	if idp.mutate != nil {
+		idp.mutate(claims)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(claims)})
+}
+
+// 用当前密钥签发RS256 JWT，调用时需持有idp.mu
+func (idp *testIdP) sign(claims map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(map[string]string{"alg": "RS256", "typ": "JWT", "kid": idp.kid}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// 模拟用户在身份提供方完成登录，返回授权码
+func (idp *testIdP) authorize(t *testing.T, authURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("code_challenge_method = %q, want S256", query.Get("code_challenge_method"))
+	}
+
+	code, err := generateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.codes[code] = testAuthRequest{nonce: query.Get("nonce"), codeChallenge: query.Get("code_challenge")}
+	idp.mu.Unlock()
+	return code
+}
+
+// 替换全局的OIDC提供方，测试结束后恢复
+func setupTestOIDC(t *testing.T, idp *testIdP, groupRoles map[string]string) {
+	t.Helper()
+
+	config := defaultOIDCConfig
+	config.Issuer = idp.server.URL
+	config.ClientID = testOIDCClientID
+	config.RedirectURL = "http://localhost/auth/oidc/callback"
+	config.GroupRoles = groupRoles
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	old := oidcProvider
+	oidcProvider = NewOIDCProvider(config)
+	t.Cleanup(func() { oidcProvider = old })
+}
+
+// 发起单点登录，返回回调需要的state和state Cookie
+func startOIDCLogin(t *testing.T, idp *testIdP) (code, state string, cookie *http.Cookie) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handleOIDCLogin(w, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want 302: %s", w.Code, w.Body.String())
+	}
+
+	authURL := w.Header().Get("Location")
+	if !strings.HasPrefix(authURL, idp.server.URL+"/authorize?") {
+		t.Fatalf("redirected to %q, want the authorization endpoint", authURL)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == OIDC_STATE_COOKIE {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("no state cookie set")
+	}
+
+	u, _ := url.Parse(authURL)
+	return idp.authorize(t, authURL), u.Query().Get("state"), cookie
+}
+
+// 调用回调处理器
+func oidcCallback(code, state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	query := url.Values{"code": {code}, "state": {state}}
+	r := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	handleOIDCCallback(w, r)
+	return w
+}
+
+// 完成一次单点登录，返回回调的响应
+func completeOIDCLogin(t *testing.T, idp *testIdP) *httptest.ResponseRecorder {
+	t.Helper()
+
+	code, state, cookie := startOIDCLogin(t, idp)
+	return oidcCallback(code, state, cookie)
+}
+
+// 按subject查找单点登录创建的用户
+func oidcUser(t *testing.T, idp *testIdP, subject string) User {
+	t.Helper()
+
+	userStore.mu.Lock()
+	defer userStore.mu.Unlock()
+	i := userStore.oidcUserIndex(idp.server.URL, subject)
+	if i < 0 {
+		t.Fatalf("no user for subject %q", subject)
+	}
+	return userStore.users[i]
+}
+
+func TestOIDCLogin(t *testing.T) {
+	setupTestStores(t)
+	idp := newTestIdP(t)
+	setupTestOIDC(t, idp, nil)
+
+	w := completeOIDCLogin(t, idp)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+		t.Fatalf("callback status = %d, location = %q: %s", w.Code, w.Header().Get("Location"), w.Body.String())
+	}
+
+	var session *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "session_token" && c.Value != "" {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("no session cookie set")
+	}
+
+	user := oidcUser(t, idp, "user-1")
+	if user.Username != "sso-user-1" {
+		t.Errorf("username = %q, want sso-user-1", user.Username)
+	}
+	if len(user.Roles) != 1 || user.Roles[0] != ROLE_MEMBER {
+		t.Errorf("roles = %v, want the default role", user.Roles)
+	}
+	if s, _, ok := userStore.TouchSession(session.Value, "127.0.0.1"); !ok || s.UserID != user.ID {
+		t.Errorf("session cookie does not belong to the new user")
+	}
+
+	// 再次登录使用同一个用户
+	if w := completeOIDCLogin(t, idp); w.Code != http.StatusSeeOther {
+		t.Fatalf("second login status = %d: %s", w.Code, w.Body.String())
+	}
+	if users := userStore.ListUsers(); len(users) != 2 {
+		t.Errorf("got %d users after logging in twice, want the default admin and one SSO user", len(users))
+	}
+}
+
+func TestOIDCCallbackRejectsBadState(t *testing.T) {
+	setupTestStores(t)
+	idp := newTestIdP(t)
+	setupTestOIDC(t, idp, nil)
+
+	tests := []struct {
+		name   string
+		modify func(state string, cookie *http.Cookie) (string, *http.Cookie)
+		want   int
+	}{
+		{"missing cookie", func(state string, cookie *http.Cookie) (string, *http.Cookie) {
+			return state, nil
+		}, http.StatusBadRequest},
+		{"cookie mismatch", func(state string, cookie *http.Cookie) (string, *http.Cookie) {
+			return state, &http.Cookie{Name: OIDC_STATE_COOKIE, Value: "other"}
+		}, http.StatusBadRequest},
+		{"unknown state", func(state string, cookie *http.Cookie) (string, *http.Cookie) {
+			return "forged", &http.Cookie{Name: OIDC_STATE_COOKIE, Value: "forged"}
+		}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, state, cookie := startOIDCLogin(t, idp)
+			state, cookie = tt.modify(state, cookie)
+			if w := oidcCallback(code, state, cookie); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	// 每个state只能使用一次
+	code, state, cookie := startOIDCLogin(t, idp)
+	if w := oidcCallback(code, state, cookie); w.Code != http.StatusSeeOther {
+		t.Fatalf("first callback status = %d: %s", w.Code, w.Body.String())
+	}
+	if w := oidcCallback(code, state, cookie); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed callback status = %d, want 401", w.Code)
+	}
+}
+
+func TestOIDCRejectsInvalidIDToken(t *testing.T) {
+	setupTestStores(t)
+	idp := newTestIdP(t)
+	setupTestOIDC(t, idp, nil)
+
+	tests := []struct {
+		name   string
+		mutate func(claims map[string]interface{})
+	}{
+		{"bad nonce", func(claims map[string]interface{}) { claims["nonce"] = "replayed" }},
+		{"missing nonce", func(claims map[string]interface{}) { delete(claims, "nonce") }},
+		{"wrong audience", func(claims map[string]interface{}) { claims["aud"] = "another-client" }},
+		{"wrong audience list", func(claims map[string]interface{}) { claims["aud"] = []string{"a", "b"} }},
+		{"wrong issuer", func(claims map[string]interface{}) { claims["iss"] = "https://evil.example.com" }},
+		{"expired", func(claims map[string]interface{}) {
+			claims["exp"] = time.Now().Add(-OIDC_CLOCK_SKEW - time.Minute).Unix()
+		}},
+		{"issued in the future", func(claims map[string]interface{}) {
+			claims["iat"] = time.Now().Add(OIDC_CLOCK_SKEW + time.Hour).Unix()
+		}},
+		{"no subject", func(claims map[string]interface{}) { claims["sub"] = "" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp.mu.Lock()
+			idp.mutate = tt.mutate
+			idp.mu.Unlock()
+
+			if w := completeOIDCLogin(t, idp); w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want 401: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if users := userStore.ListUsers(); len(users) != 1 {
+		t.Errorf("got %d users, want no SSO user created", len(users))
+	}
+}
+
+// 身份提供方轮换密钥后，未知的kid会触发重新获取JWKS
+func TestOIDCRefreshesJWKSForUnknownKid(t *testing.T) {
+	setupTestStores(t)
+	idp := newTestIdP(t)
+	setupTestOIDC(t, idp, nil)
+
+	if w := completeOIDCLogin(t, idp); w.Code != http.StatusSeeOther {
+		t.Fatalf("login status = %d: %s", w.Code, w.Body.String())
+	}
+	if w := completeOIDCLogin(t, idp); w.Code != http.StatusSeeOther {
+		t.Fatalf("second login status = %d: %s", w.Code, w.Body.String())
+	}
+	idp.mu.Lock()
+	requests := idp.jwksRequests
+	idp.mu.Unlock()
+	if requests != 1 {
+		t.Fatalf("JWKS fetched %d times, want the keys cached after the first login", requests)
+	}
+
+	idp.rotateKey(t, "key-2")
+	if w := completeOIDCLogin(t, idp); w.Code != http.StatusSeeOther {
+		t.Fatalf("login after key rotation status = %d: %s", w.Code, w.Body.String())
+	}
+	idp.mu.Lock()
+	requests = idp.jwksRequests
+	idp.mu.Unlock()
+	if requests != 2 {
+		t.Errorf("JWKS fetched %d times, want a refresh for the new kid", requests)
+	}
+
+	// 用旧密钥签名、但声称使用新kid的令牌不能通过校验
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.key = other
+	idp.mu.Unlock()
+	if w := completeOIDCLogin(t, idp); w.Code != http.StatusUnauthorized {
+		t.Errorf("token signed with the wrong key: status = %d, want 401", w.Code)
+	}
+}
+
+// 按用户组同步角色时，不会撤销最后一个管理员的管理员角色
+func TestOIDCGroupSyncKeepsLastAdmin(t *testing.T) {
+	setupTestStores(t)
+	idp := newTestIdP(t)
+	setupTestOIDC(t, idp, map[string]string{"admins": ROLE_ADMIN, "staff": ROLE_MEMBER})
+
+	defaultAdmin, ok := getUserIDByUsername(DEFAULT_ADMIN_USERNAME)
+	if !ok {
+		t.Fatal("default admin not found")
+	}
+
+	idp.mu.Lock()
+	idp.groups = []string{"admins"}
+	idp.mu.Unlock()
+	if w := completeOIDCLogin(t, idp); w.Code != http.StatusSeeOther {
+		t.Fatalf("login status = %d: %s", w.Code, w.Body.String())
+	}
+	if user := oidcUser(t, idp, "user-1"); !hasRole(user.Roles, ROLE_ADMIN) {
+		t.Fatalf("roles = %v, want admin from the admins group", user.Roles)
+	}
+
+	// 删除默认管理员后，单点登录用户是唯一的管理员
+	if err := userStore.DeleteUser(defaultAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	idp.mu.Lock()
+	idp.groups = []string{"staff"}
+	idp.mu.Unlock()
+	if w := completeOIDCLogin(t, idp); w.Code != http.StatusSeeOther {
+		t.Fatalf("login status = %d: %s", w.Code, w.Body.String())
+	}
+	user := oidcUser(t, idp, "user-1")
+	if !hasRole(user.Roles, ROLE_ADMIN) || !hasRole(user.Roles, ROLE_MEMBER) {
+		t.Errorf("roles = %v, want the last admin to keep admin alongside the synced role", user.Roles)
+	}
+
+	// 有其他管理员时按用户组正常撤销
+	registerTestUser(t, "root", ROLE_ADMIN)
+	if w := completeOIDCLogin(t, idp); w.Code != http.StatusSeeOther {
+		t.Fatalf("login status = %d: %s", w.Code, w.Body.String())
+	}
+	user = oidcUser(t, idp, "user-1")
+	if hasRole(user.Roles, ROLE_ADMIN) {
+		t.Errorf("roles = %v, want admin revoked when another admin exists", user.Roles)
+	}
+}
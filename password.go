@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2id参数，参考OWASP推荐的最低配置
+const (
+	ARGON2_MEMORY  = 19 * 1024 // KiB
+	ARGON2_TIME    = 2
+	ARGON2_THREADS = 1
+	ARGON2_KEY_LEN = 32
+	ARGON2_SALT    = 16
+)
+
+// 哈希字符串的前缀，不带该前缀的密码视为旧版本的明文密码
+const ARGON2_PREFIX = "$argon2id$"
+
+// 用户不存在时用于比对的哈希，使登录耗时与用户是否存在无关
+var dummyPasswordHash, _ = hashPassword("dummy-password")
+
+// hashPassword 使用argon2id计算密码哈希，返回PHC格式的字符串：
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, ARGON2_SALT)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, ARGON2_TIME, ARGON2_MEMORY, ARGON2_THREADS, ARGON2_KEY_LEN)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		ARGON2_PREFIX, argon2.Version, ARGON2_MEMORY, ARGON2_TIME, ARGON2_THREADS,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyPassword 校验密码是否与保存的值匹配。
+// needsRehash为true表示保存的是明文或参数已过时，应在登录成功后重新计算哈希
+func verifyPassword(stored, password string) (ok bool, needsRehash bool) {
+	if !strings.HasPrefix(stored, ARGON2_PREFIX) {
+		// 旧版本直接保存的明文密码
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok
+	}
+
+	// $argon2id$v=19$m=...,t=...,p=...$salt$hash
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false, false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false
+	}
+
+	key := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(expected)))
+	if subtle.ConstantTimeCompare(key, expected) != 1 {
+		return false, false
+	}
+
+	needsRehash = memory != ARGON2_MEMORY || time != ARGON2_TIME || threads != ARGON2_THREADS ||
+		len(expected) != ARGON2_KEY_LEN
+	return true, needsRehash
+}
+
+// PasswordPolicy 描述注册时对密码强度的要求
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireLetter bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+// 默认的密码策略
+var passwordPolicy = PasswordPolicy{
+	MinLength:     8,
+	MaxLength:     128,
+	RequireLetter: true,
+	RequireDigit:  true,
+}
+
+// Validate 检查密码是否满足策略，不满足时返回面向用户的错误信息
+func (p PasswordPolicy) Validate(username, password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("密码长度不能少于%d个字符", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("密码长度不能超过%d个字符", p.MaxLength)
+	}
+	if strings.EqualFold(password, username) {
+		return fmt.Errorf("密码不能与用户名相同")
+	}
+
+	var hasLetter, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireLetter && !hasLetter {
+		return fmt.Errorf("密码必须包含字母")
+	}
+	if p.RequireDigit && !hasDigit {
+		return fmt.Errorf("密码必须包含数字")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		return fmt.Errorf("密码必须包含特殊字符")
+	}
+
+	return nil
+}
+
+// ErrWrongPassword 表示修改密码时输入的当前密码不正确
+var ErrWrongPassword = domainError(ErrValidation, "wrong_password", "当前密码错误")
+
+// ChangePassword 校验当前密码后修改密码，并撤销该用户除keepToken以外的所有会话和全部个人访问令牌
+func (s *UserStore) ChangePassword(userID int, current, password, keepToken string) error {
+	s.mu.Lock()
+	i := s.userIndex(userID)
+	if i < 0 {
+		s.mu.Unlock()
+		return fmt.Errorf("user with ID %d: %w", userID, ErrNotFound)
+	}
+	stored := s.users[i].Password
+	s.mu.Unlock()
+
+	// 校验和计算哈希比较耗时，不持有锁
+	if ok, _ := verifyPassword(stored, current); !ok {
+		return ErrWrongPassword
+	}
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 校验期间密码可能已被修改
+	i = s.userIndex(userID)
+	if i < 0 || s.users[i].Password != stored {
+		return ErrWrongPassword
+	}
+
+	s.users[i].Password = passwordHash
+	s.users[i].MustChangePassword = false
+	for token, session := range s.sessions {
+		if session.UserID == userID && token != keepToken {
+			err = errors.Join(err, s.deleteSession(token))
+		}
+	}
+	// 密码可能已经泄露，用旧密码创建的令牌同样不再可信
+	err = errors.Join(err, s.deleteUserTokens(userID))
+
+	// 保存数据
+	return errors.Join(err, s.persistUser(s.users[i]))
+}
+
+// 处理修改密码的请求
+// GET  /change-password 显示修改密码页面
+// POST /change-password 修改密码，请求体为{"current_password": "...", "new_password": "..."}或表单
+func handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户ID
+	userID, err := getCurrentUserID(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		user, err := userStore.GetUser(userID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// 传递数据到模板
+		data := map[string]interface{}{
+			"Username":           user.Username,
+			"MustChangePassword": user.MustChangePassword,
+			"CSRFToken":          csrfToken(r),
+		}
+
+		err = templates.ExecuteTemplate(w, "change_password.html", data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		contentType := r.Header.Get("Content-Type")
+
+		var current, password string
+		if contentType == "application/json" {
+			var data struct {
+				CurrentPassword string `json:"current_password"`
+				NewPassword     string `json:"new_password"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+				writeProblem(w, r, http.StatusBadRequest, "无效的JSON数据")
+				return
+			}
+			current = data.CurrentPassword
+			password = data.NewPassword
+		} else {
+			if err := r.ParseForm(); err != nil {
+				writeProblem(w, r, http.StatusBadRequest, err.Error())
+				return
+			}
+			current = r.Form.Get("current_password")
+			password = r.Form.Get("new_password")
+		}
+
+		err = passwordPolicy.Validate(currentUsername(r), password)
+		if err == nil && password == current {
+			err = fmt.Errorf("新密码不能与当前密码相同")
+		}
+		if err != nil {
+			writeError(w, r, ValidationErrors{"new_password": err.Error()})
+			return
+		}
+
+		// 保留当前会话，其他设备需要重新登录
+		var token string
+		if cookie, cookieErr := r.Cookie("session_token"); cookieErr == nil {
+			token = cookie.Value
+		}
+		if err := userStore.ChangePassword(userID, current, password, token); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		auditLog.Record(AuditEvent{Event: AUDIT_PASSWORD_CHANGED, Username: currentUsername(r), IP: clientIP(r)})
+
+		if contentType == "application/json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "密码修改成功"})
+		} else {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		}
+	}
+}
+
+// 默认管理员的用户名和密码，首次登录后必须修改
+const (
+	DEFAULT_ADMIN_USERNAME = "admin"
+	DEFAULT_ADMIN_PASSWORD = "admin"
+)
+
+// 旧版本创建的默认管理员没有修改密码的要求，如果仍在使用默认密码，要求登录后先修改。
+// 在NewUserStore中调用，返回是否有用户需要修改
+func (s *UserStore) requireDefaultPasswordChange() bool {
+	for i, user := range s.users {
+		if user.Username != DEFAULT_ADMIN_USERNAME || user.MustChangePassword {
+			continue
+		}
+		if ok, _ := verifyPassword(user.Password, DEFAULT_ADMIN_PASSWORD); !ok {
+			continue
+		}
+
+		s.users[i].MustChangePassword = true
+		if err := s.persistUser(s.users[i]); err != nil {
+			log.Printf("保存默认管理员失败: %v", err)
+		}
+		auditLog.Record(AuditEvent{Event: AUDIT_DEFAULT_PASSWORD, Username: user.Username})
+		return true
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, ARGON2_PREFIX) || strings.Contains(hash, "correct horse") {
+		t.Fatalf("hash = %q, want an argon2id PHC string", hash)
+	}
+
+	if ok, rehash := verifyPassword(hash, "correct horse"); !ok || rehash {
+		t.Errorf("verify correct password = %v, %v; want true, false", ok, rehash)
+	}
+	if ok, _ := verifyPassword(hash, "wrong horse"); ok {
+		t.Error("wrong password verified")
+	}
+
+	// 同一个密码每次使用不同的盐
+	if again, _ := hashPassword("correct horse"); again == hash {
+		t.Error("two hashes of the same password are identical")
+	}
+}
+
+func TestVerifyPasswordLegacyAndOutdated(t *testing.T) {
+	// 旧版本的明文密码可以验证，并要求重新计算哈希
+	if ok, rehash := verifyPassword("secret123", "secret123"); !ok || !rehash {
+		t.Errorf("plaintext = %v, %v; want true, true", ok, rehash)
+	}
+	if ok, rehash := verifyPassword("secret123", "secret124"); ok || rehash {
+		t.Errorf("wrong plaintext = %v, %v; want false, false", ok, rehash)
+	}
+
+	// 使用旧参数计算的哈希同样需要重新计算
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte("secret123"), salt, 1, 8*1024, 1, ARGON2_KEY_LEN)
+	outdated := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", ARGON2_PREFIX, argon2.Version, 8*1024, 1, 1,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	if ok, rehash := verifyPassword(outdated, "secret123"); !ok || !rehash {
+		t.Errorf("outdated parameters = %v, %v; want true, true", ok, rehash)
+	}
+
+	// 格式错误的哈希不能验证通过，也不会被当作明文
+	for _, stored := range []string{ARGON2_PREFIX, ARGON2_PREFIX + "v=19$m=1,t=1,p=1$!!$!!"} {
+		if ok, _ := verifyPassword(stored, stored); ok {
+			t.Errorf("malformed hash %q verified", stored)
+		}
+	}
+}
+
+// 旧版本保存明文密码的用户可以登录，登录后存储中的密码变为argon2id哈希
+func TestLoginMigratesPlaintextPassword(t *testing.T) {
+	storage := setupTestStores(t)
+	legacy := User{ID: 100, Username: "legacy", Password: "plain-secret1"}
+	if err := storage.PutUser(legacy); err != nil {
+		t.Fatal(err)
+	}
+	userStore = NewUserStore(storage, 0)
+
+	if _, err := userStore.Login("legacy", "wrong-secret1", "test", "127.0.0.1"); err == nil {
+		t.Fatal("login with a wrong password succeeded")
+	}
+	if stored := storedPassword(t, storage, legacy.ID); stored != legacy.Password {
+		t.Fatalf("failed login changed the stored password to %q", stored)
+	}
+
+	if _, err := userStore.Login("legacy", "plain-secret1", "test", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := userStore.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := storedPassword(t, storage, legacy.ID)
+	if !strings.HasPrefix(stored, ARGON2_PREFIX) || strings.Contains(stored, "plain-secret1") {
+		t.Fatalf("stored password = %q, want an argon2id hash", stored)
+	}
+
+	// 迁移后仍然可以用原来的密码登录
+	if _, err := userStore.Login("legacy", "plain-secret1", "test", "127.0.0.1"); err != nil {
+		t.Errorf("login after migration: %v", err)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"abc12345", true},
+		{"abc1234", false},   // 太短
+		{"abcdefgh", false},  // 没有数字
+		{"12345678", false},  // 没有字母
+		{"Alice1234", false}, // 与用户名相同
+		{"密码密码密码12", true},   // 按字符而不是字节计算长度
+		{strings.Repeat("a1", 65), false},
+	}
+
+	for _, tt := range tests {
+		err := passwordPolicy.Validate("alice1234", tt.password)
+		if (err == nil) != tt.valid {
+			t.Errorf("Validate(%q) = %v, want valid = %v", tt.password, err, tt.valid)
+		}
+	}
+}
+
+// 从存储中读取用户保存的密码
+func storedPassword(t *testing.T, storage Storage, userID int) string {
+	t.Helper()
+
+	users, _, err := storage.LoadUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range users {
+		if user.ID == userID {
+			return user.Password
+		}
+	}
+	t.Fatalf("user %d not found in storage", userID)
+	return ""
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Access 表示用户对待办事项或列表的访问级别，高级别包含低级别的所有权限
+type Access int
+
+const (
+	ACCESS_NONE  Access = iota // 不可见
+	ACCESS_VIEW                // 查看
+	ACCESS_EDIT                // 修改、完成、标记删除待办事项，向列表添加待办事项
+	ACCESS_OWNER               // 永久删除待办事项，修改、归档、删除和共享列表
+)
+
+// 访问级别对应的角色名称，用于返回给客户端
+func (a Access) role() string {
+	switch a {
+	case ACCESS_OWNER:
+		return "owner"
+	case ACCESS_EDIT:
+		return SHARE_EDITOR
+	case ACCESS_VIEW:
+		return SHARE_VIEWER
+	}
+	return ""
+}
+
+// Actor 是发起操作的用户
+type Actor struct {
+	UserID int
+	Roles  []string
+}
+
+// 从请求中获取当前用户
+func currentActor(r *http.Request) (Actor, error) {
+	identity, ok := identityFrom(r)
+	if !ok {
+		return Actor{}, ErrNotAuthenticated
+	}
+	return Actor{UserID: identity.UserID, Roles: identity.Roles}, nil
+}
+
+// 用户对列表的访问级别：创建者和可以管理所有待办事项的用户是所有者，其他用户取决于共享角色
+func listAccess(actor Actor, list TodoList) Access {
+	if list.UserID == actor.UserID || actor.Can(PERM_TODO_MANAGE_ALL) {
+		return ACCESS_OWNER
+	}
+	switch list.shareRole(actor.UserID) {
+	case SHARE_EDITOR:
+		return ACCESS_EDIT
+	case SHARE_VIEWER:
+		return ACCESS_VIEW
+	}
+	return ACCESS_NONE
+}
+
+// 用户对待办事项的访问级别，调用时需持有s.mu。
+// 创建者是所有者，其他用户取决于所在列表的权限，被指派的用户至少可以修改
+func (s *TodoStore) todoAccess(actor Actor, todo Todo) Access {
+	if todo.UserID == actor.UserID || actor.Can(PERM_TODO_MANAGE_ALL) {
+		return ACCESS_OWNER
+	}
+
+	access := ACCESS_NONE
+	if todo.ListID != 0 {
+		if i := s.listIndex(todo.ListID); i >= 0 {
+			access = listAccess(actor, s.lists[i])
+		}
+	}
+	if todo.AssigneeID == actor.UserID && access < ACCESS_EDIT {
+		access = ACCESS_EDIT
+	}
+	return access
+}
+
+// 查找待办事项并检查访问级别，返回其下标。看不到的待办事项按不存在处理，调用时需持有s.mu
+func (s *TodoStore) authorizeTodo(id int, actor Actor, need Access) (int, error) {
+	i := s.indexOf(id)
+	if i < 0 {
+		return -1, fmt.Errorf("todo with ID %d: %w", id, ErrNotFound)
+	}
+
+	access := s.todoAccess(actor, s.todos[i])
+	if access == ACCESS_NONE {
+		return -1, fmt.Errorf("todo with ID %d: %w", id, ErrNotFound)
+	}
+	if access < need {
+		return -1, fmt.Errorf("todo with ID %d: %w", id, ErrForbidden)
+	}
+	return i, nil
+}
+
+// 查找列表并检查访问级别，返回其下标。看不到的列表按不存在处理，调用时需持有s.mu
+func (s *TodoStore) authorizeList(id int, actor Actor, need Access) (int, error) {
+	i := s.listIndex(id)
+	if i < 0 {
+		return -1, fmt.Errorf("list with ID %d: %w", id, ErrNotFound)
+	}
+
+	access := listAccess(actor, s.lists[i])
+	if access == ACCESS_NONE {
+		return -1, fmt.Errorf("list with ID %d: %w", id, ErrNotFound)
+	}
+	if access < need {
+		return -1, fmt.Errorf("list with ID %d: %w", id, ErrForbidden)
+	}
+	return i, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// 默认的刷新间隔，以及最多允许积压多少次尚未落盘的修改
+const (
+	DEFAULT_FLUSH_INTERVAL = time.Second
+	PERSIST_MAX_PENDING    = 1000
+)
+
+// persister 是每个Store唯一的持久化工作协程。
+// Store修改数据后只标记为脏，工作协程按刷新间隔把积压的修改合并成一次写入，
+// 所有写入都在同一个协程中按顺序进行，旧的快照不会覆盖新的快照。
+type persister struct {
+	name     string
+	write    func() error
+	interval time.Duration
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	dirty    uint64 // 已标记的修改次数
+	synced   uint64 // 已落盘的修改次数
+	attempts uint64 // 已完成的写入尝试次数
+	lastErr  error
+
+	wake chan struct{}
+}
+
+// newPersister 创建并启动持久化工作协程，interval为0时每次修改后立即写入
+func newPersister(name string, write func() error, interval time.Duration) *persister {
+	p := &persister{
+		name:     name,
+		write:    write,
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	go p.run()
+
+	return p
+}
+
+// save 把一次修改交给存储后端，并标记为需要落盘。
+// 存储后端拒绝这次修改时返回错误，调用者应把它返回给客户端，而不是当作已经保存
+func (p *persister) save(op func() error) error {
+	err := op()
+	p.markDirty()
+	if err != nil {
+		return fmt.Errorf("保存%s数据失败: %w", p.name, err)
+	}
+	return nil
+}
+
+// markDirty 标记有新的修改。积压过多时会阻塞调用者，直到工作协程追上进度
+func (p *persister) markDirty() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.dirty++
+	if p.interval == 0 {
+		p.signal()
+	}
+
+	for p.dirty-p.synced >= PERSIST_MAX_PENDING {
+		p.signal()
+		attempts := p.attempts
+		for p.attempts == attempts {
+			p.cond.Wait()
+		}
+
+		// 写入失败时不再阻塞，错误已由工作协程记录
+		if p.lastErr != nil {
+			return
+		}
+	}
+}
+
+// Flush 立即写入所有已标记的修改，并等待写入完成
+func (p *persister) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	target := p.dirty
+	for p.synced < target {
+		p.signal()
+		attempts := p.attempts
+		for p.attempts == attempts {
+			p.cond.Wait()
+		}
+
+		// 本次写入失败时返回错误，未覆盖到的修改会在下一轮写入
+		if p.synced < target && p.lastErr != nil {
+			return p.lastErr
+		}
+	}
+
+	return nil
+}
+
+// 唤醒工作协程，调用时需持有p.mu
+func (p *persister) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// 工作协程主循环
+func (p *persister) run() {
+	var tick <-chan time.Time
+	if p.interval > 0 {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-p.wake:
+		}
+		p.flushOnce()
+	}
+}
+
+// 把截至目前的所有修改写入一次
+func (p *persister) flushOnce() {
+	p.mu.Lock()
+	target := p.dirty
+	if target == p.synced {
+		p.attempts++
+		p.cond.Broadcast()
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+
+	err := p.write()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.attempts++
+	if err != nil {
+		log.Printf("保存%s数据失败: %v", p.name, err)
+		p.lastErr = err
+	} else {
+		p.synced = target
+		p.lastErr = nil
+	}
+	p.cond.Broadcast()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingStorage 记录各个方法的调用次数，未覆盖的Storage方法不应被调用
+type countingStorage struct {
+	Storage
+
+	mu      sync.Mutex
+	puts    int
+	syncs   int
+	syncErr error
+	gate    chan struct{} // 不为nil时，Sync会阻塞到从中读到值
+}
+
+func (s *countingStorage) PutTodo(todo Todo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.puts++
+	return nil
+}
+
+func (s *countingStorage) Sync() error {
+	if s.gate != nil {
+		<-s.gate
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncs++
+	return s.syncErr
+}
+
+func (s *countingStorage) counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.puts, s.syncs
+}
+
+// 刷新间隔足够长，测试中只有Flush和积压过多会触发写入
+const testFlushInterval = time.Hour
+
+func TestPersisterCoalescesWrites(t *testing.T) {
+	storage := &countingStorage{}
+	p := newPersister("测试", storage.Sync, testFlushInterval)
+
+	for i := 0; i < 100; i++ {
+		p.save(func() error { return storage.PutTodo(Todo{ID: i}) })
+	}
+	if puts, syncs := storage.counts(); puts != 100 || syncs != 0 {
+		t.Fatalf("before Flush: puts = %d, syncs = %d, want 100 and 0", puts, syncs)
+	}
+
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, syncs := storage.counts(); syncs != 1 {
+		t.Fatalf("syncs = %d, want 100 modifications coalesced into 1", syncs)
+	}
+
+	// 没有新的修改时Flush不会再写入
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, syncs := storage.counts(); syncs != 1 {
+		t.Errorf("syncs = %d after an idle Flush, want 1", syncs)
+	}
+}
+
+func TestPersisterBackPressure(t *testing.T) {
+	storage := &countingStorage{gate: make(chan struct{})}
+	p := newPersister("测试", storage.Sync, testFlushInterval)
+
+	for i := 0; i < PERSIST_MAX_PENDING-1; i++ {
+		p.markDirty()
+	}
+	if _, syncs := storage.counts(); syncs != 0 {
+		t.Fatalf("syncs = %d below the pending limit, want 0", syncs)
+	}
+
+	// 达到上限的那次修改要等工作协程写完才返回
+	done := make(chan struct{})
+	go func() {
+		p.markDirty()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("markDirty returned while the write was still blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	storage.gate <- struct{}{}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("markDirty still blocked after the write completed")
+	}
+	if _, syncs := storage.counts(); syncs != 1 {
+		t.Errorf("syncs = %d, want 1", syncs)
+	}
+}
+
+func TestPersisterBackPressureReleasedOnError(t *testing.T) {
+	storage := &countingStorage{syncErr: errors.New("disk full")}
+	p := newPersister("测试", storage.Sync, testFlushInterval)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < PERSIST_MAX_PENDING; i++ {
+			p.markDirty()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("markDirty blocked forever after a failed write")
+	}
+}
+
+// 关闭服务时Flush要把所有积压的修改写完，写入失败时返回错误并在下次重试
+func TestPersisterFlushDrains(t *testing.T) {
+	storage := &countingStorage{syncErr: errors.New("disk full")}
+	p := newPersister("测试", storage.Sync, testFlushInterval)
+
+	for i := 0; i < 10; i++ {
+		p.save(func() error { return storage.PutTodo(Todo{ID: i}) })
+	}
+
+	if err := p.Flush(); err == nil {
+		t.Fatal("Flush returned nil after a failed write")
+	}
+
+	storage.mu.Lock()
+	storage.syncErr = nil
+	storage.mu.Unlock()
+
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, syncs := storage.counts(); syncs != 2 {
+		t.Errorf("syncs = %d, want the failed write and the retry", syncs)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.synced != p.dirty {
+		t.Errorf("synced = %d, dirty = %d after Flush", p.synced, p.dirty)
+	}
+}
+
+// 存储后端拒绝修改时，错误要返回给调用者，修改仍会标记为需要落盘
+func TestPersisterSaveReturnsError(t *testing.T) {
+	storage := &countingStorage{}
+	p := newPersister("测试", storage.Sync, testFlushInterval)
+
+	if err := p.save(func() error { return errStorageRejected }); !errors.Is(err, errStorageRejected) {
+		t.Errorf("save() = %v, want the storage error", err)
+	}
+	if err := p.save(func() error { return nil }); err != nil {
+		t.Errorf("save() = %v, want nil", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dirty != 2 {
+		t.Errorf("dirty = %d, want 2", p.dirty)
+	}
+}
+
+// rejectingStorage 拒绝所有待办事项的写入
+type rejectingStorage struct {
+	Storage
+}
+
+var errStorageRejected = errors.New("database is locked")
+
+func (s rejectingStorage) PutTodo(todo Todo) error { return errStorageRejected }
+
+// 保存失败的修改不能当作成功返回，客户端应收到500
+func TestStorageErrorReachesClient(t *testing.T) {
+	storage := setupTestStores(t)
+	todoStore = NewTodoStore(rejectingStorage{storage}, 0)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+
+	if _, err := todoStore.Add(Actor{UserID: user.ID, Roles: user.Roles}, TodoInput{Title: "写周报"}); !errors.Is(err, errStorageRejected) {
+		t.Errorf("Add() error = %v, want the storage error", err)
+	}
+
+	session, err := userStore.createSession(user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/todos", strings.NewReader(`{"title":"写周报"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.AddCookie(&http.Cookie{Name: "session_token", Value: session.Token})
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), errStorageRejected.Error()) {
+		t.Errorf("response leaks the storage error: %s", w.Body.String())
+	}
+}
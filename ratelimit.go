@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginLimits 是登录和注册的频率限制与账号锁定参数
+type LoginLimits struct {
+	Window           time.Duration // 登录频率限制的滑动窗口
+	MaxPerIP         int           // 每个IP在窗口内最多尝试登录的次数
+	MaxPerUsername   int           // 每个用户名在窗口内最多被尝试登录的次数
+	RegisterWindow   time.Duration // 注册频率限制的滑动窗口
+	MaxRegisterPerIP int           // 每个IP在窗口内最多注册的次数
+	LockoutThreshold int           // 在锁定时长内连续失败多少次后锁定账号
+	LockoutDuration  time.Duration // 账号锁定时长
+}
+
+// 默认的登录限制
+var loginLimits = LoginLimits{
+	Window:           time.Minute,
+	MaxPerIP:         20,
+	MaxPerUsername:   10,
+	RegisterWindow:   time.Hour,
+	MaxRegisterPerIP: 10,
+	LockoutThreshold: 5,
+	LockoutDuration:  15 * time.Minute,
+}
+
+// 清理过期记录的间隔
+const RATE_LIMIT_REAP_INTERVAL = 5 * time.Minute
+
+// 频率限制和账号锁定使用的当前时间，测试中可以替换
+var rateLimitNow = time.Now
+
+// slidingWindow 按键统计滑动窗口内的请求次数
+type slidingWindow struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[string][]time.Time
+}
+
+func newSlidingWindow(limit int, window time.Duration) *slidingWindow {
+	return &slidingWindow{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string][]time.Time),
+	}
+}
+
+// 删除窗口之外的记录，调用时需持有l.mu
+func (l *slidingWindow) prune(key string, now time.Time) []time.Time {
+	hits := l.hits[key]
+	i := 0
+	for i < len(hits) && now.Sub(hits[i]) >= l.window {
+		i++
+	}
+	hits = hits[i:]
+	if len(hits) == 0 {
+		delete(l.hits, key)
+	} else {
+		l.hits[key] = hits
+	}
+	return hits
+}
+
+// Allow 记录一次请求并判断是否超过限制，超过时返回需要等待的时间。limit为0表示不限制
+func (l *slidingWindow) Allow(key string) (time.Duration, bool) {
+	if l.limit <= 0 {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := rateLimitNow()
+	hits := l.prune(key, now)
+	if len(hits) >= l.limit {
+		return l.window - now.Sub(hits[0]), false
+	}
+	l.hits[key] = append(hits, now)
+	return 0, true
+}
+
+// Add 记录一次事件，返回窗口内的事件次数
+func (l *slidingWindow) Add(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := rateLimitNow()
+	hits := append(l.prune(key, now), now)
+	l.hits[key] = hits
+	return len(hits)
+}
+
+// Reset 清除键的所有记录
+func (l *slidingWindow) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.hits, key)
+}
+
+// 清理所有过期记录
+func (l *slidingWindow) reap() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := rateLimitNow()
+	for key := range l.hits {
+		l.prune(key, now)
+	}
+}
+
+// lockoutTracker 记录每个用户名的登录失败，连续失败过多时临时锁定
+type lockoutTracker struct {
+	mu          sync.Mutex
+	failures    *slidingWindow
+	threshold   int
+	duration    time.Duration
+	lockedUntil map[string]time.Time
+}
+
+func newLockoutTracker(threshold int, duration time.Duration) *lockoutTracker {
+	return &lockoutTracker{
+		failures:    newSlidingWindow(threshold, duration),
+		threshold:   threshold,
+		duration:    duration,
+		lockedUntil: make(map[string]time.Time),
+	}
+}
+
+// Locked 判断用户名是否处于锁定状态，返回剩余的锁定时间
+func (t *lockoutTracker) Locked(username string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	until, ok := t.lockedUntil[username]
+	if !ok {
+		return 0, false
+	}
+	if remaining := until.Sub(rateLimitNow()); remaining > 0 {
+		return remaining, true
+	}
+	delete(t.lockedUntil, username)
+	return 0, false
+}
+
+// Fail 记录一次登录失败，达到阈值时锁定用户名并返回true。阈值为0表示不锁定
+func (t *lockoutTracker) Fail(username string) bool {
+	if t.threshold <= 0 || t.failures.Add(username) < t.threshold {
+		return false
+	}
+
+	// 锁定结束后重新计数
+	t.failures.Reset(username)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lockedUntil[username] = rateLimitNow().Add(t.duration)
+	return true
+}
+
+// Reset 登录成功后清除失败记录
+func (t *lockoutTracker) Reset(username string) {
+	t.failures.Reset(username)
+}
+
+// 清理过期的失败记录和锁定
+func (t *lockoutTracker) reap() {
+	t.failures.reap()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := rateLimitNow()
+	for username, until := range t.lockedUntil {
+		if now.After(until) {
+			delete(t.lockedUntil, username)
+		}
+	}
+}
+
+// LoginGuard 对登录和注册请求做频率限制和账号锁定
+type LoginGuard struct {
+	loginByIP    *slidingWindow
+	loginByUser  *slidingWindow
+	registerByIP *slidingWindow
+	lockout      *lockoutTracker
+}
+
+// 全局登录保护，在main中按命令行参数创建
+var loginGuard = newLoginGuard(loginLimits)
+
+func newLoginGuard(limits LoginLimits) *LoginGuard {
+	return &LoginGuard{
+		loginByIP:    newSlidingWindow(limits.MaxPerIP, limits.Window),
+		loginByUser:  newSlidingWindow(limits.MaxPerUsername, limits.Window),
+		registerByIP: newSlidingWindow(limits.MaxRegisterPerIP, limits.RegisterWindow),
+		lockout:      newLockoutTracker(limits.LockoutThreshold, limits.LockoutDuration),
+	}
+}
+
+// 定期清理过期记录，ctx取消后返回
+func (g *LoginGuard) reap(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		g.loginByIP.reap()
+		g.loginByUser.reap()
+		g.registerByIP.reap()
+		g.lockout.reap()
+	}
+}
+
+// 用户名不区分大小写和首尾空白，避免绕过按用户名的限制
+func limitKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// CheckLogin 检查是否允许本次登录尝试，不允许时返回需要等待的时间和面向用户的原因
+func (g *LoginGuard) CheckLogin(ip, username string) (time.Duration, error) {
+	if remaining, locked := g.lockout.Locked(limitKey(username)); locked {
+		return remaining, fmt.Errorf("登录失败次数过多，账号已被临时锁定，请%s后再试", formatWait(remaining))
+	}
+	if wait, ok := g.loginByIP.Allow(ip); !ok {
+		auditLog.Record(AuditEvent{Event: AUDIT_RATE_LIMITED, Username: username, IP: ip, Detail: "login per IP"})
+		return wait, fmt.Errorf("登录请求过于频繁，请%s后再试", formatWait(wait))
+	}
+	if wait, ok := g.loginByUser.Allow(limitKey(username)); !ok {
+		auditLog.Record(AuditEvent{Event: AUDIT_RATE_LIMITED, Username: username, IP: ip, Detail: "login per username"})
+		return wait, fmt.Errorf("登录请求过于频繁，请%s后再试", formatWait(wait))
+	}
+	return 0, nil
+}
+
+// LoginFailed 记录一次密码错误，达到阈值时锁定账号并写入审计日志
+func (g *LoginGuard) LoginFailed(ip, username string) {
+	if g.lockout.Fail(limitKey(username)) {
+		auditLog.Record(AuditEvent{
+			Event:    AUDIT_LOGIN_LOCKED,
+			Username: username,
+			IP:       ip,
+			Detail:   fmt.Sprintf("locked for %s", loginLimits.LockoutDuration),
+		})
+	}
+}
+
+// LoginSucceeded 登录成功后清除失败记录
+func (g *LoginGuard) LoginSucceeded(username string) {
+	g.lockout.Reset(limitKey(username))
+}
+
+// CheckRegister 检查是否允许本次注册
+func (g *LoginGuard) CheckRegister(ip string) (time.Duration, error) {
+	if wait, ok := g.registerByIP.Allow(ip); !ok {
+		auditLog.Record(AuditEvent{Event: AUDIT_RATE_LIMITED, IP: ip, Detail: "register per IP"})
+		return wait, fmt.Errorf("注册请求过于频繁，请%s后再试", formatWait(wait))
+	}
+	return 0, nil
+}
+
+// 把等待时间格式化为面向用户的文字
+func formatWait(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d秒", int(math.Ceil(d.Seconds())))
+	}
+	return fmt.Sprintf("%d分钟", int(math.Ceil(d.Minutes())))
+}
+
+// 返回429响应，并通过Retry-After告诉客户端需要等待的秒数
+func writeTooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration, err error) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeProblem(w, r, http.StatusTooManyRequests, err.Error())
+}
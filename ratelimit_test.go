@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 把rateLimitNow替换为可以手动推进的时钟，测试结束后恢复
+func setRateLimitClock(t *testing.T) func(time.Duration) {
+	t.Helper()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	old := rateLimitNow
+	rateLimitNow = func() time.Time { return now }
+	t.Cleanup(func() { rateLimitNow = old })
+
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestSlidingWindow(t *testing.T) {
+	advance := setRateLimitClock(t)
+	l := newSlidingWindow(3, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if _, ok := l.Allow("a"); !ok {
+			t.Fatalf("request %d rejected below the limit", i+1)
+		}
+		advance(10 * time.Second)
+	}
+	wait, ok := l.Allow("a")
+	if ok {
+		t.Fatal("fourth request within the window allowed")
+	}
+	// 最早的请求在30秒前，还要等30秒才滑出窗口
+	if wait != 30*time.Second {
+		t.Errorf("wait = %v, want 30s", wait)
+	}
+
+	// 其他键不受影响
+	if _, ok := l.Allow("b"); !ok {
+		t.Error("other key rejected")
+	}
+
+	advance(wait)
+	if _, ok := l.Allow("a"); !ok {
+		t.Error("request rejected after the oldest hit left the window")
+	}
+}
+
+// 连续失败达到阈值后锁定，锁定时长过后自动解锁，登录成功会清除失败记录
+func TestLoginLockout(t *testing.T) {
+	advance := setRateLimitClock(t)
+	limits := loginLimits
+	limits.LockoutThreshold = 3
+	limits.LockoutDuration = 15 * time.Minute
+	g := newLoginGuard(limits)
+
+	g.LoginFailed("1.1.1.1", "alice")
+	g.LoginFailed("1.1.1.1", "alice")
+	g.LoginSucceeded("alice")
+	g.LoginFailed("1.1.1.1", "alice")
+	g.LoginFailed("1.1.1.1", "alice")
+	if _, err := g.CheckLogin("1.1.1.1", "alice"); err != nil {
+		t.Fatalf("locked after a success reset the failures: %v", err)
+	}
+
+	// 用户名不区分大小写，换IP也不能绕过锁定
+	g.LoginFailed("2.2.2.2", " Alice ")
+	wait, err := g.CheckLogin("3.3.3.3", "alice")
+	if err == nil {
+		t.Fatal("not locked after reaching the threshold")
+	}
+	if wait != limits.LockoutDuration {
+		t.Errorf("wait = %v, want %v", wait, limits.LockoutDuration)
+	}
+	if _, err := g.CheckLogin("1.1.1.1", "bob"); err != nil {
+		t.Errorf("other user locked: %v", err)
+	}
+
+	advance(limits.LockoutDuration - time.Second)
+	if _, err := g.CheckLogin("1.1.1.1", "alice"); err == nil {
+		t.Error("unlocked before the lockout duration")
+	}
+	advance(time.Second)
+	if _, err := g.CheckLogin("1.1.1.1", "alice"); err != nil {
+		t.Errorf("still locked after the lockout duration: %v", err)
+	}
+}
+
+// 每个IP的登录和注册次数分别受限，不同IP互不影响
+func TestLoginGuardPerIPLimits(t *testing.T) {
+	advance := setRateLimitClock(t)
+	limits := loginLimits
+	limits.MaxPerIP = 2
+	limits.MaxPerUsername = 100
+	limits.MaxRegisterPerIP = 1
+	g := newLoginGuard(limits)
+
+	for i, username := range []string{"alice", "bob"} {
+		if _, err := g.CheckLogin("1.1.1.1", username); err != nil {
+			t.Fatalf("attempt %d rejected: %v", i+1, err)
+		}
+	}
+	if _, err := g.CheckLogin("1.1.1.1", "carol"); err == nil {
+		t.Error("third login from the same IP allowed")
+	}
+	if _, err := g.CheckLogin("2.2.2.2", "carol"); err != nil {
+		t.Errorf("login from another IP rejected: %v", err)
+	}
+
+	if _, err := g.CheckRegister("1.1.1.1"); err != nil {
+		t.Fatalf("first registration rejected: %v", err)
+	}
+	if _, err := g.CheckRegister("1.1.1.1"); err == nil {
+		t.Error("second registration from the same IP allowed")
+	}
+
+	advance(limits.Window)
+	if _, err := g.CheckLogin("1.1.1.1", "carol"); err != nil {
+		t.Errorf("login rejected after the window passed: %v", err)
+	}
+}
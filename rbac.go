@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 用户角色，一个用户可以有多个角色，权限为各角色权限的并集
+const (
+	ROLE_ADMIN     = "admin"     // 管理员，拥有所有权限
+	ROLE_MODERATOR = "moderator" // 版主，可以管理所有博客评论
+	ROLE_MEMBER    = "member"    // 普通成员，注册用户的默认角色
+	ROLE_READONLY  = "readonly"  // 只读用户，只能查看
+)
+
+// Permission 表示一项可以授予角色的操作权限
+type Permission string
+
+const (
+	PERM_TODO_WRITE       Permission = "todo:write"       // 创建和修改待办事项、标签和列表
+	PERM_TODO_MANAGE_ALL  Permission = "todo:manage_all"  // 查看和修改所有用户的待办事项和列表
+	PERM_BLOG_WRITE       Permission = "blog:write"       // 发表博客和评论
+	PERM_COMMENT_MODERATE Permission = "comment:moderate" // 删除任何博客评论
+	PERM_SESSION_MANAGE   Permission = "session:manage"   // 查看和撤销其他用户的会话
+	PERM_ROLE_MANAGE      Permission = "role:manage"      // 授予和撤销用户角色
+	PERM_USER_MANAGE      Permission = "user:manage"      // 查看、禁用、重置密码和删除用户
+)
+
+// 所有角色，按权限从高到低排列
+var allRoles = []string{ROLE_ADMIN, ROLE_MODERATOR, ROLE_MEMBER, ROLE_READONLY}
+
+// 每个角色拥有的权限
+var rolePermissions = map[string][]Permission{
+	ROLE_ADMIN: {
+		PERM_TODO_WRITE, PERM_TODO_MANAGE_ALL, PERM_BLOG_WRITE,
+		PERM_COMMENT_MODERATE, PERM_SESSION_MANAGE, PERM_ROLE_MANAGE, PERM_USER_MANAGE,
+	},
+	ROLE_MODERATOR: {PERM_TODO_WRITE, PERM_BLOG_WRITE, PERM_COMMENT_MODERATE},
+	ROLE_MEMBER:    {PERM_TODO_WRITE, PERM_BLOG_WRITE},
+	ROLE_READONLY:  {},
+}
+
+// ErrLastAdmin 表示操作会使系统中没有管理员，如撤销、禁用或删除最后一个管理员
+var ErrLastAdmin = domainError(ErrConflict, "last_admin", "不能移除最后一个管理员")
+
+// 判断角色名是否有效
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// 判断角色列表中是否包含指定角色
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断角色列表是否拥有指定权限
+func rolesCan(roles []string, perm Permission) bool {
+	for _, role := range roles {
+		for _, p := range rolePermissions[role] {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 返回角色列表拥有的所有权限，按allRoles中的顺序去重
+func rolesPermissions(roles []string) []Permission {
+	seen := make(map[Permission]bool)
+	perms := make([]Permission, 0)
+	for _, role := range allRoles {
+		if !hasRole(roles, role) {
+			continue
+		}
+		for _, p := range rolePermissions[role] {
+			if !seen[p] {
+				seen[p] = true
+				perms = append(perms, p)
+			}
+		}
+	}
+	return perms
+}
+
+// Can 判断用户是否拥有指定权限
+func (a Actor) Can(perm Permission) bool {
+	return rolesCan(a.Roles, perm)
+}
+
+// 旧版本只有IsAdmin标记，没有角色的用户按该标记迁移为admin或member，调用时需持有s.mu。
+// 返回是否有用户被迁移，以及写回存储时的错误
+func (s *UserStore) migrateRoles() (bool, error) {
+	migrated := false
+	var err error
+	for i, user := range s.users {
+		if user.Roles != nil {
+			continue
+		}
+		if user.IsAdmin {
+			s.users[i].Roles = []string{ROLE_ADMIN}
+		} else {
+			s.users[i].Roles = []string{ROLE_MEMBER}
+		}
+		s.users[i].IsAdmin = false
+		err = errors.Join(err, s.persistUser(s.users[i]))
+		migrated = true
+	}
+	return migrated, err
+}
+
+// 查找用户的下标，调用时需持有s.mu
+func (s *UserStore) userIndex(userID int) int {
+	for i, user := range s.users {
+		if user.ID == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+// 统计未被禁用的管理员数量，调用时需持有s.mu
+func (s *UserStore) countAdmins() int {
+	count := 0
+	for _, user := range s.users {
+		if hasRole(user.Roles, ROLE_ADMIN) && !user.Disabled {
+			count++
+		}
+	}
+	return count
+}
+
+// GetRoles 返回用户当前的角色
+func (s *UserStore) GetRoles(userID int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.userIndex(userID)
+	if i < 0 {
+		return nil, fmt.Errorf("user with ID %d: %w", userID, ErrNotFound)
+	}
+	return append([]string(nil), s.users[i].Roles...), nil
+}
+
+// GrantRole 为用户添加角色，已有该角色时不做修改
+func (s *UserStore) GrantRole(userID int, role string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.userIndex(userID)
+	if i < 0 {
+		return User{}, fmt.Errorf("user with ID %d: %w", userID, ErrNotFound)
+	}
+	if hasRole(s.users[i].Roles, role) {
+		return s.users[i], nil
+	}
+
+	// 按值返回的User与这里共用Roles的底层数组，直接append可能改写调用者看到的角色
+	roles := append([]string(nil), s.users[i].Roles...)
+	s.users[i].Roles = append(roles, role)
+
+	// 保存数据
+	if err := s.persistUser(s.users[i]); err != nil {
+		return User{}, err
+	}
+
+	return s.users[i], nil
+}
+
+// RevokeRole 撤销用户的角色，不能撤销最后一个管理员的管理员角色
+func (s *UserStore) RevokeRole(userID int, role string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.userIndex(userID)
+	if i < 0 {
+		return User{}, fmt.Errorf("user with ID %d: %w", userID, ErrNotFound)
+	}
+	if !hasRole(s.users[i].Roles, role) {
+		return s.users[i], nil
+	}
+	if role == ROLE_ADMIN && !s.users[i].Disabled && s.countAdmins() <= 1 {
+		return User{}, ErrLastAdmin
+	}
+
+	roles := make([]string, 0, len(s.users[i].Roles))
+	for _, r := range s.users[i].Roles {
+		if r != role {
+			roles = append(roles, r)
+		}
+	}
+	s.users[i].Roles = roles
+
+	// 保存数据
+	if err := s.persistUser(s.users[i]); err != nil {
+		return User{}, err
+	}
+
+	return s.users[i], nil
+}
+
+// 策略检查：用户没有指定权限时返回403
+func requirePermission(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actor, err := currentActor(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !actor.Can(perm) {
+			writeProblem(w, r, http.StatusForbidden, "权限不足")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// 策略检查：GET和HEAD请求只要求登录，其他请求要求指定权限
+func requireWritePermission(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	guarded := requirePermission(perm, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		guarded(w, r)
+	}
+}
+
+// 用户角色的响应，不包含密码等信息
+func userRolesResponse(user User) map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":     user.ID,
+		"username":    user.Username,
+		"roles":       user.Roles,
+		"permissions": rolesPermissions(user.Roles),
+	}
+}
+
+// 处理角色列表的请求
+// GET /api/admin/roles 列出所有角色及其权限
+func handleRoles(w http.ResponseWriter, r *http.Request) {
+	roles := make([]map[string]interface{}, 0, len(allRoles))
+	for _, role := range allRoles {
+		roles = append(roles, map[string]interface{}{
+			"name":        role,
+			"permissions": rolePermissions[role],
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roles)
+}
+
+// 处理用户角色的请求
+// GET    /api/admin/users/{id}/roles        获取用户的角色和权限
+// PUT    /api/admin/users/{id}/roles/{role} 授予角色
+// DELETE /api/admin/users/{id}/roles/{role} 撤销角色
+func handleUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := pathInt(r, "id")
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var user User
+	role := r.PathValue("role")
+
+	switch r.Method {
+	case http.MethodPut, http.MethodDelete:
+		if !validRole(role) {
+			writeError(w, r, ValidationErrors{"role": "角色必须是" + strings.Join(allRoles, "、") + "之一"})
+			return
+		}
+		if r.Method == http.MethodPut {
+			user, err = userStore.GrantRole(userID, role)
+		} else {
+			user, err = userStore.RevokeRole(userID, role)
+		}
+
+	default:
+		var roles []string
+		roles, err = userStore.GetRoles(userID)
+		user = User{ID: userID, Username: getUsernameByID(userID), Roles: roles}
+	}
+
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(userRolesResponse(user))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 每个角色只能访问拥有相应权限的路由，没有权限时返回403
+func TestRolePolicy(t *testing.T) {
+	setupTestStores(t)
+	target := registerTestUser(t, "target", ROLE_MEMBER)
+	handler := newRouter()
+
+	sessions := make(map[string]string)
+	for _, role := range allRoles {
+		user := registerTestUser(t, "user-"+role, role)
+		session, err := userStore.createSession(user, "test", "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions[role] = session.Token
+	}
+
+	targetRoles := "/api/admin/users/" + strconv.Itoa(target.ID) + "/roles"
+	tests := []struct {
+		method  string
+		target  string
+		body    string
+		allowed []string
+	}{
+		{http.MethodGet, "/api/todos", "", allRoles},
+		{http.MethodPost, "/api/todos", `{"title":"写周报"}`, []string{ROLE_ADMIN, ROLE_MODERATOR, ROLE_MEMBER}},
+		{http.MethodPost, "/api/tags", `{"name":"工作"}`, []string{ROLE_ADMIN, ROLE_MODERATOR, ROLE_MEMBER}},
+		{http.MethodGet, "/api/blogs", "", allRoles},
+		{http.MethodPost, "/api/blogs", `{"title":"标题","content":"内容"}`, []string{ROLE_ADMIN, ROLE_MODERATOR, ROLE_MEMBER}},
+		{http.MethodGet, "/api/admin/users", "", []string{ROLE_ADMIN}},
+		{http.MethodGet, "/api/admin/roles", "", []string{ROLE_ADMIN}},
+		{http.MethodGet, targetRoles, "", []string{ROLE_ADMIN}},
+		{http.MethodPut, targetRoles + "/" + ROLE_READONLY, "", []string{ROLE_ADMIN}},
+	}
+
+	for _, tt := range tests {
+		for _, role := range allRoles {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			r.AddCookie(&http.Cookie{Name: "session_token", Value: sessions[role]})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			allowed := hasRole(tt.allowed, role)
+			if allowed && (w.Code == http.StatusForbidden || w.Code >= 500) {
+				t.Errorf("%s %s as %s: status = %d, want allowed: %s", tt.method, tt.target, role, w.Code, w.Body.String())
+			}
+			if !allowed && w.Code != http.StatusForbidden {
+				t.Errorf("%s %s as %s: status = %d, want 403", tt.method, tt.target, role, w.Code)
+			}
+		}
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	tests := []struct {
+		roles []string
+		perm  Permission
+		want  bool
+	}{
+		{[]string{ROLE_ADMIN}, PERM_USER_MANAGE, true},
+		{[]string{ROLE_MODERATOR}, PERM_COMMENT_MODERATE, true},
+		{[]string{ROLE_MODERATOR}, PERM_USER_MANAGE, false},
+		{[]string{ROLE_MEMBER}, PERM_TODO_WRITE, true},
+		{[]string{ROLE_MEMBER}, PERM_COMMENT_MODERATE, false},
+		{[]string{ROLE_READONLY}, PERM_TODO_WRITE, false},
+		// 多个角色的权限取并集
+		{[]string{ROLE_READONLY, ROLE_MODERATOR}, PERM_TODO_WRITE, true},
+		{[]string{"unknown"}, PERM_TODO_WRITE, false},
+		{nil, PERM_TODO_WRITE, false},
+	}
+
+	for _, tt := range tests {
+		if got := (Actor{Roles: tt.roles}).Can(tt.perm); got != tt.want {
+			t.Errorf("%v.Can(%s) = %v, want %v", tt.roles, tt.perm, got, tt.want)
+		}
+	}
+}
+
+// 旧版本只有IsAdmin标记的用户在加载时迁移为admin或member角色，并写回存储
+func TestMigrateLegacyIsAdmin(t *testing.T) {
+	storage := setupTestStores(t)
+	legacy := []User{
+		{ID: 100, Username: "old-admin", Password: "x", IsAdmin: true},
+		{ID: 101, Username: "old-member", Password: "x"},
+		{ID: 102, Username: "moderator", Password: "x", Roles: []string{ROLE_MODERATOR}},
+	}
+	for _, user := range legacy {
+		if err := storage.PutUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	userStore = NewUserStore(storage, 0)
+	if err := userStore.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int][]string{
+		100: {ROLE_ADMIN},
+		101: {ROLE_MEMBER},
+		102: {ROLE_MODERATOR}, // 已有角色的用户不受影响
+	}
+	users, _, err := storage.LoadUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range users {
+		roles, ok := want[user.ID]
+		if !ok {
+			continue
+		}
+		if strings.Join(user.Roles, ",") != strings.Join(roles, ",") || user.IsAdmin {
+			t.Errorf("user %s: roles = %v, is_admin = %v; want %v, false", user.Username, user.Roles, user.IsAdmin, roles)
+		}
+		delete(want, user.ID)
+	}
+	if len(want) != 0 {
+		t.Errorf("users missing from storage: %v", want)
+	}
+}
+
+// 不能撤销最后一个管理员的管理员角色
+func TestRevokeLastAdmin(t *testing.T) {
+	setupTestStores(t)
+	admin := registerTestUser(t, "root", ROLE_ADMIN)
+
+	// 先撤销默认管理员的角色，root成为最后一个管理员
+	defaultAdmin, _ := getUserIDByUsername(DEFAULT_ADMIN_USERNAME)
+	if _, err := userStore.RevokeRole(defaultAdmin, ROLE_ADMIN); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := userStore.RevokeRole(admin.ID, ROLE_ADMIN); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("revoke last admin: err = %v, want ErrLastAdmin", err)
+	}
+
+	other := registerTestUser(t, "root2", ROLE_MEMBER)
+	if _, err := userStore.GrantRole(other.ID, ROLE_ADMIN); err != nil {
+		t.Fatal(err)
+	}
+	user, err := userStore.RevokeRole(admin.ID, ROLE_ADMIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasRole(user.Roles, ROLE_ADMIN) {
+		t.Errorf("roles = %v after revoking admin", user.Roles)
+	}
+}
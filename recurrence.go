@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 重复频率
+const (
+	FREQ_DAILY   = "daily"
+	FREQ_WEEKLY  = "weekly"
+	FREQ_MONTHLY = "monthly"
+	FREQ_YEARLY  = "yearly"
+)
+
+// 重复间隔和重复次数的上限
+const (
+	RECURRENCE_MAX_INTERVAL = 1000
+	RECURRENCE_MAX_COUNT    = 1000
+)
+
+// RRULE中BYDAY使用的星期缩写
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// Recurrence 描述待办事项的重复规则，语义参考iCalendar的RRULE
+type Recurrence struct {
+	Freq      string   `json:"freq"`                 // daily、weekly、monthly、yearly
+	Interval  int      `json:"interval,omitempty"`   // 间隔，默认为1
+	ByWeekday []string `json:"by_weekday,omitempty"` // 仅用于weekly，如["MO","WE"]
+	Until     string   `json:"until,omitempty"`      // 最后一次的截止日期（含），格式为YYYY-MM-DD
+	Count     int      `json:"count,omitempty"`      // 总共重复的次数，0表示不限
+	Start     string   `json:"start,omitempty"`      // 系列第一次的截止日期，按月和按年重复时以它的日期为准
+}
+
+// UnmarshalJSON 同时支持JSON对象和RRULE字符串，如"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR;COUNT=10"
+func (r *Recurrence) UnmarshalJSON(data []byte) error {
+	var rule string
+	if err := json.Unmarshal(data, &rule); err == nil {
+		parsed, err := parseRRule(rule)
+		if err != nil {
+			return err
+		}
+		*r = parsed
+		return nil
+	}
+
+	// 使用别名类型避免递归调用UnmarshalJSON
+	type plain Recurrence
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+// 解析RRULE字符串
+func parseRRule(rule string) (Recurrence, error) {
+	var r Recurrence
+
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	for _, part := range strings.Split(rule, ";") {
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return r, fmt.Errorf("invalid RRULE part %q", part)
+		}
+
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.Freq = strings.ToLower(value)
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil {
+				return r, fmt.Errorf("invalid RRULE INTERVAL %q", value)
+			}
+			r.Interval = interval
+		case "BYDAY":
+			r.ByWeekday = strings.Split(strings.ToUpper(value), ",")
+		case "UNTIL":
+			// 支持YYYYMMDD和YYYY-MM-DD，忽略时间部分
+			until := value
+			if len(until) >= 8 && !strings.Contains(until, "-") {
+				until = until[:4] + "-" + until[4:6] + "-" + until[6:8]
+			}
+			r.Until = until
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return r, fmt.Errorf("invalid RRULE COUNT %q", value)
+			}
+			r.Count = count
+		default:
+			return r, fmt.Errorf("unsupported RRULE part %q", key)
+		}
+	}
+
+	return r, nil
+}
+
+// Validate 检查重复规则是否有效，并补全默认值。返回的ValidationErrors以Recurrence的JSON字段名为键
+func (r *Recurrence) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	switch r.Freq {
+	case FREQ_DAILY, FREQ_WEEKLY, FREQ_MONTHLY, FREQ_YEARLY:
+	default:
+		errs["freq"] = "重复频率必须是daily、weekly、monthly或yearly"
+	}
+
+	if r.Interval < 0 || r.Interval > RECURRENCE_MAX_INTERVAL {
+		errs["interval"] = fmt.Sprintf("重复间隔必须在1到%d之间", RECURRENCE_MAX_INTERVAL)
+	}
+	if r.Interval == 0 {
+		r.Interval = 1
+	}
+	if r.Count < 0 || r.Count > RECURRENCE_MAX_COUNT {
+		errs["count"] = fmt.Sprintf("重复次数必须在0到%d之间", RECURRENCE_MAX_COUNT)
+	}
+
+	if len(r.ByWeekday) > 0 && r.Freq != FREQ_WEEKLY {
+		errs["by_weekday"] = "只有按周重复时才能指定星期"
+	}
+	for i, code := range r.ByWeekday {
+		code = strings.ToUpper(code)
+		if _, ok := weekdayCodes[code]; !ok {
+			errs["by_weekday"] = "星期必须是MO、TU、WE、TH、FR、SA或SU"
+			break
+		}
+		r.ByWeekday[i] = code
+	}
+
+	if err := validateDate("重复结束日期", r.Until); err != nil {
+		errs["until"] = err.Error()
+	}
+	if err := validateDate("重复开始日期", r.Start); err != nil {
+		errs["start"] = err.Error()
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// 判断日期是否在BYDAY指定的星期中
+func (r *Recurrence) matchesWeekday(date time.Time) bool {
+	for _, code := range r.ByWeekday {
+		if weekdayCodes[code] == date.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// 返回[from, to)中第一个在BYDAY指定的星期中的日期
+func (r *Recurrence) firstWeekdayMatch(from, to time.Time) (time.Time, bool) {
+	for date := from; date.Before(to); date = date.AddDate(0, 0, 1) {
+		if r.matchesWeekday(date) {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// 返回日期所在周的周一
+func weekStart(date time.Time) time.Time {
+	offset := (int(date.Weekday()) + 6) % 7
+	return date.AddDate(0, 0, -offset)
+}
+
+// 返回year年month月的第day天，超过当月天数时取当月最后一天
+func clampedDate(year int, month time.Month, day int) time.Time {
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// Next 返回date之后的下一次日期，occurrence是date在系列中的序号（从1开始）。
+// 达到Count或超过Until时返回false
+func (r *Recurrence) Next(date time.Time, occurrence int) (time.Time, bool) {
+	if r.Count > 0 && occurrence >= r.Count {
+		return time.Time{}, false
+	}
+
+	interval := r.Interval
+	if interval <= 0 {
+		interval = 1
+	}
+
+	anchor := date
+	if start, err := time.Parse(DATE_LAYOUT, r.Start); err == nil {
+		anchor = start
+	}
+
+	var next time.Time
+	switch r.Freq {
+	case FREQ_DAILY:
+		next = date.AddDate(0, 0, interval)
+
+	case FREQ_WEEKLY:
+		if len(r.ByWeekday) == 0 {
+			next = date.AddDate(0, 0, 7*interval)
+			break
+		}
+		// 先在当前周的剩余日期中查找，找不到时跳到interval周之后的那一周，取其中第一个匹配的星期
+		var ok bool
+		if next, ok = r.firstWeekdayMatch(date.AddDate(0, 0, 1), weekStart(date).AddDate(0, 0, 7)); ok {
+			break
+		}
+		week := weekStart(date).AddDate(0, 0, 7*interval)
+		if next, ok = r.firstWeekdayMatch(week, week.AddDate(0, 0, 7)); !ok {
+			return time.Time{}, false
+		}
+
+	case FREQ_MONTHLY:
+		month := date.Month() + time.Month(interval)
+		next = clampedDate(date.Year(), month, anchor.Day())
+
+	case FREQ_YEARLY:
+		next = clampedDate(date.Year()+interval, anchor.Month(), anchor.Day())
+
+	default:
+		return time.Time{}, false
+	}
+
+	if r.Until != "" && next.Format(DATE_LAYOUT) > r.Until {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// String 返回RRULE格式的重复规则
+func (r *Recurrence) String() string {
+	parts := []string{"FREQ=" + strings.ToUpper(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByWeekday) > 0 {
+		parts = append(parts, "BYDAY="+strings.Join(r.ByWeekday, ","))
+	}
+	if r.Until != "" {
+		parts = append(parts, "UNTIL="+strings.ReplaceAll(r.Until, "-", ""))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	return strings.Join(parts, ";")
+}
+
+// 为刚完成的重复待办事项生成下一次的实例，调用时需持有s.mu。
+// 每个实例只会生成一次后续实例，反复切换完成状态不会产生重复的待办事项
+func (s *TodoStore) spawnNextOccurrence(i int) error {
+	current := s.todos[i]
+	if current.Recurrence == nil || current.NextID != 0 {
+		return nil
+	}
+
+	// 以截止日期为基准，没有截止日期时以完成当天为基准
+	base, err := time.Parse(DATE_LAYOUT, current.DueDate)
+	if err != nil {
+		base, _ = time.Parse(DATE_LAYOUT, today())
+	}
+
+	occurrence := current.Occurrence
+	if occurrence == 0 {
+		occurrence = 1
+	}
+
+	nextDue, ok := current.Recurrence.Next(base, occurrence)
+	if !ok {
+		return nil
+	}
+
+	// 开始日期与截止日期保持相同的间隔
+	startDate := ""
+	if start, err := time.Parse(DATE_LAYOUT, current.StartDate); err == nil {
+		startDate = nextDue.Add(start.Sub(base)).Format(DATE_LAYOUT)
+	}
+
+	seriesID := current.SeriesID
+	if seriesID == 0 {
+		seriesID = current.ID
+	}
+
+	recurrence := *current.Recurrence
+	next := Todo{
+		ID:         s.nextID,
+		UserID:     current.UserID,
+		Username:   current.Username,
+		Title:      current.Title,
+		Priority:   current.Priority,
+		Order:      s.nextOrder(current.UserID, current.ListID, current.ParentID),
+		ListID:     current.ListID,
+		ParentID:   current.ParentID,
+		StartDate:  startDate,
+		DueDate:    nextDue.Format(DATE_LAYOUT),
+		Notes:      current.Notes,
+		Tags:       append([]string(nil), current.Tags...),
+		AssigneeID: current.AssigneeID,
+		Assignee:   current.Assignee,
+		Recurrence: &recurrence,
+		SeriesID:   seriesID,
+		Occurrence: occurrence + 1,
+	}
+	s.nextID++
+
+	s.todos[i].SeriesID = seriesID
+	s.todos[i].Occurrence = occurrence
+	s.todos[i].NextID = next.ID
+	s.todos = append(s.todos, next)
+
+	// 保存数据
+	return errors.Join(s.persistTodo(s.todos[i]), s.persistTodo(next))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecurrenceNextWeekly(t *testing.T) {
+	tests := []struct {
+		rule string
+		date string
+		want string
+	}{
+		// 2024-01-01是周一
+		{"FREQ=WEEKLY;BYDAY=MO,FR", "2024-01-01", "2024-01-05"},
+		{"FREQ=WEEKLY;BYDAY=MO,FR", "2024-01-05", "2024-01-08"},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR", "2024-01-05", "2024-01-15"},
+		{"FREQ=WEEKLY;INTERVAL=3;BYDAY=WE", "2024-01-03", "2024-01-24"},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=SU", "2024-01-07", "2024-01-21"},
+		{"FREQ=WEEKLY;INTERVAL=2", "2024-01-03", "2024-01-17"},
+		{"FREQ=WEEKLY;INTERVAL=1000;BYDAY=MO", "2024-01-01", "2043-03-02"},
+	}
+
+	for _, tt := range tests {
+		r, err := parseRRule(tt.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errs := r.Validate(); errs != nil {
+			t.Fatalf("%s: %v", tt.rule, errs)
+		}
+		date, _ := time.Parse(DATE_LAYOUT, tt.date)
+
+		next, ok := r.Next(date, 1)
+		if !ok || next.Format(DATE_LAYOUT) != tt.want {
+			t.Errorf("%s after %s = %s, %v; want %s", tt.rule, tt.date, next.Format(DATE_LAYOUT), ok, tt.want)
+		}
+	}
+}
+
+// 校验之前保存的超大间隔也不会逐日循环
+func TestRecurrenceNextHugeInterval(t *testing.T) {
+	r := Recurrence{Freq: FREQ_WEEKLY, Interval: 1000000000, ByWeekday: []string{"MO"}}
+	date, _ := time.Parse(DATE_LAYOUT, "2024-01-01")
+
+	done := make(chan struct{})
+	go func() {
+		r.Next(date, 1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Next did not return for a huge weekly interval")
+	}
+}
+
+func TestRecurrenceValidateLimits(t *testing.T) {
+	tests := []struct {
+		rule  string
+		field string
+	}{
+		{"FREQ=WEEKLY;INTERVAL=1000000000;BYDAY=MO", "interval"},
+		{"FREQ=DAILY;INTERVAL=-1", "interval"},
+		{"FREQ=DAILY;COUNT=1000000", "count"},
+		{"FREQ=DAILY;COUNT=-1", "count"},
+		{"FREQ=HOURLY", "freq"},
+		{"FREQ=DAILY;BYDAY=MO", "by_weekday"},
+	}
+
+	for _, tt := range tests {
+		r, err := parseRRule(tt.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errs := r.Validate()
+		if _, ok := errs[tt.field]; !ok {
+			t.Errorf("%s: errors = %v, want an error for %s", tt.rule, errs, tt.field)
+		}
+	}
+
+	r := Recurrence{Freq: FREQ_DAILY, Interval: RECURRENCE_MAX_INTERVAL, Count: RECURRENCE_MAX_COUNT}
+	if errs := r.Validate(); errs != nil {
+		t.Errorf("limits themselves should be valid: %v", errs)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 旧版URL与新URL的对应关系。旧URL仍然可用，请求会转发到新的路由，
+// 响应中带有Deprecation和Link头，提示客户端改用新URL
+var legacyRoutes = []struct {
+	pattern string // 旧的方法和路径
+	target  string // 新的方法和路径，{name}取自旧路径中的同名参数
+}{
+	{"POST /api/todos/toggle/{id}", "POST /api/todos/{id}/toggle"},
+	{"DELETE /api/todos/mark-deleted/{id}", "POST /api/todos/{id}/mark-deleted"},
+	{"DELETE /api/todos/delete/{id}", "DELETE /api/todos/{id}"},
+	{"GET /api/blogs/user/{id}", "GET /api/users/{id}/blogs"},
+	{"POST /api/blogs/comments/{id}", "POST /api/blogs/{id}/comments"},
+	{"DELETE /api/blogs/comments/{id}/{comment_id}", "DELETE /api/blogs/{id}/comments/{comment_id}"},
+}
+
+// 注册所有路由
+func registerRoutes(mux *http.ServeMux) {
+	// 静态文件服务
+	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+
+	// 用户相关路由
+	mux.HandleFunc("GET /register", requireFeature(features.Registration, handleRegister))
+	mux.HandleFunc("POST /register", requireFeature(features.Registration, handleRegister))
+	mux.HandleFunc("GET /login", handleLogin)
+	mux.HandleFunc("POST /login", handleLogin)
+	mux.HandleFunc("GET /login/2fa", handleLoginTwoFactor)
+	mux.HandleFunc("POST /login/2fa", handleLoginTwoFactor)
+	mux.HandleFunc("GET /auth/oidc/login", handleOIDCLogin)
+	mux.HandleFunc("GET /auth/oidc/callback", handleOIDCCallback)
+	mux.HandleFunc("GET /logout", handleLogout)
+	mux.HandleFunc("POST /logout", handleLogout)
+	mux.HandleFunc("GET /change-password", authMiddleware(handleChangePassword))
+	mux.HandleFunc("POST /change-password", authMiddleware(handleChangePassword))
+	mux.HandleFunc("GET /api/sessions", authMiddleware(handleSessions))
+	mux.HandleFunc("DELETE /api/sessions", authMiddleware(handleSessions))
+	mux.HandleFunc("DELETE /api/sessions/{id}", authMiddleware(handleSession))
+	mux.HandleFunc("GET /api/tokens", authMiddleware(handleTokens))
+	mux.HandleFunc("POST /api/tokens", authMiddleware(handleTokens))
+	mux.HandleFunc("DELETE /api/tokens/{id}", authMiddleware(handleToken))
+	mux.HandleFunc("GET /api/2fa", authMiddleware(handleTwoFactor))
+	mux.HandleFunc("POST /api/2fa/{action}", authMiddleware(handleTwoFactor))
+
+	// 管理 API 路由（需要角色管理或用户管理权限）
+	userManage := func(h http.HandlerFunc) http.HandlerFunc {
+		return authMiddleware(requirePermission(PERM_USER_MANAGE, h))
+	}
+	roleManage := func(h http.HandlerFunc) http.HandlerFunc {
+		return authMiddleware(requirePermission(PERM_ROLE_MANAGE, h))
+	}
+	mux.HandleFunc("GET /api/admin/roles", roleManage(handleRoles))
+	mux.HandleFunc("GET /api/admin/users", userManage(handleAdminUsers))
+	mux.HandleFunc("GET /api/admin/users/{id}", userManage(handleAdminUser))
+	mux.HandleFunc("PATCH /api/admin/users/{id}", userManage(handleAdminUser))
+	mux.HandleFunc("DELETE /api/admin/users/{id}", userManage(handleAdminUser))
+	mux.HandleFunc("POST /api/admin/users/{id}/reset-password", userManage(handleAdminResetPassword))
+	mux.HandleFunc("DELETE /api/admin/users/{id}/2fa", userManage(handleAdminResetTwoFactor))
+	mux.HandleFunc("GET /api/admin/users/{id}/roles", roleManage(handleUserRoles))
+	mux.HandleFunc("PUT /api/admin/users/{id}/roles/{role}", roleManage(handleUserRoles))
+	mux.HandleFunc("DELETE /api/admin/users/{id}/roles/{role}", roleManage(handleUserRoles))
+
+	// 待办事项 API 路由（需要认证）
+	todoWrite := func(h http.HandlerFunc) http.HandlerFunc {
+		return authMiddleware(requireWritePermission(PERM_TODO_WRITE, h))
+	}
+	mux.HandleFunc("GET /api/current-user", authMiddleware(handleCurrentUser))
+	mux.HandleFunc("GET /api/todos", todoWrite(handleTodos))
+	mux.HandleFunc("POST /api/todos", todoWrite(handleTodos))
+	mux.HandleFunc("GET /api/completed-todos", authMiddleware(handleCompletedTodos))
+	mux.HandleFunc("PATCH /api/todos/{id}", todoWrite(handleTodo))
+	mux.HandleFunc("DELETE /api/todos/{id}", todoWrite(handleDeleteTodo))
+	mux.HandleFunc("POST /api/todos/{id}/toggle", todoWrite(handleToggleTodo))
+	mux.HandleFunc("POST /api/todos/{id}/mark-deleted", todoWrite(handleMarkTodoAsDeleted))
+	mux.HandleFunc("POST /api/todos/update-order", todoWrite(handleUpdateTodoOrder))
+	mux.HandleFunc("POST /api/todos/update-dates", todoWrite(handleUpdateTodoDates))
+	mux.HandleFunc("GET /api/tags", todoWrite(handleTags))
+	mux.HandleFunc("POST /api/tags", todoWrite(handleTags))
+	mux.HandleFunc("PATCH /api/tags/{id}", todoWrite(handleTag))
+	mux.HandleFunc("DELETE /api/tags/{id}", todoWrite(handleTag))
+	mux.HandleFunc("GET /api/lists", todoWrite(handleLists))
+	mux.HandleFunc("POST /api/lists", todoWrite(handleLists))
+	mux.HandleFunc("GET /api/lists/{id}", todoWrite(handleList))
+	mux.HandleFunc("PATCH /api/lists/{id}", todoWrite(handleList))
+	mux.HandleFunc("DELETE /api/lists/{id}", todoWrite(handleList))
+	mux.HandleFunc("POST /api/lists/{id}/shares", todoWrite(handleShareList))
+	mux.HandleFunc("DELETE /api/lists/{id}/shares/{user_id}", todoWrite(handleUnshareList))
+
+	// 博客 API 路由（需要认证）
+	blogs := func(h http.HandlerFunc) http.HandlerFunc {
+		return requireFeature(features.Blogs, authMiddleware(h))
+	}
+	blogWrite := func(h http.HandlerFunc) http.HandlerFunc {
+		return blogs(requireWritePermission(PERM_BLOG_WRITE, h))
+	}
+	mux.HandleFunc("GET /api/blogs", blogWrite(handleBlogs))
+	mux.HandleFunc("POST /api/blogs", blogWrite(handleBlogs))
+	mux.HandleFunc("GET /api/blogs/{id}", blogWrite(handleBlog))
+	mux.HandleFunc("PUT /api/blogs/{id}", blogWrite(handleBlog))
+	mux.HandleFunc("DELETE /api/blogs/{id}", blogWrite(handleBlog))
+	mux.HandleFunc("POST /api/blogs/{id}/comments", blogWrite(handleBlogComments))
+	mux.HandleFunc("DELETE /api/blogs/{id}/comments/{comment_id}", blogWrite(handleBlogComments))
+	mux.HandleFunc("GET /api/users/{id}/blogs", blogs(handleUserBlogs))
+
+	// 页面路由
+	mux.HandleFunc("GET /{$}", authMiddleware(handleIndex))
+	mux.HandleFunc("GET /completed", authMiddleware(handleCompletedPage))
+	mux.HandleFunc("GET /lists/{id}", authMiddleware(handleListPage))
+	mux.HandleFunc("GET /blogs", blogs(handleBlogsPage))
+	mux.HandleFunc("GET /blogs/new", blogs(handleNewBlogPage))
+	mux.HandleFunc("GET /blogs/{id}", blogs(handleBlogPage))
+	mux.HandleFunc("GET /blogs/edit/{id}", blogs(handleEditBlogPage))
+	mux.HandleFunc("GET /settings/2fa", authMiddleware(handleTwoFactorPage))
+	mux.HandleFunc("GET /admin", userManage(handleAdminPage))
+}
+
+// router 先在新的路由中查找，找不到时再查找旧版URL，都不匹配时统一返回404或405
+type router struct {
+	mux    *http.ServeMux
+	legacy *http.ServeMux
+}
+
+// 创建包含所有路由和旧版URL的router
+func newRouter() *router {
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+
+	legacy := http.NewServeMux()
+	for _, route := range legacyRoutes {
+		legacy.HandleFunc(route.pattern, legacyAlias(mux, route.target))
+	}
+
+	return &router{mux: mux, legacy: legacy}
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := rt.mux.Handler(r); pattern != "" {
+		rt.mux.ServeHTTP(w, r)
+		return
+	}
+	if _, pattern := rt.legacy.Handler(r); pattern != "" {
+		rt.legacy.ServeHTTP(w, r)
+		return
+	}
+
+	// 路径存在但方法不对时返回405，旧版URL的405优先于新路由的404
+	status, allow := routeError(rt.mux, r)
+	if status == http.StatusNotFound {
+		if legacyStatus, legacyAllow := routeError(rt.legacy, r); legacyStatus == http.StatusMethodNotAllowed {
+			status, allow = legacyStatus, legacyAllow
+		}
+	}
+
+	message := "页面不存在"
+	if status == http.StatusMethodNotAllowed {
+		w.Header().Set("Allow", allow)
+		message = "不支持的请求方法"
+	}
+	writeProblem(w, r, status, message)
+}
+
+// 返回ServeMux对未匹配请求的处理结果：状态码和Allow头
+func routeError(mux *http.ServeMux, r *http.Request) (int, string) {
+	h, _ := mux.Handler(r)
+	rec := &statusRecorder{header: http.Header{}, status: http.StatusOK}
+	h.ServeHTTP(rec, r)
+	return rec.status, rec.header.Get("Allow")
+}
+
+// statusRecorder 只记录状态码和响应头，丢弃响应体
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (rec *statusRecorder) Header() http.Header         { return rec.header }
+func (rec *statusRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (rec *statusRecorder) WriteHeader(status int)      { rec.status = status }
+
+// 把旧版URL的请求转发到target对应的新路由
+func legacyAlias(mux http.Handler, target string) http.HandlerFunc {
+	method, path, _ := strings.Cut(target, " ")
+	segments := strings.Split(path, "/")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 用旧路径中的参数替换新路径中的{name}
+		resolved := make([]string, len(segments))
+		for i, segment := range segments {
+			if name, ok := strings.CutPrefix(segment, "{"); ok {
+				segment = url.PathEscape(r.PathValue(strings.TrimSuffix(name, "}")))
+			}
+			resolved[i] = segment
+		}
+		newPath := strings.Join(resolved, "/")
+
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+newPath+`>; rel="successor-version"`)
+
+		forward := r.Clone(r.Context())
+		forward.Method = method
+		forward.URL.Path = newPath
+		forward.URL.RawPath = ""
+		mux.ServeHTTP(w, forward)
+	}
+}
+
+// 读取路径中的整数参数
+func pathInt(r *http.Request, name string) (int, error) {
+	return strconv.Atoi(r.PathValue(name))
+}
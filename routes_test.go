@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 未匹配的路径返回404，路径存在但方法不对时返回405和Allow头，/api/下为JSON格式
+func TestRouterNotFoundAndMethodNotAllowed(t *testing.T) {
+	setupTestStores(t)
+	handler := newRouter()
+
+	tests := []struct {
+		method string
+		target string
+		status int
+		code   string
+		allow  string
+	}{
+		{http.MethodGet, "/api/no-such-route", http.StatusNotFound, "not_found", ""},
+		{http.MethodGet, "/no-such-page", http.StatusNotFound, "not_found", ""},
+		{http.MethodPut, "/api/todos", http.StatusMethodNotAllowed, "method_not_allowed", "GET, HEAD, POST"},
+		{http.MethodDelete, "/login", http.StatusMethodNotAllowed, "method_not_allowed", "GET, HEAD, POST"},
+		// 旧版URL的405优先于新路由的404
+		{http.MethodGet, "/api/todos/toggle/1", http.StatusMethodNotAllowed, "method_not_allowed", "POST"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.target, w.Code, tt.status)
+			continue
+		}
+		if allow := w.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.target, allow, tt.allow)
+		}
+		if !strings.HasPrefix(tt.target, "/api/") {
+			continue
+		}
+		var problem Problem
+		if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+			t.Fatalf("%s %s: %v", tt.method, tt.target, err)
+		}
+		if problem.Code != tt.code {
+			t.Errorf("%s %s: code = %q, want %q", tt.method, tt.target, problem.Code, tt.code)
+		}
+	}
+}
+
+// 旧版URL被转发到新路由，并带有Deprecation和Link头
+func TestRouterLegacyAliases(t *testing.T) {
+	setupTestStores(t)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+	actor := Actor{UserID: user.ID, Roles: user.Roles}
+	_, plain, err := userStore.CreateToken(user.ID, "ci", TOKEN_SCOPE_WRITE, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	todo, err := todoStore.Add(actor, TodoInput{Title: "写周报"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newRouter()
+
+	id := strconv.Itoa(todo.ID)
+	tests := []struct {
+		method string
+		target string
+		link   string
+	}{
+		{http.MethodPost, "/api/todos/toggle/" + id, "/api/todos/" + id + "/toggle"},
+		{http.MethodGet, "/api/blogs/user/" + strconv.Itoa(user.ID), "/api/users/" + strconv.Itoa(user.ID) + "/blogs"},
+		{http.MethodDelete, "/api/todos/mark-deleted/" + id, "/api/todos/" + id + "/mark-deleted"},
+		{http.MethodDelete, "/api/todos/delete/" + id, "/api/todos/" + id},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		r.Header.Set("Authorization", "Bearer "+plain)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code >= 300 {
+			t.Errorf("%s %s: status = %d: %s", tt.method, tt.target, w.Code, w.Body.String())
+			continue
+		}
+		if w.Header().Get("Deprecation") != "true" {
+			t.Errorf("%s %s: missing Deprecation header", tt.method, tt.target)
+		}
+		if want := "<" + tt.link + `>; rel="successor-version"`; w.Header().Get("Link") != want {
+			t.Errorf("%s %s: Link = %q, want %q", tt.method, tt.target, w.Header().Get("Link"), want)
+		}
+	}
+
+	// 永久删除经过了新路由
+	for _, remaining := range todoStore.GetVisible(actor, true) {
+		if remaining.ID == todo.ID {
+			t.Errorf("todo %d still exists after DELETE /api/todos/delete/%d", todo.ID, todo.ID)
+		}
+	}
+}
+
+// GET /logout显示确认页面而不是直接登出，确认后POST /logout才删除会话
+func TestLogoutConfirmation(t *testing.T) {
+	setupTestStores(t)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+	session, err := userStore.createSession(user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := csrfMiddleware(newRouter())
+	const csrf = "csrf-token"
+
+	r := httptest.NewRequest(http.MethodGet, "/logout", nil)
+	r.AddCookie(&http.Cookie{Name: "session_token", Value: session.Token})
+	r.AddCookie(&http.Cookie{Name: CSRF_COOKIE, Value: csrf})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /logout: status = %d, want 200", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `value="`+csrf+`"`) {
+		t.Error("confirmation page does not carry the CSRF token")
+	}
+	if sessions := userStore.ListSessions(user.ID); len(sessions) != 1 {
+		t.Fatalf("GET /logout removed the session")
+	}
+
+	form := strings.NewReader(CSRF_FORM_FIELD + "=" + csrf)
+	r = httptest.NewRequest(http.MethodPost, "/logout", form)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: "session_token", Value: session.Token})
+	r.AddCookie(&http.Cookie{Name: CSRF_COOKIE, Value: csrf})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+		t.Fatalf("POST /logout: status = %d, Location = %q", w.Code, w.Header().Get("Location"))
+	}
+	if sessions := userStore.ListSessions(user.ID); len(sessions) != 0 {
+		t.Errorf("%d sessions left after POST /logout", len(sessions))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HTTP服务器的默认超时
+const (
+	DEFAULT_READ_TIMEOUT     = 15 * time.Second // 读取整个请求（包括请求体）的时限
+	DEFAULT_WRITE_TIMEOUT    = 30 * time.Second // 从读完请求头到写完响应的时限
+	DEFAULT_IDLE_TIMEOUT     = 2 * time.Minute  // keep-alive连接的空闲时限
+	DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second // 关闭时等待正在处理的请求完成的时限
+	READ_HEADER_TIMEOUT      = 5 * time.Second  // 读取请求头的时限，防止慢速攻击占用连接
+)
+
+// 按配置的超时创建HTTP服务器
+func newHTTPServer(addr string, handler http.Handler, config Config) *http.Server {
+	readHeaderTimeout := READ_HEADER_TIMEOUT
+	if config.ReadTimeout > 0 && config.ReadTimeout < readHeaderTimeout {
+		readHeaderTimeout = config.ReadTimeout
+	}
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
+}
+
+// 停止接受新连接，并在timeout内等待正在处理的请求完成，超时后强制关闭剩余的连接
+func shutdownServers(servers []*http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("等待请求完成超时，强制关闭%s上的连接: %v", server.Addr, err)
+				server.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// backgroundTasks 管理定期清理等后台协程。关闭服务器时先停止它们，再保存数据和关闭存储，
+// 避免存储关闭后还有协程修改数据
+type backgroundTasks struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newBackgroundTasks() *backgroundTasks {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &backgroundTasks{ctx: ctx, cancel: cancel}
+}
+
+// Go 启动后台任务，task应在ctx取消后尽快返回
+func (b *backgroundTasks) Go(task func(ctx context.Context)) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		task(b.ctx)
+	}()
+}
+
+// Stop 通知所有后台任务退出，并等待它们结束
+func (b *backgroundTasks) Stop() {
+	b.cancel()
+	b.wg.Wait()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// 关闭服务器前停止后台清理任务，Stop返回后不再有协程访问Store
+func TestBackgroundTasksStop(t *testing.T) {
+	setupTestStores(t)
+	guard := newLoginGuard(loginLimits)
+
+	background := newBackgroundTasks()
+	background.Go(func(ctx context.Context) { guard.reap(ctx, time.Millisecond) })
+	background.Go(func(ctx context.Context) { userStore.reapSessions(ctx, time.Millisecond) })
+	time.Sleep(10 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		background.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("background tasks did not stop")
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 会话相关的时间参数
+const (
+	DEFAULT_SESSION_TTL    = 24 * time.Hour   // 会话在最后一次活动后的默认有效期
+	SESSION_TOUCH_INTERVAL = time.Minute      // 顺延有效期的最小间隔，避免每个请求都写入存储
+	SESSION_REAP_INTERVAL  = 10 * time.Minute // 清理过期会话的间隔
+)
+
+// 会话的有效期，可以通过配置修改
+var sessionTTL = DEFAULT_SESSION_TTL
+
+// SessionInfo 是返回给客户端的会话信息，不包含会话令牌
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	Username   string    `json:"username"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Current    bool      `json:"current"` // 是否为发起请求的会话
+}
+
+// 由会话令牌派生对外展示的会话标识
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// 为用户创建新会话，调用时需持有s.mu
+func (s *UserStore) createSession(user User, userAgent, ip string) (Session, error) {
+	// 生成会话令牌
+	token, err := generateToken()
+	if err != nil {
+		return Session{}, err
+	}
+
+	// 创建会话
+	now := time.Now()
+	session := Session{
+		Token:      token,
+		ID:         sessionID(token),
+		UserID:     user.ID,
+		Username:   user.Username,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(sessionTTL),
+		UserAgent:  userAgent,
+		IP:         ip,
+	}
+
+	// 存储会话，保存失败时不创建，避免客户端拿到重启后就失效的会话
+	if err := s.persister.save(func() error { return s.storage.PutSession(session) }); err != nil {
+		return Session{}, err
+	}
+	s.sessions[token] = session
+
+	return session, nil
+}
+
+// TouchSession 验证会话令牌，并在用户有活动时顺延会话有效期。
+// renewed为true表示有效期已被顺延，调用者应更新客户端的Cookie
+func (s *UserStore) TouchSession(token, ip string) (session Session, renewed bool, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[token]
+	now := time.Now()
+	if !exists || now.After(session.ExpiresAt) {
+		return Session{}, false, false
+	}
+
+	if now.Sub(session.LastSeenAt) < SESSION_TOUCH_INTERVAL && session.IP == ip {
+		return session, false, true
+	}
+
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(sessionTTL)
+	session.IP = ip
+	s.sessions[token] = session
+	// 顺延失败只影响重启后的有效期，不应让这次请求失败
+	if err := s.persister.save(func() error { return s.storage.PutSession(session) }); err != nil {
+		log.Printf("顺延会话失败: %v", err)
+	}
+
+	return session, true, true
+}
+
+// ListSessions 返回指定用户所有未过期的会话，最近活动的排在前面
+func (s *UserStore) ListSessions(userID int) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	sessions := make([]Session, 0)
+	for _, session := range s.sessions {
+		if session.UserID == userID && now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions
+}
+
+// RevokeSession 撤销指定标识的会话，普通用户只能撤销自己的会话，管理员可以撤销任何会话
+func (s *UserStore) RevokeSession(id string, userID int, isAdmin bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, session := range s.sessions {
+		if session.ID == id && (isAdmin || session.UserID == userID) {
+			return s.deleteSession(token)
+		}
+	}
+
+	return fmt.Errorf("session %s: %w", id, ErrNotFound)
+}
+
+// RevokeUserSessions 撤销指定用户的所有会话，exceptToken对应的会话会被保留，返回撤销的数量
+func (s *UserStore) RevokeUserSessions(userID int, exceptToken string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	var err error
+	for token, session := range s.sessions {
+		if session.UserID == userID && token != exceptToken {
+			err = errors.Join(err, s.deleteSession(token))
+			count++
+		}
+	}
+
+	return count, err
+}
+
+// PurgeExpiredSessions 删除所有已过期的会话，返回删除的数量
+func (s *UserStore) PurgeExpiredSessions() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	var err error
+	for token, session := range s.sessions {
+		if now.After(session.ExpiresAt) {
+			err = errors.Join(err, s.deleteSession(token))
+			count++
+		}
+	}
+
+	return count, err
+}
+
+// 定期清理过期会话，ctx取消后返回
+func (s *UserStore) reapSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		count, err := s.PurgeExpiredSessions()
+		if err != nil {
+			log.Printf("清理过期会话失败: %v", err)
+		}
+		if count > 0 {
+			log.Printf("已清理%d个过期会话", count)
+		}
+	}
+}
+
+// 获取客户端IP地址
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// 设置会话Cookie
+func setSessionCookie(w http.ResponseWriter, session Session) {
+	http.SetCookie(w, cookieConfig.apply(&http.Cookie{
+		Name:     "session_token",
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+	}))
+}
+
+// 转换为返回给客户端的会话信息
+func toSessionInfos(sessions []Session, currentToken string) []SessionInfo {
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, SessionInfo{
+			ID:         session.ID,
+			UserID:     session.UserID,
+			Username:   session.Username,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			Current:    session.Token == currentToken,
+		})
+	}
+	return infos
+}
+
+// 获取当前请求的会话令牌
+func currentSessionToken(r *http.Request) string {
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// 处理会话列表的请求
+// GET    /api/sessions            列出当前用户的会话，管理员可通过user_id查看其他用户
+// DELETE /api/sessions            撤销当前用户除本会话外的所有会话，管理员可通过user_id撤销其他用户的全部会话
+func handleSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// 获取当前用户
+	actor, err := currentActor(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	userID := actor.UserID
+
+	// 检查用户能否管理其他用户的会话
+	isAdmin := actor.Can(PERM_SESSION_MANAGE)
+
+	// 目标用户，默认为当前用户
+	targetUserID := userID
+	if userIDStr := r.URL.Query().Get("user_id"); userIDStr != "" {
+		targetUserID, err = strconv.Atoi(userIDStr)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+		if targetUserID != userID && !isAdmin {
+			writeProblem(w, r, http.StatusForbidden, "Only admins can manage other users' sessions")
+			return
+		}
+	}
+
+	currentToken := currentSessionToken(r)
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		sessions := userStore.ListSessions(targetUserID)
+		json.NewEncoder(w).Encode(toSessionInfos(sessions, currentToken))
+
+	case http.MethodDelete:
+		count, err := userStore.RevokeUserSessions(targetUserID, currentToken)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int{"revoked": count})
+	}
+}
+
+// 处理单个会话的请求
+// DELETE /api/sessions/{id} 撤销指定会话
+func handleSession(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户
+	actor, err := currentActor(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	userID := actor.UserID
+
+	// 检查用户能否管理其他用户的会话
+	isAdmin := actor.Can(PERM_SESSION_MANAGE)
+
+	id := r.PathValue("id")
+	if id == "" {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+
+	// 撤销会话，管理员可以撤销任何用户的会话
+	if err := userStore.RevokeSession(id, userID, isAdmin); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// 把会话的时间向前推d，模拟这段时间内没有活动
+func ageSession(t *testing.T, token string, d time.Duration) {
+	t.Helper()
+
+	userStore.mu.Lock()
+	defer userStore.mu.Unlock()
+
+	session, ok := userStore.sessions[token]
+	if !ok {
+		t.Fatalf("session %s not found", sessionID(token))
+	}
+	session.CreatedAt = session.CreatedAt.Add(-d)
+	session.LastSeenAt = session.LastSeenAt.Add(-d)
+	session.ExpiresAt = session.ExpiresAt.Add(-d)
+	userStore.sessions[token] = session
+}
+
+// 有活动的会话会顺延有效期，超过有效期没有活动的会话失效并被清理
+func TestSessionSlidingExpiry(t *testing.T) {
+	setupTestStores(t)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+	session, err := userStore.createSession(user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 间隔太短时不顺延，避免每个请求都写入存储
+	if _, renewed, ok := userStore.TouchSession(session.Token, "127.0.0.1"); !ok || renewed {
+		t.Fatalf("immediate touch: renewed = %v, ok = %v; want false, true", renewed, ok)
+	}
+
+	ageSession(t, session.Token, sessionTTL/2)
+	touched, renewed, ok := userStore.TouchSession(session.Token, "127.0.0.1")
+	if !ok || !renewed {
+		t.Fatalf("touch after %v: renewed = %v, ok = %v; want true, true", sessionTTL/2, renewed, ok)
+	}
+	if remaining := time.Until(touched.ExpiresAt); remaining < sessionTTL-time.Minute {
+		t.Errorf("expires in %v after renewal, want about %v", remaining, sessionTTL)
+	}
+
+	// IP变化时立即更新
+	if touched, renewed, _ := userStore.TouchSession(session.Token, "10.0.0.1"); !renewed || touched.IP != "10.0.0.1" {
+		t.Errorf("touch from a new IP: renewed = %v, ip = %s", renewed, touched.IP)
+	}
+
+	ageSession(t, session.Token, sessionTTL+time.Second)
+	if _, _, ok := userStore.TouchSession(session.Token, "127.0.0.1"); ok {
+		t.Fatal("expired session still valid")
+	}
+	if sessions := userStore.ListSessions(user.ID); len(sessions) != 0 {
+		t.Errorf("ListSessions returned %d expired sessions", len(sessions))
+	}
+	if n, err := userStore.PurgeExpiredSessions(); err != nil || n != 1 {
+		t.Errorf("PurgeExpiredSessions() = %d, %v; want 1, nil", n, err)
+	}
+}
+
+// 用户只能撤销自己的会话，管理员可以撤销任何会话；“退出其他设备”保留当前会话
+func TestSessionRevocation(t *testing.T) {
+	setupTestStores(t)
+	alice := registerTestUser(t, "alice", ROLE_MEMBER)
+	bob := registerTestUser(t, "bob", ROLE_MEMBER)
+
+	var tokens []string
+	for i := 0; i < 3; i++ {
+		session, err := userStore.createSession(alice, "test", "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, session.Token)
+	}
+
+	if err := userStore.RevokeSession(sessionID(tokens[0]), bob.ID, false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoke another user's session: err = %v, want ErrNotFound", err)
+	}
+	if err := userStore.RevokeSession(sessionID(tokens[0]), alice.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := userStore.TouchSession(tokens[0], "127.0.0.1"); ok {
+		t.Error("revoked session still valid")
+	}
+	if err := userStore.RevokeSession(sessionID(tokens[1]), bob.ID, true); err != nil {
+		t.Errorf("admin revoke: %v", err)
+	}
+
+	session, err := userStore.createSession(alice, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := userStore.RevokeUserSessions(alice.ID, session.Token); err != nil || n != 1 {
+		t.Errorf("RevokeUserSessions() = %d, %v; want 1, nil", n, err)
+	}
+	sessions := userStore.ListSessions(alice.ID)
+	if len(sessions) != 1 || sessions[0].Token != session.Token {
+		t.Errorf("sessions left = %+v, want only the current one", sessions)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 列表的共享角色
+const (
+	SHARE_VIEWER = "viewer" // 只能查看列表中的待办事项
+	SHARE_EDITOR = "editor" // 可以添加、修改、完成和标记删除待办事项
+)
+
+// ListShare 表示列表共享给的一个用户，保存在TodoList中
+type ListShare struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// 返回用户在列表中的共享角色，未共享时返回空字符串
+func (l TodoList) shareRole(userID int) string {
+	for _, share := range l.Shares {
+		if share.UserID == userID {
+			return share.Role
+		}
+	}
+	return ""
+}
+
+// 返回指派给指定用户的待办事项
+func filterAssignedTo(todos []Todo, userID int) []Todo {
+	filtered := make([]Todo, 0, len(todos))
+	for _, todo := range todos {
+		if todo.AssigneeID == userID {
+			filtered = append(filtered, todo)
+		}
+	}
+	return filtered
+}
+
+// ShareList 把列表共享给用户，已共享时修改其角色，只有列表的所有者可以共享
+func (s *TodoStore) ShareList(id int, actor Actor, userID int, role string) (TodoList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.authorizeList(id, actor, ACCESS_OWNER)
+	if err != nil {
+		return TodoList{}, err
+	}
+	if s.lists[i].UserID == userID {
+		return TodoList{}, ValidationErrors{"username": "不能共享给列表的创建者"}
+	}
+
+	// 按值返回的TodoList与这里共用Shares的底层数组，替换为新的切片而不是原地修改
+	share := ListShare{UserID: userID, Username: getUsernameByID(userID), Role: role}
+	shares := make([]ListShare, 0, len(s.lists[i].Shares)+1)
+	for _, existing := range s.lists[i].Shares {
+		if existing.UserID != userID {
+			shares = append(shares, existing)
+		}
+	}
+	s.lists[i].Shares = append(shares, share)
+
+	// 保存数据
+	list := s.lists[i]
+	if err := s.persister.save(func() error { return s.storage.PutList(list) }); err != nil {
+		return TodoList{}, err
+	}
+
+	return list, nil
+}
+
+// UnshareList 取消列表对用户的共享。所有者可以移除任何用户，被共享的用户可以退出
+func (s *TodoStore) UnshareList(id int, actor Actor, userID int) (TodoList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	need := ACCESS_OWNER
+	if actor.UserID == userID {
+		need = ACCESS_VIEW
+	}
+	i, err := s.authorizeList(id, actor, need)
+	if err != nil {
+		return TodoList{}, err
+	}
+
+	shares := make([]ListShare, 0, len(s.lists[i].Shares))
+	for _, share := range s.lists[i].Shares {
+		if share.UserID != userID {
+			shares = append(shares, share)
+		}
+	}
+	if len(shares) == len(s.lists[i].Shares) {
+		return TodoList{}, fmt.Errorf("list with ID %d is not shared with user %d: %w", id, userID, ErrNotFound)
+	}
+	s.lists[i].Shares = shares
+
+	// 保存数据
+	list := s.lists[i]
+	if err := s.persister.save(func() error { return s.storage.PutList(list) }); err != nil {
+		return TodoList{}, err
+	}
+
+	return list, nil
+}
+
+// 共享列表给用户
+// POST /api/lists/{id}/shares 请求体为{"username": "...", "role": "viewer|editor"}
+func handleShareList(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户
+	actor, err := currentActor(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	id, err := pathInt(r, "id")
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+
+	var input struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, r, ValidationErrors{"body": err.Error()})
+		return
+	}
+
+	errs := ValidationErrors{}
+	userID, ok := getUserIDByUsername(strings.TrimSpace(input.Username))
+	if !ok {
+		errs["username"] = "用户不存在"
+	}
+	if input.Role == "" {
+		input.Role = SHARE_VIEWER
+	}
+	if input.Role != SHARE_VIEWER && input.Role != SHARE_EDITOR {
+		errs["role"] = "角色必须是viewer或editor"
+	}
+	if len(errs) > 0 {
+		writeError(w, r, errs)
+		return
+	}
+
+	list, err := todoStore.ShareList(id, actor, userID, input.Role)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// 取消列表对用户的共享
+// DELETE /api/lists/{id}/shares/{user_id}
+func handleUnshareList(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户
+	actor, err := currentActor(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	id, err := pathInt(r, "id")
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	userID, err := pathInt(r, "user_id")
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if _, err := todoStore.UnshareList(id, actor, userID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
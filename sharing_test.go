@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// 注册测试用户并返回对应的Actor
+func registerTestActor(t *testing.T, username string) Actor {
+	t.Helper()
+
+	user := registerTestUser(t, username, ROLE_MEMBER)
+	return Actor{UserID: user.ID, Roles: user.Roles}
+}
+
+// 共享列表的查看者只能查看，编辑者可以修改但不能永久删除或共享，其他用户看不到
+func TestSharedListAccess(t *testing.T) {
+	setupTestStores(t)
+	owner := registerTestActor(t, "alice")
+	viewer := registerTestActor(t, "bob")
+	editor := registerTestActor(t, "carol")
+	stranger := registerTestActor(t, "dave")
+
+	list, err := todoStore.AddList(owner.UserID, "家务")
+	if err != nil {
+		t.Fatal(err)
+	}
+	todo, err := todoStore.Add(owner, TodoInput{Title: "买菜", ListID: list.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := todoStore.ShareList(list.ID, owner, viewer.UserID, SHARE_VIEWER); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := todoStore.ShareList(list.ID, owner, editor.UserID, SHARE_EDITOR); err != nil {
+		t.Fatal(err)
+	}
+
+	visible := func(actor Actor) bool {
+		for _, v := range todoStore.GetVisible(actor, false) {
+			if v.ID == todo.ID {
+				return true
+			}
+		}
+		return false
+	}
+	for name, actor := range map[string]Actor{"viewer": viewer, "editor": editor} {
+		if !visible(actor) {
+			t.Errorf("%s cannot see the shared todo", name)
+		}
+	}
+	if visible(stranger) {
+		t.Error("stranger can see the shared todo")
+	}
+
+	// 查看者不能修改，也不能向列表添加待办事项
+	if _, err := todoStore.Toggle(todo.ID, viewer); !errors.Is(err, ErrForbidden) {
+		t.Errorf("viewer toggle: err = %v, want ErrForbidden", err)
+	}
+	if _, err := todoStore.Add(viewer, TodoInput{Title: "扫地", ListID: list.ID}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("viewer add: err = %v, want ErrForbidden", err)
+	}
+
+	// 编辑者可以修改和添加，但不能永久删除和共享
+	if _, err := todoStore.Toggle(todo.ID, editor); err != nil {
+		t.Errorf("editor toggle: %v", err)
+	}
+	if _, err := todoStore.Add(editor, TodoInput{Title: "扫地", ListID: list.ID}); err != nil {
+		t.Errorf("editor add: %v", err)
+	}
+	if err := todoStore.Delete(todo.ID, editor); !errors.Is(err, ErrForbidden) {
+		t.Errorf("editor delete: err = %v, want ErrForbidden", err)
+	}
+	if _, err := todoStore.ShareList(list.ID, editor, stranger.UserID, SHARE_VIEWER); !errors.Is(err, ErrForbidden) {
+		t.Errorf("editor share: err = %v, want ErrForbidden", err)
+	}
+
+	// 看不到的待办事项按不存在处理
+	if _, err := todoStore.Toggle(todo.ID, stranger); !errors.Is(err, ErrNotFound) {
+		t.Errorf("stranger toggle: err = %v, want ErrNotFound", err)
+	}
+
+	// 被共享的用户可以退出，之后就看不到了
+	if _, err := todoStore.UnshareList(list.ID, viewer, viewer.UserID); err != nil {
+		t.Fatal(err)
+	}
+	if visible(viewer) {
+		t.Error("viewer can still see the todo after leaving the list")
+	}
+	if _, err := todoStore.UnshareList(list.ID, viewer, editor.UserID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("former viewer removing editor: err = %v, want ErrNotFound", err)
+	}
+}
+
+// 被指派的用户即使没有列表权限也可以修改待办事项
+func TestAssigneeCanEdit(t *testing.T) {
+	setupTestStores(t)
+	owner := registerTestActor(t, "alice")
+	assignee := registerTestActor(t, "bob")
+
+	todo, err := todoStore.Add(owner, TodoInput{Title: "写周报"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := todoStore.Toggle(todo.ID, assignee); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("toggle before assignment: err = %v, want ErrNotFound", err)
+	}
+
+	username := "bob"
+	if _, err := todoStore.Update(todo.ID, owner, TodoPatch{Assignee: &username}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := todoStore.Toggle(todo.ID, assignee); err != nil {
+		t.Errorf("assignee toggle: %v", err)
+	}
+	if err := todoStore.Delete(todo.ID, assignee); !errors.Is(err, ErrForbidden) {
+		t.Errorf("assignee delete: err = %v, want ErrForbidden", err)
+	}
+}
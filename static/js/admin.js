@@ -0,0 +1,201 @@
+document.addEventListener('DOMContentLoaded', () => {
+    // DOM元素
+    const userRows = document.getElementById('user-rows');
+    const errorElement = document.getElementById('admin-error');
+    const logoutBtn = document.getElementById('logout-btn');
+    const backBtn = document.getElementById('back-btn');
+
+    // 所有用户，删除时用于选择接收数据的用户
+    let users = [];
+
+    // 加载用户列表
+    loadUsers();
+
+    // 登出按钮事件监听
+    if (logoutBtn) {
+        logoutBtn.addEventListener('click', logout);
+    }
+
+    // 返回按钮事件监听
+    if (backBtn) {
+        backBtn.addEventListener('click', () => {
+            window.location.href = '/';
+        });
+    }
+
+    // 登出功能
+    async function logout() {
+        try {
+            const response = await fetch('/logout', {
+                method: 'POST'
+            });
+
+            if (response.ok) {
+                window.location.href = '/login';
+            }
+        } catch (error) {
+            console.error('登出失败:', error);
+        }
+    }
+
+    // 显示错误信息
+    async function showError(response) {
+        let message = await response.text();
+        try {
+            const data = JSON.parse(message);
+            message = data.fields ? Object.values(data.fields).join('；') : (data.detail || message);
+        } catch (e) {
+            // 纯文本错误信息
+        }
+        errorElement.textContent = message;
+    }
+
+    // 发送管理请求，成功后重新加载用户列表
+    async function adminRequest(url, options) {
+        errorElement.textContent = '';
+        try {
+            const response = await fetch(url, options);
+            if (!response.ok) {
+                await showError(response);
+                return null;
+            }
+            loadUsers();
+            return response;
+        } catch (error) {
+            console.error('管理请求失败:', error);
+            errorElement.textContent = '请求失败，请稍后再试';
+            return null;
+        }
+    }
+
+    // 加载用户列表
+    async function loadUsers() {
+        try {
+            const response = await fetch('/api/admin/users');
+            if (!response.ok) {
+                await showError(response);
+                return;
+            }
+            users = await response.json();
+
+            userRows.innerHTML = '';
+            users.forEach(user => {
+                userRows.appendChild(renderUser(user));
+            });
+        } catch (error) {
+            console.error('加载用户失败:', error);
+        }
+    }
+
+    // 创建操作按钮
+    function actionButton(text, onClick, danger) {
+        const button = document.createElement('button');
+        button.textContent = text;
+        if (danger) {
+            button.className = 'danger';
+        }
+        button.addEventListener('click', onClick);
+        return button;
+    }
+
+    // 渲染一行用户信息
+    function renderUser(user) {
+        const row = document.createElement('tr');
+        if (user.disabled) {
+            row.className = 'disabled';
+        }
+
+        const cells = [
+            user.id,
+            user.username,
+            null,
+            `${user.todos.total}（${user.todos.completed}）`,
+            user.blogs,
+            user.last_login_at && !user.last_login_at.startsWith('0001') ? new Date(user.last_login_at).toLocaleString() : '从未登录',
+            user.disabled ? '已禁用' : (user.must_change_password ? '待修改密码' : '正常')
+        ];
+        cells.forEach(value => {
+            const cell = document.createElement('td');
+            if (value === null) {
+                (user.roles || []).forEach(role => {
+                    const badge = document.createElement('span');
+                    badge.className = 'role-badge';
+                    badge.textContent = role;
+                    cell.appendChild(badge);
+                });
+            } else {
+                cell.textContent = value;
+            }
+            row.appendChild(cell);
+        });
+
+        const actions = document.createElement('td');
+        actions.className = 'user-actions';
+
+        const isAdmin = (user.roles || []).includes('admin');
+        actions.appendChild(actionButton(isAdmin ? '取消管理员' : '设为管理员', () => {
+            adminRequest(`/api/admin/users/${user.id}/roles/admin`, {
+                method: isAdmin ? 'DELETE' : 'PUT'
+            });
+        }));
+
+        actions.appendChild(actionButton(user.disabled ? '启用' : '禁用', () => {
+            adminRequest(`/api/admin/users/${user.id}`, {
+                method: 'PATCH',
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({ disabled: !user.disabled })
+            });
+        }));
+
+        actions.appendChild(actionButton('重置密码', async () => {
+            if (!confirm(`确定要重置用户 ${user.username} 的密码吗？`)) {
+                return;
+            }
+            const response = await adminRequest(`/api/admin/users/${user.id}/reset-password`, {
+                method: 'POST'
+            });
+            if (response) {
+                const data = await response.json();
+                alert(`临时密码：${data.temporary_password}\n用户登录后必须修改密码`);
+            }
+        }));
+
+        if (user.two_factor) {
+            actions.appendChild(actionButton('重置两步验证', () => {
+                if (!confirm(`确定要关闭用户 ${user.username} 的两步验证吗？`)) {
+                    return;
+                }
+                adminRequest(`/api/admin/users/${user.id}/2fa`, {
+                    method: 'DELETE'
+                });
+            }));
+        }
+
+        actions.appendChild(actionButton('删除', () => deleteUser(user), true));
+
+        row.appendChild(actions);
+        return row;
+    }
+
+    // 删除用户，选择转移或一并删除其数据
+    function deleteUser(user) {
+        const others = users.filter(u => u.id !== user.id).map(u => `${u.id}: ${u.username}`).join('\n');
+        const target = prompt(`删除用户 ${user.username}。\n输入接收其待办事项和博客的用户ID，留空则一并删除这些数据：\n${others}`);
+        if (target === null) {
+            return;
+        }
+
+        let url = `/api/admin/users/${user.id}?mode=purge`;
+        if (target.trim() !== '') {
+            url = `/api/admin/users/${user.id}?mode=reassign&to=${encodeURIComponent(target.trim())}`;
+        } else if (!confirm(`确定要删除用户 ${user.username} 及其所有数据吗？`)) {
+            return;
+        }
+
+        adminRequest(url, {
+            method: 'DELETE'
+        });
+    }
+});
@@ -6,6 +6,27 @@ document.addEventListener('DOMContentLoaded', () => {
     const todoTemplate = document.getElementById('todo-item-template');
     const usernameElement = document.getElementById('username');
     const logoutBtn = document.getElementById('logout-btn');
+    const startDateInput = document.getElementById('new-todo-start');
+    const dueDateInput = document.getElementById('new-todo-due');
+    const repeatSelect = document.getElementById('new-todo-repeat');
+    const todoFilter = document.getElementById('todo-filter');
+    const tagFilter = document.getElementById('tag-filter');
+    const newTodoTagsInput = document.getElementById('new-todo-tags');
+    
+    const listNav = document.getElementById('list-nav');
+    
+    // 当前页面对应的列表，0为默认列表
+    const currentListId = parseInt(document.body.dataset.listId || '0', 10);
+    const currentListArchived = document.body.dataset.listArchived === 'true';
+    // 当前用户在列表中的角色：owner、editor或viewer
+    const currentListRole = document.body.dataset.listRole || 'owner';
+    
+    // 当前显示的待办事项，用于计算拖放后的位置
+    let currentTodos = [];
+    
+    // 当前用户的标签目录，以及筛选中选中的标签
+    let tagCatalogue = [];
+    let selectedTags = [];
     
     // 优先级选择器
     const prioritySelect = document.createElement('select');
@@ -31,6 +52,37 @@ document.addEventListener('DOMContentLoaded', () => {
         logoutBtn.addEventListener('click', logout);
     }
 
+    // 加载列表导航
+    loadLists();
+    
+    // 列表的归档和删除按钮
+    const archiveListBtn = document.getElementById('archive-list-btn');
+    if (archiveListBtn) {
+        archiveListBtn.addEventListener('click', () => {
+            updateList({ archived: !currentListArchived });
+        });
+    }
+    const deleteListBtn = document.getElementById('delete-list-btn');
+    if (deleteListBtn) {
+        deleteListBtn.addEventListener('click', deleteList);
+    }
+    
+    // 共享列表的管理
+    const shareBtn = document.getElementById('share-btn');
+    if (shareBtn) {
+        shareBtn.addEventListener('click', shareList);
+        loadShares();
+    }
+    
+    // 已归档的列表和只读的共享列表不能添加待办事项
+    if (currentListArchived || currentListRole === 'viewer') {
+        document.querySelector('.add-todo').style.display = 'none';
+        document.querySelector('.date-inputs').style.display = 'none';
+    }
+
+    // 筛选条件变化时重新加载
+    todoFilter.addEventListener('change', loadTodos);
+
     // 添加新待办事项的事件监听
     addBtn.addEventListener('click', addTodo);
     newTodoInput.addEventListener('keypress', (e) => {
@@ -60,6 +112,18 @@ document.addEventListener('DOMContentLoaded', () => {
                 if (adminBadge && userData.is_admin) {
                     adminBadge.style.display = 'inline-block';
                 }
+                
+                // 有用户管理权限时显示管理后台入口
+                const adminLink = document.getElementById('admin-link');
+                if (adminLink && (userData.permissions || []).includes('user:manage')) {
+                    adminLink.style.display = 'inline-block';
+                }
+                
+                // 只读用户不能添加待办事项
+                if (!(userData.permissions || []).includes('todo:write')) {
+                    document.querySelector('.add-todo').style.display = 'none';
+                    document.querySelector('.date-inputs').style.display = 'none';
+                }
             } else {
                 // 如果未登录，重定向到登录页面
                 window.location.href = '/login';
@@ -88,8 +152,16 @@ document.addEventListener('DOMContentLoaded', () => {
     // 加载所有待办事项
     async function loadTodos() {
         try {
-            const response = await fetch('/api/todos');
+            const params = new URLSearchParams(todoFilter.value);
+            // 指派给我的待办事项可能来自任何列表
+            if (!params.has('assigned')) {
+                params.set('list_id', currentListId);
+            }
+            selectedTags.forEach(tag => params.append('tag', tag));
+            const query = params.toString() ? `?${params.toString()}` : '';
+            const [response] = await Promise.all([fetch(`/api/todos${query}`), loadTags()]);
             const todos = await response.json();
+            currentTodos = todos;
             
             // 清空列表
             todoList.innerHTML = '';
@@ -101,8 +173,20 @@ document.addEventListener('DOMContentLoaded', () => {
                 2: []   // 高优先级
             };
             
-            // 将待办事项按优先级分组
+            // 子任务按父任务分组，父任务不在当前结果中的子任务作为顶层显示
+            const todoIds = new Set(todos.map(todo => todo.id));
+            const childrenByParent = {};
+            todos.forEach(todo => {
+                if (todo.parent_id && todoIds.has(todo.parent_id)) {
+                    (childrenByParent[todo.parent_id] = childrenByParent[todo.parent_id] || []).push(todo);
+                }
+            });
+            
+            // 将顶层待办事项按优先级分组
             todos.forEach(todo => {
+                if (todo.parent_id && todoIds.has(todo.parent_id)) {
+                    return;
+                }
                 const priority = todo.priority || 1;
                 priorityGroups[priority].push(todo);
             });
@@ -141,8 +225,7 @@ document.addEventListener('DOMContentLoaded', () => {
                     priorityGroups[priority]
                         .sort((a, b) => (a.order || 0) - (b.order || 0))
                         .forEach(todo => {
-                            const todoNode = createTodoNode(todo);
-                            sectionItems.appendChild(todoNode);
+                            appendTodoTree(sectionItems, todo, childrenByParent, 0);
                         });
                     
                     // 添加到主列表
@@ -154,14 +237,29 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
     
+    // 添加待办事项及其所有子任务，子任务按层级缩进显示在父任务之后
+    function appendTodoTree(container, todo, childrenByParent, depth) {
+        container.appendChild(createTodoNode(todo, depth));
+        (childrenByParent[todo.id] || [])
+            .sort((a, b) => (a.order || 0) - (b.order || 0))
+            .forEach(child => appendTodoTree(container, child, childrenByParent, depth + 1));
+    }
+    
     // 创建待办事项节点
-    function createTodoNode(todo) {
+    function createTodoNode(todo, depth = 0) {
         // 克隆模板
         const todoNode = document.importNode(todoTemplate.content, true);
         const todoItem = todoNode.querySelector('.todo-item');
         const checkbox = todoNode.querySelector('.todo-checkbox');
         const todoTitle = todoNode.querySelector('.todo-title');
         const todoUser = todoNode.querySelector('.todo-user');
+        const todoDue = todoNode.querySelector('.todo-due');
+        const overdueBadge = todoNode.querySelector('.overdue-badge');
+        const todoRepeat = todoNode.querySelector('.todo-repeat');
+        const todoProgress = todoNode.querySelector('.todo-progress');
+        const todoAssignee = todoNode.querySelector('.todo-assignee');
+        const subtaskBtn = todoNode.querySelector('.subtask-btn');
+        const editBtn = todoNode.querySelector('.edit-btn:not(.subtask-btn)');
         const deleteBtn = todoNode.querySelector('.delete-btn');
 
         // 设置数据
@@ -170,6 +268,12 @@ document.addEventListener('DOMContentLoaded', () => {
         todoItem.dataset.order = todo.order || 0;
         todoItem.dataset.priority = todo.priority || 1;
         todoTitle.textContent = todo.title;
+        if (todo.notes) {
+            const notes = document.createElement('span');
+            notes.className = 'todo-notes';
+            notes.textContent = todo.notes;
+            todoTitle.appendChild(notes);
+        }
         checkbox.checked = todo.completed;
         
         if (todo.completed) {
@@ -180,8 +284,36 @@ document.addEventListener('DOMContentLoaded', () => {
         const priorityClass = ['priority-low', 'priority-medium', 'priority-high'];
         todoItem.classList.add(priorityClass[todo.priority || 1]);
         
-        // 如果是管理员且待办事项不是当前用户的，显示用户名
-        if (currentUser && currentUser.is_admin && todo.user_id !== currentUser.id) {
+        // 显示日期和逾期标识
+        if (todo.start_date || todo.due_date) {
+            todoDue.textContent = formatTodoDates(todo);
+            todoDue.style.display = 'inline';
+        }
+        // 显示子任务进度
+        if (todo.progress) {
+            todoProgress.textContent = `${todo.progress.done}/${todo.progress.total}`;
+            todoProgress.style.display = 'inline-block';
+        }
+        
+        // 显示被指派的用户
+        if (todo.assignee) {
+            todoAssignee.textContent = `@${todo.assignee}`;
+            todoAssignee.style.display = 'inline-block';
+        }
+        
+        const todoTags = todoNode.querySelector('.todo-tags');
+        (todo.tags || []).forEach(tag => todoTags.appendChild(createTagChip(tag)));
+        if (todo.recurrence) {
+            todoRepeat.textContent = formatRecurrence(todo.recurrence);
+            todoRepeat.style.display = 'inline';
+        }
+        if (isOverdue(todo)) {
+            todoItem.classList.add('overdue');
+            overdueBadge.style.display = 'inline-block';
+        }
+        
+        // 其他用户创建的待办事项（管理员查看或共享列表中）显示用户名
+        if (currentUser && todo.user_id !== currentUser.id) {
             todoUser.textContent = todo.username || `用户 ${todo.user_id}`;
             todoUser.style.display = 'inline-block';
             // 为其他用户的待办事项添加特殊样式
@@ -197,6 +329,27 @@ document.addEventListener('DOMContentLoaded', () => {
             markTodoAsDeleted(todo.id, todoItem);
         });
 
+        subtaskBtn.addEventListener('click', () => {
+            addSubtask(todo);
+        });
+
+        // 点击编辑按钮或双击标题进入编辑状态
+        editBtn.addEventListener('click', () => {
+            startEditing(todo, todoItem);
+        });
+        todoTitle.addEventListener('dblclick', () => {
+            startEditing(todo, todoItem);
+        });
+
+        // 子任务缩进显示，只有顶层待办事项可以拖动排序
+        if (depth > 0) {
+            todoItem.classList.add('subtask');
+            todoItem.style.marginLeft = `${depth * 25}px`;
+        }
+        if (todo.parent_id) {
+            return todoItem;
+        }
+
         // 添加拖放功能
         todoItem.draggable = true;
         
@@ -212,6 +365,245 @@ document.addEventListener('DOMContentLoaded', () => {
         return todoItem;
     }
     
+    // 加载列表导航
+    async function loadLists() {
+        try {
+            const response = await fetch('/api/lists?include_archived=true');
+            if (!response.ok) {
+                return;
+            }
+            const lists = await response.json();
+
+            listNav.innerHTML = '';
+            listNav.appendChild(createListLink('默认列表', '/', currentListId === 0, false));
+            lists.forEach(list => {
+                const name = list.role === 'owner' ? list.name : `${list.name}（共享）`;
+                listNav.appendChild(createListLink(name, `/lists/${list.id}`, list.id === currentListId, list.archived));
+            });
+
+            // 新建列表
+            const input = document.createElement('input');
+            input.type = 'text';
+            input.placeholder = '新建列表...';
+            input.addEventListener('keypress', async (e) => {
+                if (e.key !== 'Enter' || !input.value.trim()) {
+                    return;
+                }
+                const response = await fetch('/api/lists', {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json'
+                    },
+                    body: JSON.stringify({ name: input.value.trim() })
+                });
+                if (response.ok) {
+                    const list = await response.json();
+                    window.location.href = `/lists/${list.id}`;
+                }
+            });
+            listNav.appendChild(input);
+        } catch (error) {
+            console.error('加载列表失败:', error);
+        }
+    }
+    
+    // 创建列表导航链接
+    function createListLink(name, href, active, archived) {
+        const link = document.createElement('a');
+        link.href = href;
+        link.textContent = archived ? `${name}（已归档）` : name;
+        if (active) {
+            link.classList.add('active');
+        }
+        if (archived) {
+            link.classList.add('archived');
+        }
+        return link;
+    }
+    
+    // 修改当前列表
+    async function updateList(patch) {
+        try {
+            const response = await fetch(`/api/lists/${currentListId}`, {
+                method: 'PATCH',
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify(patch)
+            });
+            if (response.ok) {
+                window.location.reload();
+            }
+        } catch (error) {
+            console.error('修改列表失败:', error);
+        }
+    }
+    
+    // 删除当前列表，其中的待办事项会移到默认列表
+    async function deleteList() {
+        if (!confirm('确定删除该列表吗？其中的待办事项会移到默认列表。')) {
+            return;
+        }
+        try {
+            const response = await fetch(`/api/lists/${currentListId}`, {
+                method: 'DELETE'
+            });
+            if (response.ok) {
+                window.location.href = '/';
+            }
+        } catch (error) {
+            console.error('删除列表失败:', error);
+        }
+    }
+    
+    // 显示当前列表共享给的用户
+    async function loadShares() {
+        try {
+            const response = await fetch(`/api/lists/${currentListId}`);
+            if (!response.ok) {
+                return;
+            }
+            const list = await response.json();
+            const shareList = document.getElementById('share-list');
+            shareList.innerHTML = '';
+            (list.shares || []).forEach(share => {
+                const chip = document.createElement('span');
+                chip.className = 'share-chip';
+                chip.textContent = `${share.username}（${share.role === 'editor' ? '可编辑' : '只读'}）`;
+                const removeBtn = document.createElement('button');
+                removeBtn.textContent = '×';
+                removeBtn.title = '取消共享';
+                removeBtn.addEventListener('click', () => unshareList(share.user_id));
+                chip.appendChild(removeBtn);
+                shareList.appendChild(chip);
+            });
+        } catch (error) {
+            console.error('加载共享用户失败:', error);
+        }
+    }
+    
+    // 把当前列表共享给用户
+    async function shareList() {
+        const usernameInput = document.getElementById('share-username');
+        const shareError = document.getElementById('share-error');
+        if (!usernameInput.value.trim()) {
+            return;
+        }
+        try {
+            const response = await fetch(`/api/lists/${currentListId}/shares`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({
+                    username: usernameInput.value.trim(),
+                    role: document.getElementById('share-role').value
+                })
+            });
+            if (!response.ok) {
+                shareError.textContent = await errorMessage(response) || '共享失败';
+                return;
+            }
+            shareError.textContent = '';
+            usernameInput.value = '';
+            loadShares();
+        } catch (error) {
+            console.error('共享列表失败:', error);
+        }
+    }
+    
+    // 取消列表对用户的共享
+    async function unshareList(userId) {
+        try {
+            const response = await fetch(`/api/lists/${currentListId}/shares/${userId}`, {
+                method: 'DELETE'
+            });
+            if (response.ok) {
+                loadShares();
+            }
+        } catch (error) {
+            console.error('取消共享失败:', error);
+        }
+    }
+    
+    // 加载标签目录并显示筛选用的标签
+    async function loadTags() {
+        try {
+            const response = await fetch('/api/tags');
+            if (!response.ok) {
+                return;
+            }
+            tagCatalogue = await response.json();
+
+            // 去掉已被删除的标签
+            selectedTags = selectedTags.filter(name => tagCatalogue.some(tag => tag.name === name));
+
+            tagFilter.innerHTML = '';
+            tagCatalogue.forEach(tag => {
+                const chip = createTagChip(tag.name);
+                chip.textContent = `${tag.name} (${tag.count})`;
+                if (selectedTags.includes(tag.name)) {
+                    chip.classList.add('active');
+                }
+                chip.addEventListener('click', () => {
+                    if (selectedTags.includes(tag.name)) {
+                        selectedTags = selectedTags.filter(name => name !== tag.name);
+                    } else {
+                        selectedTags.push(tag.name);
+                    }
+                    loadTodos();
+                });
+                tagFilter.appendChild(chip);
+            });
+        } catch (error) {
+            console.error('加载标签失败:', error);
+        }
+    }
+    
+    // 创建使用标签颜色的标签元素
+    function createTagChip(name) {
+        const chip = document.createElement('span');
+        chip.className = 'tag-chip';
+        chip.textContent = name;
+        const tag = tagCatalogue.find(t => t.name === name);
+        if (tag) {
+            chip.style.backgroundColor = tag.color;
+        }
+        return chip;
+    }
+    
+    // 本地时区的今天日期，格式为YYYY-MM-DD
+    function todayString() {
+        const now = new Date();
+        const month = String(now.getMonth() + 1).padStart(2, '0');
+        const day = String(now.getDate()).padStart(2, '0');
+        return `${now.getFullYear()}-${month}-${day}`;
+    }
+    
+    // 判断待办事项是否已逾期
+    function isOverdue(todo) {
+        return !!todo.due_date && !todo.completed && todo.due_date < todayString();
+    }
+    
+    // 格式化重复规则
+    function formatRecurrence(recurrence) {
+        const units = { daily: '天', weekly: '周', monthly: '月', yearly: '年' };
+        const interval = recurrence.interval || 1;
+        const every = interval > 1 ? `每${interval}${units[recurrence.freq]}` : `每${units[recurrence.freq]}`;
+        return `重复: ${every}`;
+    }
+    
+    // 格式化开始日期和截止日期
+    function formatTodoDates(todo) {
+        if (todo.start_date && todo.due_date) {
+            return `${todo.start_date} ~ ${todo.due_date}`;
+        }
+        if (todo.due_date) {
+            return `截止 ${todo.due_date}`;
+        }
+        return `开始 ${todo.start_date}`;
+    }
+    
     // 处理拖动经过事件
     function handleDragOver(e) {
         e.preventDefault();
@@ -228,7 +620,7 @@ document.addEventListener('DOMContentLoaded', () => {
     
     // 获取拖动后的位置元素
     function getDragAfterElement(container, y) {
-        const draggableElements = [...container.querySelectorAll('.todo-item:not(.dragging)')];
+        const draggableElements = [...container.querySelectorAll('.todo-item:not(.dragging):not(.subtask)')];
         
         return draggableElements.reduce((closest, child) => {
             const box = child.getBoundingClientRect();
@@ -250,9 +642,20 @@ document.addEventListener('DOMContentLoaded', () => {
         const prioritySection = e.currentTarget;
         const priority = parseInt(prioritySection.dataset.priority);
         
-        // 计算新的顺序
-        const items = Array.from(prioritySection.querySelectorAll('.todo-item'));
-        const newOrder = items.indexOf(todoItem);
+        // 页面按优先级分组显示，需要换算成在整个列表中的位置：
+        // 放在同组下一个待办事项之前，没有下一个时放在同组上一个待办事项之后
+        const items = Array.from(prioritySection.querySelectorAll('.todo-item:not(.subtask)'));
+        const index = items.indexOf(todoItem);
+        const ordered = currentTodos
+            .filter(todo => !todo.parent_id && String(todo.id) !== String(todoId))
+            .sort((a, b) => (a.order || 0) - (b.order || 0))
+            .map(todo => String(todo.id));
+        let newOrder = ordered.length;
+        if (index + 1 < items.length) {
+            newOrder = ordered.indexOf(items[index + 1].dataset.id);
+        } else if (index > 0) {
+            newOrder = ordered.indexOf(items[index - 1].dataset.id) + 1;
+        }
         
         // 更新优先级和顺序
         updateTodoOrder(todoId, newOrder, priority);
@@ -299,15 +702,29 @@ document.addEventListener('DOMContentLoaded', () => {
                 },
                 body: JSON.stringify({ 
                     title: title,
-                    priority: priority 
+                    priority: priority,
+                    start_date: startDateInput.value,
+                    due_date: dueDateInput.value,
+                    recurrence: repeatSelect.value ? { freq: repeatSelect.value } : null,
+                    list_id: currentListId,
+                    tags: newTodoTagsInput.value.split(',').map(t => t.trim()).filter(t => t)
                 })
             });
 
-            const newTodo = await response.json();
-            appendTodoToDOM(newTodo);
+            if (!response.ok) {
+                alert(await errorMessage(response));
+                return;
+            }
+            
+            // 重新加载，使新的待办事项显示在对应的优先级区域中
+            loadTodos();
             
             // 清空输入框
             newTodoInput.value = '';
+            startDateInput.value = '';
+            dueDateInput.value = '';
+            repeatSelect.value = '';
+            newTodoTagsInput.value = '';
         } catch (error) {
             console.error('添加待办事项失败:', error);
         }
@@ -316,11 +733,17 @@ document.addEventListener('DOMContentLoaded', () => {
     // 切换待办事项状态
     async function toggleTodo(id, checkbox) {
         try {
-            const response = await fetch(`/api/todos/toggle/${id}`, {
+            const response = await fetch(`/api/todos/${id}/toggle`, {
                 method: 'POST'
             });
 
             if (response.ok) {
+                const todo = await response.json();
+                // 完成重复待办事项后会生成下一次，父任务和子任务的状态会联动，需要重新加载列表
+                if ((todo.recurrence && todo.completed) || todo.progress || todo.parent_id) {
+                    loadTodos();
+                    return;
+                }
                 const todoItem = checkbox.closest('.todo-item');
                 todoItem.classList.toggle('completed');
             }
@@ -331,77 +754,158 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
 
-    // 标记待办事项为已删除
-async function markTodoAsDeleted(id, todoElement) {
-    try {
-        const response = await fetch(`/api/todos/mark-deleted/${id}`, {
-            method: 'DELETE'
+    // 在待办事项所在位置显示编辑表单
+    function startEditing(todo, todoItem) {
+        if (todoItem.querySelector('.todo-edit-form')) {
+            return;
+        }
+
+        const form = document.createElement('form');
+        form.className = 'todo-edit-form';
+        form.innerHTML = `
+            <input type="text" class="edit-title" name="title" />
+            <select name="priority">
+                <option value="2">高优先级</option>
+                <option value="1">中优先级</option>
+                <option value="0">低优先级</option>
+            </select>
+            <input type="date" name="due_date" />
+            <input type="text" name="tags" placeholder="标签，用逗号分隔" />
+            <input type="text" name="assignee" placeholder="指派给（用户名）" />
+            <textarea name="notes" placeholder="备注"></textarea>
+            <button type="submit" class="edit-btn">保存</button>
+            <button type="button" class="delete-btn cancel-btn">取消</button>
+            <span class="field-error"></span>
+        `;
+        form.elements.title.value = todo.title;
+        form.elements.priority.value = String(todo.priority || 0);
+        form.elements.due_date.value = todo.due_date || '';
+        form.elements.tags.value = (todo.tags || []).join(', ');
+        form.elements.notes.value = todo.notes || '';
+        form.elements.assignee.value = todo.assignee || '';
+
+        // 编辑时隐藏原有内容
+        Array.from(todoItem.children).forEach(child => child.style.display = 'none');
+        todoItem.draggable = false;
+        todoItem.appendChild(form);
+        form.elements.title.focus();
+
+        form.querySelector('.cancel-btn').addEventListener('click', () => {
+            loadTodos();
+        });
+        form.addEventListener('keydown', (e) => {
+            if (e.key === 'Escape') {
+                loadTodos();
+            }
         });
+        form.addEventListener('submit', async (e) => {
+            e.preventDefault();
 
-            if (response.ok) {
-                todoElement.remove();
+            // 只提交发生变化的字段
+            const patch = {};
+            const title = form.elements.title.value.trim();
+            if (title !== todo.title) patch.title = title;
+            const priority = parseInt(form.elements.priority.value, 10);
+            if (priority !== (todo.priority || 0)) patch.priority = priority;
+            const dueDate = form.elements.due_date.value;
+            if (dueDate !== (todo.due_date || '')) patch.due_date = dueDate;
+            const notes = form.elements.notes.value;
+            if (notes !== (todo.notes || '')) patch.notes = notes;
+            const tags = form.elements.tags.value.split(',').map(t => t.trim()).filter(t => t);
+            if (tags.join(',') !== (todo.tags || []).join(',')) patch.tags = tags;
+            const assignee = form.elements.assignee.value.trim();
+            if (assignee !== (todo.assignee || '')) patch.assignee = assignee;
+
+            if (Object.keys(patch).length === 0) {
+                loadTodos();
+                return;
             }
-        } catch (error) {
-            console.error('标记待办事项为已删除失败:', error);
-        }
+
+            const errors = await updateTodo(todo.id, patch);
+            if (errors) {
+                form.querySelector('.field-error').textContent = errors;
+                return;
+            }
+            loadTodos();
+        });
     }
 
-    // 将待办事项添加到DOM
-    function appendTodoToDOM(todo) {
-        // 克隆模板
-        const todoNode = document.importNode(todoTemplate.content, true);
-        const todoItem = todoNode.querySelector('.todo-item');
-        const checkbox = todoNode.querySelector('.todo-checkbox');
-        const todoTitle = todoNode.querySelector('.todo-title');
-        const todoUser = todoNode.querySelector('.todo-user');
-        const deleteBtn = todoNode.querySelector('.delete-btn');
+    // 添加子任务
+    async function addSubtask(parent) {
+        const title = prompt('子任务标题');
+        if (!title || !title.trim()) {
+            return;
+        }
 
-        // 设置数据
-        todoItem.dataset.id = todo.id;
-        todoItem.dataset.userId = todo.user_id;
-        todoItem.dataset.order = todo.order || 0;
-        todoItem.dataset.priority = todo.priority || 1;
-        todoTitle.textContent = todo.title;
-        checkbox.checked = todo.completed;
-        
-        if (todo.completed) {
-            todoItem.classList.add('completed');
+        try {
+            const response = await fetch('/api/todos', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({
+                    title: title.trim(),
+                    priority: parent.priority || 0,
+                    parent_id: parent.id
+                })
+            });
+
+            if (!response.ok) {
+                alert(await errorMessage(response));
+                return;
+            }
+            loadTodos();
+        } catch (error) {
+            console.error('添加子任务失败:', error);
         }
-        
-        // 根据优先级添加样式
-        const priorityClass = ['priority-low', 'priority-medium', 'priority-high'];
-        todoItem.classList.add(priorityClass[todo.priority || 1]);
-        
-        // 如果是管理员且待办事项不是当前用户的，显示用户名
-        if (currentUser && currentUser.is_admin && todo.user_id !== currentUser.id) {
-            todoUser.textContent = todo.username || `用户 ${todo.user_id}`;
-            todoUser.style.display = 'inline-block';
-            // 为其他用户的待办事项添加特殊样式
-            todoItem.style.borderLeft = '3px solid #3498db';
+    }
+    
+    // 从错误响应中取出错误信息，校验错误显示各字段的原因
+    async function errorMessage(response) {
+        let message = await response.text();
+        try {
+            const data = JSON.parse(message);
+            message = data.fields ? Object.values(data.fields).join('；') : (data.detail || message);
+        } catch (e) {
+            // 纯文本错误信息
         }
+        return message;
+    }
 
-        // 添加事件监听
-        checkbox.addEventListener('change', () => {
-            toggleTodo(todo.id, checkbox);
-        });
+    // 部分更新待办事项，失败时返回错误信息
+    async function updateTodo(id, patch) {
+        try {
+            const response = await fetch(`/api/todos/${id}`, {
+                method: 'PATCH',
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify(patch)
+            });
 
-        deleteBtn.addEventListener('click', () => {
-            markTodoAsDeleted(todo.id, todoItem);
-        });
+            if (response.ok) {
+                return null;
+            }
+            return await errorMessage(response);
+        } catch (error) {
+            console.error('更新待办事项失败:', error);
+            return '更新待办事项失败';
+        }
+    }
 
-        // 添加拖放功能
-        todoItem.draggable = true;
-        
-        todoItem.addEventListener('dragstart', (e) => {
-            e.dataTransfer.setData('text/plain', todo.id);
-            todoItem.classList.add('dragging');
-        });
-        
-        todoItem.addEventListener('dragend', () => {
-            todoItem.classList.remove('dragging');
+    // 标记待办事项为已删除
+async function markTodoAsDeleted(id, todoElement) {
+    try {
+        const response = await fetch(`/api/todos/${id}/mark-deleted`, {
+            method: 'POST'
         });
 
-        // 添加到列表
-        todoList.appendChild(todoNode);
+            if (response.ok) {
+                // 子任务会一起被删除，重新加载列表
+                loadTodos();
+            }
+        } catch (error) {
+            console.error('标记待办事项为已删除失败:', error);
+        }
     }
 });
\ No newline at end of file
@@ -2,6 +2,8 @@ document.addEventListener('DOMContentLoaded', () => {
     // 获取表单元素
     const loginForm = document.querySelector('form[action="/login"]');
     const registerForm = document.querySelector('form[action="/register"]');
+    const changePasswordForm = document.querySelector('form[action="/change-password"]');
+    const twoFactorForm = document.querySelector('form[action="/login/2fa"]');
     
     // 登录表单处理
     if (loginForm) {
@@ -26,10 +28,12 @@ document.addEventListener('DOMContentLoaded', () => {
                 });
                 
                 if (response.ok) {
-                    window.location.href = '/';
+                    const data = await response.json();
+                    // 启用了两步验证时还需要输入验证码
+                    window.location.href = data.two_factor_required ? '/login/2fa' : '/';
                 } else {
                     const data = await response.json();
-                    alert(data.error || '登录失败，请检查用户名和密码');
+                    alert(data.detail || '登录失败，请检查用户名和密码');
                 }
             } catch (error) {
                 console.error('登录请求失败:', error);
@@ -51,10 +55,7 @@ document.addEventListener('DOMContentLoaded', () => {
                 return;
             }
             
-            if (password.length < 6) {
-                alert('密码长度至少为6个字符');
-                return;
-            }
+            // 密码策略由服务器校验，不满足时会返回具体原因
             
             try {
                 const response = await fetch('/register', {
@@ -70,7 +71,7 @@ document.addEventListener('DOMContentLoaded', () => {
                     window.location.href = '/login';
                 } else {
                     const data = await response.json();
-                    alert(data.error || '注册失败，该用户名可能已被使用');
+                    alert(data.fields ? Object.values(data.fields).join('；') : (data.detail || '注册失败，该用户名可能已被使用'));
                 }
             } catch (error) {
                 console.error('注册请求失败:', error);
@@ -78,4 +79,67 @@ document.addEventListener('DOMContentLoaded', () => {
             }
         });
     }
-});
\ No newline at end of file
+    
+    // 修改密码表单处理
+    if (changePasswordForm) {
+        changePasswordForm.addEventListener('submit', async (e) => {
+            e.preventDefault();
+            
+            const current_password = document.getElementById('current_password').value;
+            const new_password = document.getElementById('new_password').value;
+            
+            try {
+                const response = await fetch('/change-password', {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json'
+                    },
+                    body: JSON.stringify({ current_password, new_password })
+                });
+                
+                if (response.ok) {
+                    alert('密码修改成功');
+                    window.location.href = '/';
+                } else {
+                    const data = await response.json();
+                    alert(data.fields ? Object.values(data.fields).join('；') : (data.detail || '修改密码失败'));
+                }
+            } catch (error) {
+                console.error('修改密码请求失败:', error);
+                alert('修改密码请求失败，请稍后再试');
+            }
+        });
+    }
+    
+    // 两步验证表单处理
+    if (twoFactorForm) {
+        twoFactorForm.addEventListener('submit', async (e) => {
+            e.preventDefault();
+            
+            const code = document.getElementById('code').value.trim();
+            
+            try {
+                const response = await fetch('/login/2fa', {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json'
+                    },
+                    body: JSON.stringify({ code })
+                });
+                
+                if (response.ok) {
+                    window.location.href = '/';
+                } else {
+                    const data = await response.json();
+                    alert(data.detail || '验证失败');
+                    if (response.status === 401) {
+                        window.location.href = '/login';
+                    }
+                }
+            } catch (error) {
+                console.error('验证请求失败:', error);
+                alert('验证请求失败，请稍后再试');
+            }
+        });
+    }
+});
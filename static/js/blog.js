@@ -64,6 +64,12 @@ document.addEventListener('DOMContentLoaded', () => {
                 if (adminBadge && userData.is_admin) {
                     adminBadge.style.display = 'inline-block';
                 }
+                
+                // 没有发表权限的用户不能评论
+                const commentForm = document.getElementById('comment-form');
+                if (commentForm && !(userData.permissions || []).includes('blog:write')) {
+                    commentForm.style.display = 'none';
+                }
             } else {
                 // 如果未登录，重定向到登录页面
                 window.location.href = '/login';
@@ -146,7 +152,7 @@ document.addEventListener('DOMContentLoaded', () => {
         if (!content) return;
         
         try {
-            const response = await fetch(`/api/blogs/comments/${blogId}`, {
+            const response = await fetch(`/api/blogs/${blogId}/comments`, {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json'
@@ -170,7 +176,7 @@ document.addEventListener('DOMContentLoaded', () => {
             // 获取博客ID
             const blogId = window.location.pathname.split('/').pop();
             // 修正API路径格式
-            const response = await fetch(`/api/blogs/comments/${blogId}/${commentId}`, {
+            const response = await fetch(`/api/blogs/${blogId}/comments/${commentId}`, {
                 method: 'DELETE'
             });
             
@@ -205,7 +211,9 @@ document.addEventListener('DOMContentLoaded', () => {
         commentContent.textContent = comment.content;
         
         // 如果是当前用户的评论或当前用户是博客作者，显示删除按钮
-        if (currentUser && (comment.user_id === currentUser.id || (currentBlog && currentBlog.user_id === currentUser.id))) {
+        // 评论作者、博客作者和版主可以删除评论
+        const canModerate = currentUser && (currentUser.permissions || []).includes('comment:moderate');
+        if (currentUser && (canModerate || comment.user_id === currentUser.id || (currentBlog && currentBlog.user_id === currentUser.id))) {
             deleteCommentBtn.style.display = 'inline-block';
             deleteCommentBtn.addEventListener('click', () => {
                 if (confirm('确定要删除这条评论吗？')) {
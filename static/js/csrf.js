@@ -0,0 +1,24 @@
+// CSRF防护：修改数据的请求自动带上csrf_token Cookie中的令牌
+(() => {
+    const SAFE_METHODS = ['GET', 'HEAD', 'OPTIONS', 'TRACE'];
+
+    // 从Cookie中读取CSRF令牌
+    function csrfToken() {
+        const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+        return match ? decodeURIComponent(match[1]) : '';
+    }
+
+    // 包装fetch，同源的非只读请求自动添加X-CSRF-Token请求头
+    const originalFetch = window.fetch;
+    window.fetch = (input, init = {}) => {
+        const method = (init.method || (input instanceof Request ? input.method : 'GET')).toUpperCase();
+        const url = new URL(input instanceof Request ? input.url : input, window.location.href);
+
+        if (!SAFE_METHODS.includes(method) && url.origin === window.location.origin) {
+            const headers = new Headers(init.headers || (input instanceof Request ? input.headers : undefined));
+            headers.set('X-CSRF-Token', csrfToken());
+            init = { ...init, headers };
+        }
+        return originalFetch(input, init);
+    };
+})();
@@ -0,0 +1,163 @@
+document.addEventListener('DOMContentLoaded', () => {
+    // DOM元素
+    const statusElement = document.getElementById('two-factor-status');
+    const errorElement = document.getElementById('two-factor-error');
+    const enrollSection = document.getElementById('enroll-section');
+    const confirmSection = document.getElementById('confirm-section');
+    const manageSection = document.getElementById('manage-section');
+    const recoverySection = document.getElementById('recovery-section');
+    const logoutBtn = document.getElementById('logout-btn');
+    const backBtn = document.getElementById('back-btn');
+
+    // 加载两步验证状态
+    loadStatus();
+
+    // 登出按钮事件监听
+    if (logoutBtn) {
+        logoutBtn.addEventListener('click', logout);
+    }
+
+    // 返回按钮事件监听
+    if (backBtn) {
+        backBtn.addEventListener('click', () => {
+            window.location.href = '/';
+        });
+    }
+
+    document.getElementById('enroll-btn').addEventListener('click', enroll);
+    document.getElementById('confirm-btn').addEventListener('click', confirmEnrollment);
+    document.getElementById('regenerate-btn').addEventListener('click', regenerateRecoveryCodes);
+    document.getElementById('disable-btn').addEventListener('click', disable);
+
+    // 登出功能
+    async function logout() {
+        try {
+            const response = await fetch('/logout', {
+                method: 'POST'
+            });
+
+            if (response.ok) {
+                window.location.href = '/login';
+            }
+        } catch (error) {
+            console.error('登出失败:', error);
+        }
+    }
+
+    // 从错误响应中取出错误信息
+    async function errorMessage(response) {
+        let message = await response.text();
+        try {
+            message = JSON.parse(message).detail || message;
+        } catch (e) {
+            // 纯文本错误信息
+        }
+        return message;
+    }
+
+    // 发送两步验证请求，失败时显示错误信息并返回null
+    async function twoFactorRequest(action, code) {
+        errorElement.textContent = '';
+        try {
+            const response = await fetch(`/api/2fa/${action}`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({ code })
+            });
+            if (!response.ok) {
+                errorElement.textContent = await errorMessage(response);
+                return null;
+            }
+            return await response.json();
+        } catch (error) {
+            console.error('两步验证请求失败:', error);
+            errorElement.textContent = '请求失败，请稍后再试';
+            return null;
+        }
+    }
+
+    // 加载两步验证状态
+    async function loadStatus() {
+        try {
+            const response = await fetch('/api/2fa');
+            if (!response.ok) {
+                errorElement.textContent = await errorMessage(response);
+                return;
+            }
+            const status = await response.json();
+
+            confirmSection.style.display = 'none';
+            if (status.enabled) {
+                statusElement.textContent = `两步验证已启用，剩余${status.recovery_codes_remaining}个恢复码。`;
+                enrollSection.style.display = 'none';
+                manageSection.style.display = 'block';
+            } else {
+                statusElement.textContent = '两步验证未启用。启用后，登录时除了密码还需要输入验证器应用中的验证码。';
+                enrollSection.style.display = 'block';
+                manageSection.style.display = 'none';
+            }
+        } catch (error) {
+            console.error('加载两步验证状态失败:', error);
+        }
+    }
+
+    // 显示恢复码
+    function showRecoveryCodes(codes) {
+        const list = document.getElementById('recovery-codes');
+        list.innerHTML = '';
+        codes.forEach(code => {
+            const item = document.createElement('div');
+            item.textContent = code;
+            list.appendChild(item);
+        });
+        recoverySection.style.display = 'block';
+    }
+
+    // 开始绑定，显示密钥
+    async function enroll() {
+        const data = await twoFactorRequest('enroll', '');
+        if (data) {
+            document.getElementById('totp-uri').textContent = data.uri;
+            document.getElementById('totp-secret').textContent = data.secret;
+            enrollSection.style.display = 'none';
+            confirmSection.style.display = 'block';
+        }
+    }
+
+    // 确认绑定
+    async function confirmEnrollment() {
+        const code = document.getElementById('confirm-code').value.trim();
+        const data = await twoFactorRequest('confirm', code);
+        if (data) {
+            await loadStatus();
+            showRecoveryCodes(data.recovery_codes);
+        }
+    }
+
+    // 重新生成恢复码
+    async function regenerateRecoveryCodes() {
+        const code = document.getElementById('manage-code').value.trim();
+        const data = await twoFactorRequest('recovery-codes', code);
+        if (data) {
+            document.getElementById('manage-code').value = '';
+            await loadStatus();
+            showRecoveryCodes(data.recovery_codes);
+        }
+    }
+
+    // 关闭两步验证
+    async function disable() {
+        if (!confirm('确定要关闭两步验证吗？')) {
+            return;
+        }
+        const code = document.getElementById('manage-code').value.trim();
+        const data = await twoFactorRequest('disable', code);
+        if (data) {
+            document.getElementById('manage-code').value = '';
+            recoverySection.style.display = 'none';
+            await loadStatus();
+        }
+    }
+});
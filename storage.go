@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+)
+
+// 存储后端类型
+const (
+	STORAGE_JSON   = "json"
+	STORAGE_SQLITE = "sqlite"
+)
+
+// Storage 是持久化后端的抽象，UserStore、TodoStore和BlogStore通过它读写数据。
+// 各个Store在内存中保存完整数据，每次修改后只把发生变化的那一条记录交给后端，
+// 由后端决定如何落盘（记录日志后在Sync时整体重写JSON文件，或逐行更新数据库）。
+type Storage interface {
+	// LoadUsers 返回所有用户以及下一个可用的用户ID
+	LoadUsers() ([]User, int, error)
+	PutUser(user User) error
+	DeleteUser(id int) error
+
+	// LoadSessions 返回所有已保存的会话
+	LoadSessions() ([]Session, error)
+	PutSession(session Session) error
+	DeleteSession(token string) error
+
+	// LoadTodos 返回所有待办事项以及下一个可用的待办事项ID
+	LoadTodos() ([]Todo, int, error)
+	PutTodo(todo Todo) error
+	DeleteTodo(id int) error
+
+	// LoadBlogs 返回所有博客（包含评论）以及下一个可用的博客ID和评论ID
+	LoadBlogs() ([]Blog, int, int, error)
+	PutBlog(blog Blog) error
+	DeleteBlog(id int) error
+	PutComment(comment Comment) error
+	DeleteComment(blogID, id int) error
+
+	// LoadTags 返回所有用户的标签以及下一个可用的标签ID
+	LoadTags() ([]Tag, int, error)
+	PutTag(tag Tag) error
+	DeleteTag(id int) error
+
+	// LoadLists 返回所有用户的待办事项列表以及下一个可用的列表ID
+	LoadLists() ([]TodoList, int, error)
+	PutList(list TodoList) error
+	DeleteList(id int) error
+
+	// LoadTokens 返回所有个人访问令牌以及下一个可用的令牌ID
+	LoadTokens() ([]AccessToken, int, error)
+	PutToken(token AccessToken) error
+	DeleteToken(id int) error
+
+	// Sync 把后端缓冲的修改写入持久化介质
+	Sync() error
+
+	// Close 释放后端持有的资源
+	Close() error
+}
+
+// openStorage 根据类型打开对应的存储后端，JSON文件保存在dataDir中
+func openStorage(kind, dataDir, dsn string) (Storage, error) {
+	switch kind {
+	case STORAGE_JSON:
+		return NewJSONStorage(dataDir)
+	case STORAGE_SQLITE:
+		return NewSQLiteStorage(dsn)
+	default:
+		return nil, fmt.Errorf("未知的存储类型: %s", kind)
+	}
+}
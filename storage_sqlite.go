@@ -0,0 +1,369 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// 默认的SQLite数据库文件名，位于数据目录下
+const SQLITE_FILE = "todolist.db"
+
+// 每张表除了用于查询的键之外，只保存一列JSON编码的完整记录，
+// 这样结构体增加字段时不需要迁移表结构
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id   INTEGER PRIMARY KEY,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sessions (
+	token   TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	data    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS todos (
+	id      INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	data    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS blogs (
+	id      INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	data    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS comments (
+	id      INTEGER PRIMARY KEY,
+	blog_id INTEGER NOT NULL,
+	data    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_comments_blog_id ON comments (blog_id);
+CREATE TABLE IF NOT EXISTS tags (
+	id      INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	data    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS lists (
+	id      INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	data    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS tokens (
+	id      INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	data    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS id_counters (
+	name    TEXT PRIMARY KEY,
+	next_id INTEGER NOT NULL
+);
+`
+
+// 使用自增ID的表。删除ID最大的记录后MAX(id)会变小，
+// 所以由触发器把每张表用过的最大ID记录在id_counters中，保证ID不会被重复使用
+var sqliteIDTables = []string{"users", "todos", "blogs", "comments", "tags", "lists", "tokens"}
+
+// 插入记录后更新id_counters的触发器
+const sqliteCounterTrigger = `
+CREATE TRIGGER IF NOT EXISTS %[1]s_next_id AFTER INSERT ON %[1]s BEGIN
+	INSERT INTO id_counters (name, next_id) VALUES ('%[1]s', NEW.id + 1)
+	ON CONFLICT (name) DO UPDATE SET next_id = MAX(next_id, excluded.next_id);
+END;
+`
+
+// SQLiteStorage 把数据保存在嵌入式SQLite数据库中，每次修改只写入变化的那一行
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+// NewSQLiteStorage 打开（必要时创建）SQLite数据库
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite同一时间只允许一个写入者
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, table := range sqliteIDTables {
+		if _, err := db.Exec(fmt.Sprintf(sqliteCounterTrigger, table)); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &SQLiteStorage{db: db}, nil
+}
+
+// 读取查询结果中每一行的JSON数据
+func queryJSONRows[T any](db *sql.DB, query string) ([]T, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]T, 0)
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var item T
+		if err := json.Unmarshal([]byte(data), &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// 返回表的下一个可用ID。创建id_counters之前写入的数据库没有计数，此时退回到MAX(id)
+func (s *SQLiteStorage) nextID(table string) (int, error) {
+	var nextID int
+	query := "SELECT MAX(COALESCE((SELECT next_id FROM id_counters WHERE name = ?), 1), " +
+		"COALESCE((SELECT MAX(id) FROM " + table + "), 0) + 1)"
+	if err := s.db.QueryRow(query, table).Scan(&nextID); err != nil {
+		return 0, err
+	}
+
+	return nextID, nil
+}
+
+// 写入一行记录
+func (s *SQLiteStorage) put(query string, v interface{}, args ...interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(query, append(args, string(data))...)
+	return err
+}
+
+// LoadUsers 返回所有用户
+func (s *SQLiteStorage) LoadUsers() ([]User, int, error) {
+	users, err := queryJSONRows[User](s.db, "SELECT data FROM users ORDER BY id")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	nextID, err := s.nextID("users")
+	return users, nextID, err
+}
+
+// PutUser 新增或更新用户
+func (s *SQLiteStorage) PutUser(user User) error {
+	return s.put("INSERT OR REPLACE INTO users (id, data) VALUES (?, ?)", user, user.ID)
+}
+
+// DeleteUser 删除用户
+func (s *SQLiteStorage) DeleteUser(id int) error {
+	_, err := s.db.Exec("DELETE FROM users WHERE id = ?", id)
+	return err
+}
+
+// LoadSessions 返回所有会话
+func (s *SQLiteStorage) LoadSessions() ([]Session, error) {
+	return queryJSONRows[Session](s.db, "SELECT data FROM sessions")
+}
+
+// PutSession 新增或更新会话
+func (s *SQLiteStorage) PutSession(session Session) error {
+	return s.put("INSERT OR REPLACE INTO sessions (token, user_id, data) VALUES (?, ?, ?)",
+		session, session.Token, session.UserID)
+}
+
+// DeleteSession 删除会话
+func (s *SQLiteStorage) DeleteSession(token string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE token = ?", token)
+	return err
+}
+
+// LoadTodos 返回所有待办事项
+func (s *SQLiteStorage) LoadTodos() ([]Todo, int, error) {
+	todos, err := queryJSONRows[Todo](s.db, "SELECT data FROM todos ORDER BY id")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	nextID, err := s.nextID("todos")
+	return todos, nextID, err
+}
+
+// PutTodo 新增或更新待办事项
+func (s *SQLiteStorage) PutTodo(todo Todo) error {
+	return s.put("INSERT OR REPLACE INTO todos (id, user_id, data) VALUES (?, ?, ?)",
+		todo, todo.ID, todo.UserID)
+}
+
+// DeleteTodo 删除待办事项
+func (s *SQLiteStorage) DeleteTodo(id int) error {
+	_, err := s.db.Exec("DELETE FROM todos WHERE id = ?", id)
+	return err
+}
+
+// LoadBlogs 返回所有博客，评论单独存放在comments表中
+func (s *SQLiteStorage) LoadBlogs() ([]Blog, int, int, error) {
+	blogs, err := queryJSONRows[Blog](s.db, "SELECT data FROM blogs ORDER BY id")
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	comments, err := queryJSONRows[Comment](s.db, "SELECT data FROM comments ORDER BY id")
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	// 把评论挂到对应的博客上
+	blogIndex := make(map[int]int, len(blogs))
+	for i := range blogs {
+		blogs[i].Comments = make([]Comment, 0)
+		blogIndex[blogs[i].ID] = i
+	}
+	for _, comment := range comments {
+		if i, ok := blogIndex[comment.BlogID]; ok {
+			blogs[i].Comments = append(blogs[i].Comments, comment)
+		}
+	}
+
+	nextID, err := s.nextID("blogs")
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	nextCommentID, err := s.nextID("comments")
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	return blogs, nextID, nextCommentID, nil
+}
+
+// PutBlog 新增或更新博客，不包含评论
+func (s *SQLiteStorage) PutBlog(blog Blog) error {
+	blog.Comments = nil
+	return s.put("INSERT OR REPLACE INTO blogs (id, user_id, data) VALUES (?, ?, ?)",
+		blog, blog.ID, blog.UserID)
+}
+
+// DeleteBlog 删除博客及其评论
+func (s *SQLiteStorage) DeleteBlog(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM comments WHERE blog_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM blogs WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PutComment 新增或更新评论
+func (s *SQLiteStorage) PutComment(comment Comment) error {
+	return s.put("INSERT OR REPLACE INTO comments (id, blog_id, data) VALUES (?, ?, ?)",
+		comment, comment.ID, comment.BlogID)
+}
+
+// DeleteComment 删除评论
+func (s *SQLiteStorage) DeleteComment(blogID, id int) error {
+	_, err := s.db.Exec("DELETE FROM comments WHERE id = ? AND blog_id = ?", id, blogID)
+	return err
+}
+
+// LoadTags 返回所有标签
+func (s *SQLiteStorage) LoadTags() ([]Tag, int, error) {
+	tags, err := queryJSONRows[Tag](s.db, "SELECT data FROM tags ORDER BY id")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	nextID, err := s.nextID("tags")
+	return tags, nextID, err
+}
+
+// PutTag 新增或更新标签
+func (s *SQLiteStorage) PutTag(tag Tag) error {
+	return s.put("INSERT OR REPLACE INTO tags (id, user_id, data) VALUES (?, ?, ?)",
+		tag, tag.ID, tag.UserID)
+}
+
+// DeleteTag 删除标签
+func (s *SQLiteStorage) DeleteTag(id int) error {
+	_, err := s.db.Exec("DELETE FROM tags WHERE id = ?", id)
+	return err
+}
+
+// LoadLists 返回所有待办事项列表
+func (s *SQLiteStorage) LoadLists() ([]TodoList, int, error) {
+	lists, err := queryJSONRows[TodoList](s.db, "SELECT data FROM lists ORDER BY id")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	nextID, err := s.nextID("lists")
+	return lists, nextID, err
+}
+
+// PutList 新增或更新待办事项列表
+func (s *SQLiteStorage) PutList(list TodoList) error {
+	return s.put("INSERT OR REPLACE INTO lists (id, user_id, data) VALUES (?, ?, ?)",
+		list, list.ID, list.UserID)
+}
+
+// DeleteList 删除待办事项列表
+func (s *SQLiteStorage) DeleteList(id int) error {
+	_, err := s.db.Exec("DELETE FROM lists WHERE id = ?", id)
+	return err
+}
+
+// LoadTokens 返回所有个人访问令牌
+func (s *SQLiteStorage) LoadTokens() ([]AccessToken, int, error) {
+	tokens, err := queryJSONRows[AccessToken](s.db, "SELECT data FROM tokens ORDER BY id")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	nextID, err := s.nextID("tokens")
+	return tokens, nextID, err
+}
+
+// PutToken 新增或更新个人访问令牌
+func (s *SQLiteStorage) PutToken(token AccessToken) error {
+	return s.put("INSERT OR REPLACE INTO tokens (id, user_id, data) VALUES (?, ?, ?)",
+		token, token.ID, token.UserID)
+}
+
+// DeleteToken 删除个人访问令牌
+func (s *SQLiteStorage) DeleteToken(id int) error {
+	_, err := s.db.Exec("DELETE FROM tokens WHERE id = ?", id)
+	return err
+}
+
+// Sync 每次修改都已直接写入数据库，无需额外处理
+func (s *SQLiteStorage) Sync() error {
+	return nil
+}
+
+// Close 关闭数据库连接
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
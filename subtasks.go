@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Progress 是子任务的完成进度，由TodoStore在返回待办事项时计算，不会保存
+type Progress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// 按父任务ID索引子任务在s.todos中的下标，调用时需持有s.mu
+func (s *TodoStore) childIndex() map[int][]int {
+	children := make(map[int][]int)
+	for i, todo := range s.todos {
+		if todo.ParentID != 0 {
+			children[todo.ParentID] = append(children[todo.ParentID], i)
+		}
+	}
+	return children
+}
+
+// 返回待办事项所有后代（子任务、子任务的子任务……）在s.todos中的下标，调用时需持有s.mu
+func (s *TodoStore) descendants(id int) []int {
+	return s.collectDescendants(s.childIndex(), id)
+}
+
+// 按childIndex的结果广度优先收集后代，调用时需持有s.mu
+func (s *TodoStore) collectDescendants(children map[int][]int, id int) []int {
+	result := make([]int, 0)
+	queue := []int{id}
+	for len(queue) > 0 {
+		parentID := queue[0]
+		queue = queue[1:]
+		for _, i := range children[parentID] {
+			result = append(result, i)
+			queue = append(queue, s.todos[i].ID)
+		}
+	}
+	return result
+}
+
+// 查找待办事项的下标，调用时需持有s.mu
+func (s *TodoStore) indexOf(id int) int {
+	for i, todo := range s.todos {
+		if todo.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// 检查用户能否在parentID下添加子任务，返回父任务的下标，调用时需持有s.mu
+func (s *TodoStore) checkParent(parentID int, actor Actor) (int, error) {
+	i, err := s.authorizeTodo(parentID, actor, ACCESS_EDIT)
+	if err != nil {
+		return -1, err
+	}
+	if s.todos[i].Deleted {
+		return -1, fmt.Errorf("parent todo with ID %d is deleted: %w", parentID, ErrConflict)
+	}
+	return i, nil
+}
+
+// 判断把id挂到parentID下是否会形成环，调用时需持有s.mu
+func (s *TodoStore) wouldCycle(id, parentID int) bool {
+	if id == parentID {
+		return true
+	}
+	for _, i := range s.descendants(id) {
+		if s.todos[i].ID == parentID {
+			return true
+		}
+	}
+	return false
+}
+
+// 计算每个待办事项的子任务进度，统计所有未删除的后代，调用时需持有s.mu
+func (s *TodoStore) withProgress(todos []Todo) []Todo {
+	children := s.childIndex()
+	for k := range todos {
+		progress := Progress{}
+		for _, i := range s.collectDescendants(children, todos[k].ID) {
+			if s.todos[i].Deleted {
+				continue
+			}
+			progress.Total++
+			if s.todos[i].Completed {
+				progress.Done++
+			}
+		}
+		if progress.Total > 0 {
+			todos[k].Progress = &progress
+		}
+	}
+	return todos
+}
+
+// 完成父任务时同时完成所有子任务，调用时需持有s.mu。
+// 重复的子任务不会生成下一次，否则已完成的父任务下又会出现未完成的子任务
+func (s *TodoStore) completeDescendants(id int) error {
+	var err error
+	for _, i := range s.descendants(id) {
+		if !s.todos[i].Completed {
+			s.todos[i].Completed = true
+			err = errors.Join(err, s.persistTodo(s.todos[i]))
+		}
+	}
+	return err
+}
+
+// 子任务重新打开时，已完成的祖先任务也重新打开，调用时需持有s.mu
+func (s *TodoStore) reopenAncestors(i int) error {
+	var err error
+	for parentID := s.todos[i].ParentID; parentID != 0; {
+		p := s.indexOf(parentID)
+		if p < 0 {
+			break
+		}
+		if s.todos[p].Completed && !s.todos[p].Deleted {
+			s.todos[p].Completed = false
+			err = errors.Join(err, s.persistTodo(s.todos[p]))
+		}
+		parentID = s.todos[p].ParentID
+	}
+	return err
+}
+
+// 把父任务标记为已删除时同时标记所有子任务，调用时需持有s.mu。
+// 与completeDescendants相同，重复的子任务不会生成下一次
+func (s *TodoStore) markDescendantsDeleted(id int) error {
+	var err error
+	for _, i := range s.descendants(id) {
+		if !s.todos[i].Deleted {
+			s.todos[i].Deleted = true
+			s.todos[i].Completed = true
+			err = errors.Join(err, s.persistTodo(s.todos[i]))
+		}
+	}
+	return err
+}
+
+// 移动父任务到其他列表时，子任务跟随移动，调用时需持有s.mu
+func (s *TodoStore) moveDescendants(id, listID int) error {
+	var err error
+	for _, i := range s.descendants(id) {
+		if s.todos[i].ListID != listID {
+			s.todos[i].ListID = listID
+			err = errors.Join(err, s.persistTodo(s.todos[i]))
+		}
+	}
+	return err
+}
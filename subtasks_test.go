@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+// 添加一个父任务和一个每周重复的子任务
+func addRecurringSubtask(t *testing.T, actor Actor) (Todo, Todo) {
+	t.Helper()
+
+	parent, err := todoStore.Add(actor, TodoInput{Title: "父任务"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := todoStore.Add(actor, TodoInput{
+		Title:      "每周例会",
+		DueDate:    "2024-01-01",
+		ParentID:   parent.ID,
+		Recurrence: &Recurrence{Freq: FREQ_WEEKLY, Interval: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parent, child
+}
+
+// 返回父任务下未完成的子任务
+func openChildren(actor Actor, parentID int) []Todo {
+	var open []Todo
+	for _, todo := range todoStore.GetVisible(actor, true) {
+		if todo.ParentID == parentID && !todo.Completed && !todo.Deleted {
+			open = append(open, todo)
+		}
+	}
+	return open
+}
+
+// 删除或完成父任务时，重复的子任务不会在父任务下生成新的未完成实例
+func TestParentCascadeDoesNotSpawnRecurringChild(t *testing.T) {
+	setupTestStores(t)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+	actor := Actor{UserID: user.ID, Roles: user.Roles}
+
+	deleted, _ := addRecurringSubtask(t, actor)
+	if _, err := todoStore.MarkAsDeleted(deleted.ID, actor); err != nil {
+		t.Fatal(err)
+	}
+	if open := openChildren(actor, deleted.ID); len(open) != 0 {
+		t.Errorf("open children after deleting the parent: %+v", open)
+	}
+
+	completed, _ := addRecurringSubtask(t, actor)
+	if _, err := todoStore.Toggle(completed.ID, actor); err != nil {
+		t.Fatal(err)
+	}
+	if open := openChildren(actor, completed.ID); len(open) != 0 {
+		t.Errorf("open children after completing the parent: %+v", open)
+	}
+}
+
+// 直接完成重复的子任务时仍会生成下一次
+func TestToggleRecurringSubtaskSpawnsNext(t *testing.T) {
+	setupTestStores(t)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+	actor := Actor{UserID: user.ID, Roles: user.Roles}
+
+	parent, child := addRecurringSubtask(t, actor)
+	if _, err := todoStore.Toggle(child.ID, actor); err != nil {
+		t.Fatal(err)
+	}
+
+	open := openChildren(actor, parent.ID)
+	if len(open) != 1 || open[0].DueDate != "2024-01-08" || open[0].SeriesID != child.ID {
+		t.Errorf("open children = %+v, want the next weekly occurrence", open)
+	}
+}
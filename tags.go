@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 新标签没有指定颜色时依次使用的默认颜色
+var defaultTagColors = []string{"#3498db", "#2ecc71", "#e67e22", "#9b59b6", "#e74c3c", "#1abc9c", "#f1c40f", "#34495e"}
+
+// 标签颜色的格式，如#1a2b3c
+var tagColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Tag 是用户标签目录中的一个标签，待办事项通过标签名引用它
+type Tag struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+	Color  string `json:"color"`
+}
+
+// TagWithCount 是返回给客户端的标签，附带使用该标签的未删除待办事项数量
+type TagWithCount struct {
+	Tag
+	Count int `json:"count"`
+}
+
+// ErrTagExists 表示同一用户已有同名标签
+var ErrTagExists = domainError(ErrConflict, "tag_exists", "标签已存在")
+
+// 检查标签名，返回去掉首尾空白后的值
+func validateTagName(name string) (string, error) {
+	tags, err := normalizeTags([]string{name})
+	if err != nil {
+		return "", err
+	}
+	if len(tags) == 0 {
+		return "", fmt.Errorf("标签名不能为空")
+	}
+	return tags[0], nil
+}
+
+// 检查标签颜色，返回小写的值，空字符串表示使用默认颜色
+func validateTagColor(color string) (string, error) {
+	if color != "" && !tagColorPattern.MatchString(color) {
+		return "", fmt.Errorf("颜色格式应为#RRGGBB")
+	}
+	return strings.ToLower(color), nil
+}
+
+// 查找用户的同名标签（不区分大小写），调用时需持有s.mu
+func (s *TodoStore) findTag(userID int, name string) int {
+	for i, tag := range s.tags {
+		if tag.UserID == userID && strings.EqualFold(tag.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// 在标签目录中新增一个标签，调用时需持有s.mu
+func (s *TodoStore) addTag(userID int, name, color string) (Tag, error) {
+	if color == "" {
+		color = defaultTagColors[(s.nextTagID-1)%len(defaultTagColors)]
+	}
+
+	tag := Tag{
+		ID:     s.nextTagID,
+		UserID: userID,
+		Name:   name,
+		Color:  color,
+	}
+	s.tags = append(s.tags, tag)
+	s.nextTagID++
+
+	// 保存数据
+	if err := s.persister.save(func() error { return s.storage.PutTag(tag) }); err != nil {
+		return Tag{}, err
+	}
+
+	return tag, nil
+}
+
+// 确保标签都在用户的标签目录中，不存在的自动创建。
+// 返回使用目录中大小写的标签名，调用时需持有s.mu
+func (s *TodoStore) ensureTags(userID int, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	tags := make([]string, 0, len(names))
+	for _, name := range names {
+		if i := s.findTag(userID, name); i >= 0 {
+			tags = append(tags, s.tags[i].Name)
+			continue
+		}
+		tag, err := s.addTag(userID, name, "")
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag.Name)
+	}
+	return tags, nil
+}
+
+// 判断待办事项是否带有指定标签（不区分大小写）
+func (t Todo) HasTag(name string) bool {
+	for _, tag := range t.Tags {
+		if strings.EqualFold(tag, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTags 返回用户的标签目录，按名称排序，并统计每个标签的使用次数
+func (s *TodoStore) GetTags(userID int) []TagWithCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tags := make([]TagWithCount, 0)
+	for _, tag := range s.tags {
+		if tag.UserID != userID {
+			continue
+		}
+
+		count := 0
+		for _, todo := range s.todos {
+			if todo.UserID == userID && !todo.Deleted && todo.HasTag(tag.Name) {
+				count++
+			}
+		}
+		tags = append(tags, TagWithCount{Tag: tag, Count: count})
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		return strings.ToLower(tags[i].Name) < strings.ToLower(tags[j].Name)
+	})
+
+	return tags
+}
+
+// AddTag 在用户的标签目录中创建标签，同名标签已存在时返回ErrTagExists
+func (s *TodoStore) AddTag(userID int, name, color string) (Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findTag(userID, name) >= 0 {
+		return Tag{}, ErrTagExists
+	}
+
+	return s.addTag(userID, name, color)
+}
+
+// UpdateTag 修改标签的名称或颜色，空字符串表示不修改。
+// 重命名时同步修改所有使用该标签的待办事项
+func (s *TodoStore) UpdateTag(id, userID int, name, color string) (Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, tag := range s.tags {
+		if tag.ID != id || tag.UserID != userID {
+			continue
+		}
+
+		var err error
+		if name != "" && name != tag.Name {
+			if j := s.findTag(userID, name); j >= 0 && j != i {
+				return Tag{}, ErrTagExists
+			}
+
+			// 同步修改待办事项上的标签名，使用新的切片避免影响已返回的副本
+			for k, todo := range s.todos {
+				if todo.UserID != userID || !todo.HasTag(tag.Name) {
+					continue
+				}
+				renamed := make([]string, 0, len(todo.Tags))
+				for _, t := range todo.Tags {
+					if strings.EqualFold(t, tag.Name) {
+						t = name
+					}
+					renamed = append(renamed, t)
+				}
+				s.todos[k].Tags = renamed
+				err = errors.Join(err, s.persistTodo(s.todos[k]))
+			}
+
+			s.tags[i].Name = name
+		}
+		if color != "" {
+			s.tags[i].Color = color
+		}
+
+		// 保存数据
+		updated := s.tags[i]
+		err = errors.Join(err, s.persister.save(func() error { return s.storage.PutTag(updated) }))
+		if err != nil {
+			return Tag{}, err
+		}
+
+		return updated, nil
+	}
+
+	return Tag{}, fmt.Errorf("tag with ID %d: %w", id, ErrNotFound)
+}
+
+// DeleteTag 从标签目录中删除标签，并从所有待办事项上移除该标签
+func (s *TodoStore) DeleteTag(id, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, tag := range s.tags {
+		if tag.ID != id || tag.UserID != userID {
+			continue
+		}
+
+		var err error
+		for k, todo := range s.todos {
+			if todo.UserID != userID || !todo.HasTag(tag.Name) {
+				continue
+			}
+			remaining := make([]string, 0, len(todo.Tags))
+			for _, t := range todo.Tags {
+				if !strings.EqualFold(t, tag.Name) {
+					remaining = append(remaining, t)
+				}
+			}
+			s.todos[k].Tags = remaining
+			err = errors.Join(err, s.persistTodo(s.todos[k]))
+		}
+
+		s.tags = append(s.tags[:i], s.tags[i+1:]...)
+
+		// 保存数据
+		return errors.Join(err, s.persister.save(func() error { return s.storage.DeleteTag(id) }))
+	}
+
+	return fmt.Errorf("tag with ID %d: %w", id, ErrNotFound)
+}
+
+// tagFilter 是GET /api/todos支持的标签筛选条件
+type tagFilter struct {
+	tags []string // 筛选的标签，可以重复指定tag参数
+	any  bool     // 为true时只需匹配任意一个标签，默认需要匹配全部标签
+}
+
+// 从查询参数中解析筛选条件：tag（可重复）、tag_mode=any|all
+func parseTagFilter(query url.Values) tagFilter {
+	filter := tagFilter{any: query.Get("tag_mode") == "any"}
+	for _, tag := range query["tag"] {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			filter.tags = append(filter.tags, tag)
+		}
+	}
+	return filter
+}
+
+// 判断待办事项是否满足标签筛选条件
+func (f tagFilter) match(todo Todo) bool {
+	if len(f.tags) == 0 {
+		return true
+	}
+	for _, tag := range f.tags {
+		has := todo.HasTag(tag)
+		if f.any && has {
+			return true
+		}
+		if !f.any && !has {
+			return false
+		}
+	}
+	return !f.any
+}
+
+// 返回满足筛选条件的待办事项
+func (f tagFilter) apply(todos []Todo) []Todo {
+	if len(f.tags) == 0 {
+		return todos
+	}
+
+	filtered := make([]Todo, 0, len(todos))
+	for _, todo := range todos {
+		if f.match(todo) {
+			filtered = append(filtered, todo)
+		}
+	}
+	return filtered
+}
+
+// 统计待办事项中每个标签出现的次数
+func countTags(todos []Todo) map[string]int {
+	counts := make(map[string]int)
+	for _, todo := range todos {
+		for _, tag := range todo.Tags {
+			counts[tag]++
+		}
+	}
+	return counts
+}
+
+// 处理标签目录的请求
+// GET  /api/tags 列出当前用户的标签及使用次数
+// POST /api/tags 创建标签
+func handleTags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// 获取当前用户ID
+	userID, err := getCurrentUserID(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		json.NewEncoder(w).Encode(todoStore.GetTags(userID))
+
+	case http.MethodPost:
+		var input struct {
+			Name  string `json:"name"`
+			Color string `json:"color"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, r, ValidationErrors{"body": err.Error()})
+			return
+		}
+
+		errs := ValidationErrors{}
+		name, err := validateTagName(input.Name)
+		if err != nil {
+			errs["name"] = err.Error()
+		}
+		color, err := validateTagColor(input.Color)
+		if err != nil {
+			errs["color"] = err.Error()
+		}
+		if len(errs) > 0 {
+			writeError(w, r, errs)
+			return
+		}
+
+		tag, err := todoStore.AddTag(userID, name, color)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(tag)
+	}
+}
+
+// 处理单个标签的请求
+// PATCH  /api/tags/{id} 修改标签名称或颜色
+// DELETE /api/tags/{id} 删除标签，并从待办事项上移除
+func handleTag(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户ID
+	userID, err := getCurrentUserID(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	id, err := pathInt(r, "id")
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		var input struct {
+			Name  *string `json:"name"`
+			Color *string `json:"color"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, r, ValidationErrors{"body": err.Error()})
+			return
+		}
+
+		// 未提供的字段保持不变
+		name, color := "", ""
+		errs := ValidationErrors{}
+		if input.Name != nil {
+			if name, err = validateTagName(*input.Name); err != nil {
+				errs["name"] = err.Error()
+			}
+		}
+		if input.Color != nil {
+			if color, err = validateTagColor(*input.Color); err != nil {
+				errs["color"] = err.Error()
+			}
+		}
+		if len(errs) > 0 {
+			writeError(w, r, errs)
+			return
+		}
+
+		tag, err := todoStore.UpdateTag(id, userID, name, color)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tag)
+
+	case http.MethodDelete:
+		if err := todoStore.DeleteTag(id, userID); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
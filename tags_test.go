@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// 返回用户的待办事项的标签，键为待办事项ID
+func todoTags(actor Actor) map[int][]string {
+	tags := make(map[int][]string)
+	for _, todo := range todoStore.GetVisible(actor, true) {
+		tags[todo.ID] = todo.Tags
+	}
+	return tags
+}
+
+// 返回用户标签目录中指定名称的标签
+func findTestTag(t *testing.T, userID int, name string) TagWithCount {
+	t.Helper()
+
+	for _, tag := range todoStore.GetTags(userID) {
+		if tag.Name == name {
+			return tag
+		}
+	}
+	t.Fatalf("tag %q not found", name)
+	return TagWithCount{}
+}
+
+// 重命名和删除标签会同步修改该用户所有使用该标签的待办事项，其他用户的同名标签不受影响
+func TestTagRenameAndDeleteCascade(t *testing.T) {
+	setupTestStores(t)
+	alice := registerTestUser(t, "alice", ROLE_MEMBER)
+	bob := registerTestUser(t, "bob", ROLE_MEMBER)
+	aliceActor := Actor{UserID: alice.ID, Roles: alice.Roles}
+	bobActor := Actor{UserID: bob.ID, Roles: bob.Roles}
+
+	first, err := todoStore.Add(aliceActor, TodoInput{Title: "周报", Tags: []string{"工作", "紧急"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := todoStore.Add(aliceActor, TodoInput{Title: "例会", Tags: []string{"工作"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := todoStore.Add(bobActor, TodoInput{Title: "bob的周报", Tags: []string{"工作"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work := findTestTag(t, alice.ID, "工作")
+	if work.Count != 2 {
+		t.Errorf("count = %d, want 2", work.Count)
+	}
+
+	if _, err := todoStore.UpdateTag(work.ID, alice.ID, "紧急", ""); !errors.Is(err, ErrTagExists) {
+		t.Errorf("rename onto an existing tag: err = %v, want ErrTagExists", err)
+	}
+	if _, err := todoStore.UpdateTag(work.ID, bob.ID, "项目", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("rename another user's tag: err = %v, want ErrNotFound", err)
+	}
+
+	if _, err := todoStore.UpdateTag(work.ID, alice.ID, "项目", ""); err != nil {
+		t.Fatal(err)
+	}
+	tags := todoTags(aliceActor)
+	if !slices.Equal(tags[first.ID], []string{"项目", "紧急"}) || !slices.Equal(tags[second.ID], []string{"项目"}) {
+		t.Errorf("tags after rename = %v", tags)
+	}
+	// 重命名前返回的副本不受影响
+	if !slices.Equal(first.Tags, []string{"工作", "紧急"}) {
+		t.Errorf("returned copy changed to %v", first.Tags)
+	}
+	if tags := todoTags(bobActor); !slices.Equal(tags[other.ID], []string{"工作"}) {
+		t.Errorf("other user's tags changed to %v", tags[other.ID])
+	}
+
+	if err := todoStore.DeleteTag(work.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	tags = todoTags(aliceActor)
+	if !slices.Equal(tags[first.ID], []string{"紧急"}) || len(tags[second.ID]) != 0 {
+		t.Errorf("tags after delete = %v", tags)
+	}
+	for _, tag := range todoStore.GetTags(alice.ID) {
+		if tag.ID == work.ID {
+			t.Error("deleted tag still in the catalogue")
+		}
+	}
+	if tags := todoTags(bobActor); !slices.Equal(tags[other.ID], []string{"工作"}) {
+		t.Errorf("other user's tags changed to %v", tags[other.ID])
+	}
+}
+
+func TestTagFilter(t *testing.T) {
+	todos := []Todo{
+		{ID: 1, Tags: []string{"工作", "紧急"}},
+		{ID: 2, Tags: []string{"工作"}},
+		{ID: 3, Tags: []string{"生活"}},
+		{ID: 4},
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"", []int{1, 2, 3, 4}},
+		{"tag=工作", []int{1, 2}},
+		{"tag=工作&tag=紧急", []int{1}},
+		{"tag=紧急&tag=生活&tag_mode=any", []int{1, 3}},
+		{"tag=%20生活%20", []int{3}},
+	}
+
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		var got []int
+		for _, todo := range parseTagFilter(query).apply(todos) {
+			got = append(got, todo.ID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: matched %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 自签名证书相关的参数
+const (
+	SELF_SIGNED_CERT_FILE = "selfsigned-cert.pem" // 位于数据目录下
+	SELF_SIGNED_KEY_FILE  = "selfsigned-key.pem"
+	SELF_SIGNED_VALIDITY  = 365 * 24 * time.Hour
+	SELF_SIGNED_RENEW     = 7 * 24 * time.Hour // 剩余有效期不足时重新生成
+)
+
+// 默认的HSTS有效期
+const DEFAULT_HSTS_MAX_AGE = 180 * 24 * time.Hour
+
+// certReloader 持有当前使用的证书，收到SIGHUP时重新读取证书文件，不需要重启服务器
+type certReloader struct {
+	certFile      string
+	keyFile       string
+	selfSignedDir string // 使用自签名证书时的数据目录，重新读取前先检查是否需要重新生成
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// 创建certReloader并读取证书
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 创建使用数据目录下自签名证书的certReloader，证书不存在或即将过期时重新生成
+func newSelfSignedCertReloader(dataDir string) (*certReloader, error) {
+	r := &certReloader{
+		certFile:      filepath.Join(dataDir, SELF_SIGNED_CERT_FILE),
+		keyFile:       filepath.Join(dataDir, SELF_SIGNED_KEY_FILE),
+		selfSignedDir: dataDir,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 重新读取证书文件，失败时继续使用原来的证书
+func (r *certReloader) Reload() error {
+	if r.selfSignedDir != "" {
+		if _, _, err := ensureSelfSignedCert(r.selfSignedDir); err != nil {
+			return fmt.Errorf("生成自签名证书失败: %w", err)
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate 用于tls.Config，返回当前的证书
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// 收到SIGHUP时重新读取证书
+func (r *certReloader) watchSIGHUP() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	for range sigChan {
+		if err := r.Reload(); err != nil {
+			log.Printf("重新加载证书失败，继续使用原证书: %v", err)
+			continue
+		}
+		log.Printf("已重新加载证书: %s", r.certFile)
+	}
+}
+
+// 返回数据目录下的自签名证书，不存在或即将过期时重新生成。自签名证书只用于开发
+func ensureSelfSignedCert(dataDir string) (string, string, error) {
+	certFile := filepath.Join(dataDir, SELF_SIGNED_CERT_FILE)
+	keyFile := filepath.Join(dataDir, SELF_SIGNED_KEY_FILE)
+
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && cert.Leaf != nil &&
+		time.Until(cert.Leaf.NotAfter) > SELF_SIGNED_RENEW {
+		return certFile, keyFile, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+
+	// 证书对localhost、本机回环地址和主机名有效
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"todolist development"}, CommonName: "localhost"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(SELF_SIGNED_VALIDITY),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return "", "", err
+	}
+
+	log.Printf("已生成自签名证书: %s", certFile)
+	return certFile, keyFile, nil
+}
+
+// 把HTTP请求重定向到HTTPS监听地址的同一路径
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// 中间件：为HTTPS响应添加Strict-Transport-Security，maxAge为0时不添加
+func hstsMiddleware(maxAge time.Duration, next http.Handler) http.Handler {
+	if maxAge <= 0 {
+		return next
+	}
+	value := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
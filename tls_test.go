@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 在dir中写入一张还有validFor就过期的自签名证书
+func writeExpiringCert(t *testing.T, dir string, validFor time.Duration) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, SELF_SIGNED_CERT_FILE), certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, SELF_SIGNED_KEY_FILE), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 当前证书的过期时间
+func certNotAfter(t *testing.T, r *certReloader) time.Time {
+	t.Helper()
+
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.NotAfter
+}
+
+// 重新加载自签名证书时，即将过期的证书会被重新生成
+func TestSelfSignedReloadRenewsExpiringCert(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := newSelfSignedCertReloader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := certNotAfter(t, r)
+	if time.Until(first) < SELF_SIGNED_VALIDITY-24*time.Hour {
+		t.Fatalf("new certificate expires at %v, want about %v from now", first, SELF_SIGNED_VALIDITY)
+	}
+
+	// 有效期充足时保留原证书
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := certNotAfter(t, r); !got.Equal(first) {
+		t.Errorf("certificate replaced although it was still valid: %v -> %v", first, got)
+	}
+
+	// 模拟长时间运行后证书即将过期，SIGHUP时重新生成
+	writeExpiringCert(t, dir, 24*time.Hour)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := certNotAfter(t, r); time.Until(got) < SELF_SIGNED_RENEW {
+		t.Errorf("expiring certificate was not renewed, expires at %v", got)
+	}
+}
+
+// 使用自签名证书时默认不发送HSTS，显式指定的有效期仍然生效
+func TestSelfSignedHSTSDefault(t *testing.T) {
+	tests := []struct {
+		args []string
+		env  string
+		want time.Duration
+	}{
+		{args: nil, want: DEFAULT_HSTS_MAX_AGE},
+		{args: []string{"-tls-self-signed"}, want: 0},
+		{args: []string{"-tls-self-signed", "-tls-hsts-max-age", "1h"}, want: time.Hour},
+		{args: []string{"-tls-self-signed"}, env: "2h", want: 2 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if tt.env != "" {
+			t.Setenv("TODOLIST_TLS_HSTS_MAX_AGE", tt.env)
+		}
+		config, _, err := loadConfig(tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.TLS.HSTSMaxAge != tt.want {
+			t.Errorf("args %v, env %q: hsts max age = %v, want %v", tt.args, tt.env, config.TLS.HSTSMaxAge, tt.want)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// 待办事项字段的长度限制
+const (
+	TODO_TITLE_MAX_LENGTH = 200
+	TODO_NOTES_MAX_LENGTH = 5000
+	TODO_TAG_MAX_LENGTH   = 32
+	TODO_MAX_TAGS         = 20
+)
+
+// ValidationErrors 记录每个字段的校验错误，键为JSON字段名
+type ValidationErrors map[string]string
+
+func (e ValidationErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, field+": "+e[field])
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// Is 使errors.Is(err, ErrValidation)对校验错误成立
+func (e ValidationErrors) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// 返回字段名加上前缀后的校验错误，用于嵌套对象，如recurrence.interval
+func (e ValidationErrors) prefixed(prefix string) ValidationErrors {
+	errs := make(ValidationErrors, len(e))
+	for field, message := range e {
+		errs[prefix+field] = message
+	}
+	return errs
+}
+
+// TodoPatch 是PATCH /api/todos/{id}的请求体，值为nil的字段保持不变
+type TodoPatch struct {
+	Title     *string   `json:"title"`
+	Priority  *int      `json:"priority"`
+	Notes     *string   `json:"notes"`
+	StartDate *string   `json:"start_date"`
+	DueDate   *string   `json:"due_date"`
+	Tags      *[]string `json:"tags"`
+	ParentID  *int      `json:"parent_id"` // 0表示变为顶层待办事项
+	Assignee  *string   `json:"assignee"`  // 被指派用户的用户名，空字符串表示取消指派
+}
+
+// Validate 检查各字段的取值，并规范化标题和标签
+func (p *TodoPatch) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	if p.Title != nil {
+		title := strings.TrimSpace(*p.Title)
+		if title == "" {
+			errs["title"] = "标题不能为空"
+		} else if utf8.RuneCountInString(title) > TODO_TITLE_MAX_LENGTH {
+			errs["title"] = fmt.Sprintf("标题不能超过%d个字符", TODO_TITLE_MAX_LENGTH)
+		}
+		p.Title = &title
+	}
+
+	if p.Priority != nil && (*p.Priority < 0 || *p.Priority > 2) {
+		errs["priority"] = "优先级必须是0、1或2"
+	}
+
+	if p.Notes != nil && utf8.RuneCountInString(*p.Notes) > TODO_NOTES_MAX_LENGTH {
+		errs["notes"] = fmt.Sprintf("备注不能超过%d个字符", TODO_NOTES_MAX_LENGTH)
+	}
+
+	if p.StartDate != nil {
+		if err := validateDate("开始日期", *p.StartDate); err != nil {
+			errs["start_date"] = err.Error()
+		}
+	}
+	if p.DueDate != nil {
+		if err := validateDate("截止日期", *p.DueDate); err != nil {
+			errs["due_date"] = err.Error()
+		}
+	}
+
+	if p.Assignee != nil {
+		assignee := strings.TrimSpace(*p.Assignee)
+		if assignee != "" {
+			if _, ok := getUserIDByUsername(assignee); !ok {
+				errs["assignee"] = "用户不存在"
+			}
+		}
+		p.Assignee = &assignee
+	}
+
+	if p.Tags != nil {
+		tags, err := normalizeTags(*p.Tags)
+		if err != nil {
+			errs["tags"] = err.Error()
+		}
+		p.Tags = &tags
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Validate 按与TodoPatch相同的规则检查新建待办事项的字段，并规范化标题和标签
+func (in *TodoInput) Validate() ValidationErrors {
+	patch := TodoPatch{
+		Title:     &in.Title,
+		Priority:  &in.Priority,
+		StartDate: &in.StartDate,
+		DueDate:   &in.DueDate,
+		Tags:      &in.Tags,
+	}
+	errs := patch.Validate()
+	if errs == nil {
+		errs = ValidationErrors{}
+	}
+	in.Title, in.Tags = *patch.Title, *patch.Tags
+
+	if errs["start_date"] == "" && errs["due_date"] == "" &&
+		in.StartDate != "" && in.DueDate != "" && in.StartDate > in.DueDate {
+		errs["start_date"] = "开始日期不能晚于截止日期"
+	}
+
+	if in.Recurrence != nil {
+		for field, message := range in.Recurrence.Validate().prefixed("recurrence.") {
+			errs[field] = message
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// 去掉标签首尾空白并去重，保持原有顺序
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > TODO_TAG_MAX_LENGTH {
+			return nil, fmt.Errorf("标签不能超过%d个字符", TODO_TAG_MAX_LENGTH)
+		}
+		key := strings.ToLower(tag)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > TODO_MAX_TAGS {
+		return nil, fmt.Errorf("标签不能超过%d个", TODO_MAX_TAGS)
+	}
+	return normalized, nil
+}
+
+// Update 按TodoPatch部分更新待办事项。合并后的开始日期晚于截止日期时返回ValidationErrors
+func (s *TodoStore) Update(id int, actor Actor, patch TodoPatch) (Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.authorizeTodo(id, actor, ACCESS_EDIT)
+	if err != nil {
+		return Todo{}, err
+	}
+	todo := s.todos[i]
+
+	if patch.Title != nil {
+		todo.Title = *patch.Title
+	}
+	if patch.Priority != nil {
+		todo.Priority = *patch.Priority
+	}
+	if patch.Notes != nil {
+		todo.Notes = *patch.Notes
+	}
+	if patch.StartDate != nil {
+		todo.StartDate = *patch.StartDate
+	}
+	if patch.DueDate != nil {
+		todo.DueDate = *patch.DueDate
+	}
+
+	if todo.StartDate != "" && todo.DueDate != "" && todo.StartDate > todo.DueDate {
+		return Todo{}, ValidationErrors{"start_date": "开始日期不能晚于截止日期"}
+	}
+
+	// 指派给其他用户，空字符串表示取消指派
+	if patch.Assignee != nil {
+		if *patch.Assignee == "" {
+			todo.AssigneeID = 0
+			todo.Assignee = ""
+		} else {
+			assigneeID, ok := getUserIDByUsername(*patch.Assignee)
+			if !ok {
+				return Todo{}, ValidationErrors{"assignee": "用户不存在"}
+			}
+			todo.AssigneeID = assigneeID
+			todo.Assignee = *patch.Assignee
+		}
+	}
+
+	// 修改父任务：不能挂到自己或自己的子任务下，移动后与新的父任务属于同一列表
+	if patch.ParentID != nil && *patch.ParentID != todo.ParentID {
+		parentID := *patch.ParentID
+		if parentID != 0 {
+			p, err := s.checkParent(parentID, actor)
+			if err != nil {
+				return Todo{}, ValidationErrors{"parent_id": "父任务不存在"}
+			}
+			if s.wouldCycle(todo.ID, parentID) {
+				return Todo{}, ValidationErrors{"parent_id": "不能把待办事项移到自己或自己的子任务下"}
+			}
+			todo.ListID = s.todos[p].ListID
+		}
+		todo.ParentID = parentID
+		todo.Order = s.nextOrder(todo.UserID, todo.ListID, parentID)
+	}
+
+	// 校验通过后才把新标签加入标签目录
+	if patch.Tags != nil {
+		tags, err := s.ensureTags(todo.UserID, *patch.Tags)
+		if err != nil {
+			return Todo{}, err
+		}
+		todo.Tags = tags
+	}
+
+	// 移到其他列表时子任务跟随移动
+	if todo.ListID != s.todos[i].ListID {
+		err = s.moveDescendants(todo.ID, todo.ListID)
+	}
+	s.todos[i] = todo
+
+	// 保存数据
+	if err := errors.Join(err, s.persistTodo(todo)); err != nil {
+		return Todo{}, err
+	}
+
+	return todo, nil
+}
+
+// 处理单个待办事项的请求
+// PATCH /api/todos/{id} 部分更新标题、优先级、备注、日期、标签和被指派的用户
+func handleTodo(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户
+	actor, err := currentActor(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	id, err := pathInt(r, "id")
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+
+	// 解析请求体，未知字段视为错误
+	var patch TodoPatch
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		writeError(w, r, ValidationErrors{"body": err.Error()})
+		return
+	}
+
+	if errs := patch.Validate(); errs != nil {
+		writeError(w, r, errs)
+		return
+	}
+
+	// 更新待办事项，管理员可以操作所有待办事项
+	todo, err := todoStore.Update(id, actor, patch)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(todo)
+}
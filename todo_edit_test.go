@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// 新建待办事项与PATCH使用相同的校验规则
+func TestTodoInputValidate(t *testing.T) {
+	tooManyTags := make([]string, TODO_MAX_TAGS+1)
+	for i := range tooManyTags {
+		tooManyTags[i] = strings.Repeat("t", i+1)
+	}
+
+	tests := []struct {
+		name  string
+		input TodoInput
+		field string // 为空表示应通过校验
+	}{
+		{"valid", TodoInput{Title: "买牛奶", Priority: 2}, ""},
+		{"empty title", TodoInput{Title: "   "}, "title"},
+		{"long title", TodoInput{Title: strings.Repeat("长", TODO_TITLE_MAX_LENGTH+1)}, "title"},
+		{"negative priority", TodoInput{Title: "a", Priority: -1}, "priority"},
+		{"priority too high", TodoInput{Title: "a", Priority: 3}, "priority"},
+		{"bad due date", TodoInput{Title: "a", DueDate: "2024-13-01"}, "due_date"},
+		{"start after due", TodoInput{Title: "a", StartDate: "2024-02-01", DueDate: "2024-01-01"}, "start_date"},
+		{"too many tags", TodoInput{Title: "a", Tags: tooManyTags}, "tags"},
+		{"bad recurrence", TodoInput{Title: "a", Recurrence: &Recurrence{Freq: FREQ_DAILY, Interval: RECURRENCE_MAX_INTERVAL + 1}}, "recurrence.interval"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.input.Validate()
+			if tt.field == "" {
+				if errs != nil {
+					t.Errorf("unexpected errors: %v", errs)
+				}
+				return
+			}
+			if _, ok := errs[tt.field]; !ok {
+				t.Errorf("errors = %v, want an error for %s", errs, tt.field)
+			}
+		})
+	}
+}
+
+func TestTodoInputValidateNormalizes(t *testing.T) {
+	input := TodoInput{Title: "  买牛奶  ", Tags: []string{" 家务 ", "家务", ""}}
+	if errs := input.Validate(); errs != nil {
+		t.Fatal(errs)
+	}
+	if input.Title != "买牛奶" {
+		t.Errorf("title = %q, want it trimmed", input.Title)
+	}
+	if len(input.Tags) != 1 || input.Tags[0] != "家务" {
+		t.Errorf("tags = %q, want them normalized", input.Tags)
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 个人访问令牌的权限范围
+const (
+	TOKEN_SCOPE_READ  = "read"  // 只能发送GET和HEAD请求
+	TOKEN_SCOPE_WRITE = "write" // 可以发送所有请求
+)
+
+// 个人访问令牌相关的参数
+const (
+	TOKEN_PREFIX         = "tdl_"      // 令牌前缀，便于在日志和代码中识别泄露的令牌
+	TOKEN_NAME_MAX       = 100         // 令牌名称的最大长度
+	TOKEN_DEFAULT_DAYS   = 30          // 未指定有效天数时的默认值
+	TOKEN_MAX_DAYS       = 365         // 有效天数的上限，新建的令牌总会过期
+	TOKEN_TOUCH_INTERVAL = time.Minute // 更新最后使用时间的最小间隔，避免每个请求都写入存储
+)
+
+// AccessToken 是用户为脚本和命令行工具创建的个人访问令牌，只保存令牌的哈希
+type AccessToken struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	Name       string    `json:"name"`
+	Scope      string    `json:"scope"`
+	Hash       string    `json:"hash"`
+	Hint       string    `json:"hint"` // 令牌的前几个字符，用于区分不同的令牌
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"` // 零值表示永不过期，只有旧版本创建的令牌会是零值
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// TokenInfo 是返回给客户端的令牌信息，不包含令牌的哈希
+type TokenInfo struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	Hint       string     `json:"hint"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Token      string     `json:"token,omitempty"` // 只在创建时返回一次
+}
+
+// ErrInvalidToken 表示令牌不存在、已过期或已被撤销
+var ErrInvalidToken = domainError(ErrUnauthorized, "invalid_token", "无效或已过期的访问令牌")
+
+// 计算令牌的哈希。令牌是高熵的随机值，不需要慢哈希
+func hashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 判断令牌是否已过期
+func (t AccessToken) expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && now.After(t.ExpiresAt)
+}
+
+// 转换为返回给客户端的令牌信息
+func (t AccessToken) info() TokenInfo {
+	info := TokenInfo{
+		ID:        t.ID,
+		Name:      t.Name,
+		Scope:     t.Scope,
+		Hint:      t.Hint,
+		CreatedAt: t.CreatedAt,
+	}
+	if !t.ExpiresAt.IsZero() {
+		expiresAt := t.ExpiresAt
+		info.ExpiresAt = &expiresAt
+	}
+	if !t.LastUsedAt.IsZero() {
+		lastUsedAt := t.LastUsedAt
+		info.LastUsedAt = &lastUsedAt
+	}
+	return info
+}
+
+// 从存储加载令牌，在NewUserStore中调用
+func (s *UserStore) loadTokens() {
+	tokens, nextID, err := s.storage.LoadTokens()
+	if err != nil {
+		log.Fatalf("加载访问令牌失败: %v", err)
+	}
+	s.tokens = tokens
+	s.nextTokenID = nextID
+}
+
+// CreateToken 为用户创建个人访问令牌，返回令牌信息和令牌明文。ttl必须大于0
+func (s *UserStore) CreateToken(userID int, name, scope string, ttl time.Duration) (AccessToken, string, error) {
+	if ttl <= 0 {
+		return AccessToken{}, "", ValidationErrors{"expires_in_days": "有效期必须大于0"}
+	}
+
+	random, err := generateToken()
+	if err != nil {
+		return AccessToken{}, "", err
+	}
+	plain := TOKEN_PREFIX + random
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.userIndex(userID) < 0 {
+		return AccessToken{}, "", fmt.Errorf("user with ID %d: %w", userID, ErrNotFound)
+	}
+
+	now := time.Now()
+	token := AccessToken{
+		ID:        s.nextTokenID,
+		UserID:    userID,
+		Name:      name,
+		Scope:     scope,
+		Hash:      hashAccessToken(plain),
+		Hint:      plain[:len(TOKEN_PREFIX)+6],
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	s.tokens = append(s.tokens, token)
+	s.nextTokenID++
+
+	// 保存数据
+	if err := s.persister.save(func() error { return s.storage.PutToken(token) }); err != nil {
+		return AccessToken{}, "", err
+	}
+
+	return token, plain, nil
+}
+
+// ListTokens 返回用户的所有令牌（包括已过期的），最新创建的排在前面
+func (s *UserStore) ListTokens(userID int) []AccessToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make([]AccessToken, 0)
+	for _, token := range s.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID > tokens[j].ID })
+	return tokens
+}
+
+// RevokeToken 撤销用户的令牌
+func (s *UserStore) RevokeToken(userID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, token := range s.tokens {
+		if token.ID == id && token.UserID == userID {
+			s.tokens = append(s.tokens[:i], s.tokens[i+1:]...)
+			return s.persister.save(func() error { return s.storage.DeleteToken(id) })
+		}
+	}
+	return fmt.Errorf("token with ID %d: %w", id, ErrNotFound)
+}
+
+// 删除用户的所有令牌，调用时需持有s.mu
+func (s *UserStore) deleteUserTokens(userID int) error {
+	var err error
+	tokens := make([]AccessToken, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		if token.UserID != userID {
+			tokens = append(tokens, token)
+			continue
+		}
+		id := token.ID
+		err = errors.Join(err, s.persister.save(func() error { return s.storage.DeleteToken(id) }))
+	}
+	s.tokens = tokens
+	return err
+}
+
+// AuthenticateToken 验证令牌明文，返回令牌信息并更新最后使用时间
+func (s *UserStore) AuthenticateToken(plain string) (AccessToken, error) {
+	if !strings.HasPrefix(plain, TOKEN_PREFIX) {
+		return AccessToken{}, ErrInvalidToken
+	}
+	hash := hashAccessToken(plain)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for i, token := range s.tokens {
+		if token.Hash != hash {
+			continue
+		}
+		if token.expired(now) {
+			return AccessToken{}, ErrInvalidToken
+		}
+
+		if now.Sub(token.LastUsedAt) >= TOKEN_TOUCH_INTERVAL {
+			s.tokens[i].LastUsedAt = now
+			updated := s.tokens[i]
+			// 最后使用时间只用于展示，保存失败不影响这次认证
+			if err := s.persister.save(func() error { return s.storage.PutToken(updated) }); err != nil {
+				log.Printf("更新令牌的最后使用时间失败: %v", err)
+			}
+		}
+		return s.tokens[i], nil
+	}
+	return AccessToken{}, ErrInvalidToken
+}
+
+// 从Authorization请求头中获取Bearer令牌
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// 判断请求是否来自API客户端，API客户端的错误返回JSON，认证失败时也不重定向到登录页面
+func isAPIRequest(r *http.Request) bool {
+	if _, ok := bearerToken(r); ok {
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, "/api/") ||
+		strings.Contains(r.Header.Get("Accept"), "application/json") ||
+		strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+}
+
+// 处理令牌列表的请求
+// GET  /api/tokens 列出当前用户的令牌
+// POST /api/tokens 创建令牌，请求体为{"name": "...", "scope": "read|write", "expires_in_days": 30}，
+// expires_in_days省略时为TOKEN_DEFAULT_DAYS。响应中的token字段是令牌明文，只返回这一次
+func handleTokens(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户
+	identity, ok := identityFrom(r)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	// 令牌只能通过浏览器会话管理，避免泄露的令牌为自己续期
+	if identity.TokenScope != "" {
+		writeProblem(w, r, http.StatusForbidden, "不能使用访问令牌管理访问令牌")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		tokens := userStore.ListTokens(identity.UserID)
+		infos := make([]TokenInfo, 0, len(tokens))
+		for _, token := range tokens {
+			infos = append(infos, token.info())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+
+	case http.MethodPost:
+		var input struct {
+			Name          string `json:"name"`
+			Scope         string `json:"scope"`
+			ExpiresInDays *int   `json:"expires_in_days"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, r, ValidationErrors{"body": err.Error()})
+			return
+		}
+
+		// 校验字段
+		errs := ValidationErrors{}
+		input.Name = strings.TrimSpace(input.Name)
+		if input.Name == "" {
+			errs["name"] = "名称不能为空"
+		} else if utf8.RuneCountInString(input.Name) > TOKEN_NAME_MAX {
+			errs["name"] = fmt.Sprintf("名称不能超过%d个字符", TOKEN_NAME_MAX)
+		}
+		if input.Scope == "" {
+			input.Scope = TOKEN_SCOPE_READ
+		}
+		if input.Scope != TOKEN_SCOPE_READ && input.Scope != TOKEN_SCOPE_WRITE {
+			errs["scope"] = "权限范围必须是read或write"
+		}
+		days := TOKEN_DEFAULT_DAYS
+		if input.ExpiresInDays != nil {
+			days = *input.ExpiresInDays
+		}
+		if days < 1 || days > TOKEN_MAX_DAYS {
+			errs["expires_in_days"] = fmt.Sprintf("有效天数必须在1到%d之间", TOKEN_MAX_DAYS)
+		}
+		if len(errs) > 0 {
+			writeError(w, r, errs)
+			return
+		}
+
+		ttl := time.Duration(days) * 24 * time.Hour
+		token, plain, err := userStore.CreateToken(identity.UserID, input.Name, input.Scope, ttl)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		info := token.info()
+		info.Token = plain
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+	}
+}
+
+// 处理单个令牌的请求
+// DELETE /api/tokens/{id} 撤销令牌
+func handleToken(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户
+	identity, ok := identityFrom(r)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	id, err := pathInt(r, "id")
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	// 令牌可以撤销自己，但不能撤销其他令牌
+	if identity.TokenScope != "" && identity.TokenID != id {
+		writeProblem(w, r, http.StatusForbidden, "不能使用访问令牌管理访问令牌")
+		return
+	}
+
+	if err := userStore.RevokeToken(identity.UserID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 有效天数必须在1到TOKEN_MAX_DAYS之间，省略时使用默认值，新建的令牌总会过期
+func TestCreateTokenExpiresInDaysLimit(t *testing.T) {
+	setupTestStores(t)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+	session, err := userStore.createSession(user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := authMiddleware(handleTokens)
+
+	tests := []struct {
+		days string // 空字符串表示省略该字段
+		want int
+		ttl  int // 创建成功时预期的有效天数
+	}{
+		{"", http.StatusCreated, TOKEN_DEFAULT_DAYS},
+		{"1", http.StatusCreated, 1},
+		{"365", http.StatusCreated, 365},
+		{"0", http.StatusBadRequest, 0},
+		{"366", http.StatusBadRequest, 0},
+		{"-1", http.StatusBadRequest, 0},
+		{"106752", http.StatusBadRequest, 0}, // time.Duration以天计算时的溢出点
+		{"9223372036854775807", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		body := `{"name":"ci"}`
+		if tt.days != "" {
+			body = `{"name":"ci","expires_in_days":` + tt.days + `}`
+		}
+		r := httptest.NewRequest(http.MethodPost, "/api/tokens", strings.NewReader(body))
+		r.AddCookie(&http.Cookie{Name: "session_token", Value: session.Token})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.want {
+			t.Errorf("expires_in_days=%q: status = %d, want %d: %s", tt.days, w.Code, tt.want, w.Body.String())
+			continue
+		}
+
+		if tt.want == http.StatusCreated {
+			var info TokenInfo
+			if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+				t.Fatal(err)
+			}
+			if info.ExpiresAt == nil {
+				t.Errorf("expires_in_days=%q: token never expires", tt.days)
+				continue
+			}
+			days := info.ExpiresAt.Sub(info.CreatedAt).Hours() / 24
+			if int(days+0.5) != tt.ttl {
+				t.Errorf("expires_in_days=%q: token expires after %.1f days, want %d", tt.days, days, tt.ttl)
+			}
+			continue
+		}
+
+		var problem Problem
+		if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+			t.Fatal(err)
+		}
+		if problem.Fields["expires_in_days"] == "" {
+			t.Errorf("expires_in_days=%q: fields = %v, want an expires_in_days error", tt.days, problem.Fields)
+		}
+	}
+
+	if _, _, err := userStore.CreateToken(user.ID, "ci", TOKEN_SCOPE_READ, 0); err == nil {
+		t.Error("CreateToken accepted a zero ttl")
+	}
+}
+
+// 用户自己修改密码后，原有的访问令牌都应失效，只保留当前会话
+func TestChangePasswordRevokesTokens(t *testing.T) {
+	setupTestStores(t)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+	session, err := userStore.createSession(user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, plain, err := userStore.CreateToken(user.ID, "ci", TOKEN_SCOPE_WRITE, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := userStore.ChangePassword(user.ID, "password-alice", "New-password-1!", session.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := userStore.AuthenticateToken(plain); err == nil {
+		t.Error("token still authenticates after changing the password")
+	}
+	if tokens := userStore.ListTokens(user.ID); len(tokens) != 0 {
+		t.Errorf("%d tokens left after changing the password", len(tokens))
+	}
+	if sessions := userStore.ListSessions(user.ID); len(sessions) != 1 {
+		t.Errorf("%d sessions left, want only the current one", len(sessions))
+	}
+}
@@ -0,0 +1,604 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 TOTP参数，与常见的验证器应用（Google Authenticator等）的默认值一致
+const (
+	TOTP_ISSUER      = "todolist"
+	TOTP_SECRET_SIZE = 20 // 密钥字节数，RFC 4226推荐160位
+	TOTP_PERIOD      = 30 // 时间步长（秒）
+	TOTP_DIGITS      = 6
+	TOTP_SKEW        = 1 // 允许前后各偏差的时间步数，容忍客户端时钟误差
+)
+
+// 两步验证相关的参数
+const (
+	RECOVERY_CODE_COUNT      = 10              // 每次生成的恢复码数量
+	LOGIN_CHALLENGE_TTL      = 5 * time.Minute // 输入密码后完成两步验证的时限
+	LOGIN_CHALLENGE_ATTEMPTS = 5               // 每次登录最多尝试的验证码次数
+	LOGIN_CHALLENGE_COOKIE   = "login_challenge"
+)
+
+// 两步验证的错误
+var (
+	ErrTwoFactorEnabled    = domainError(ErrConflict, "two_factor_enabled", "两步验证已启用")
+	ErrTwoFactorNotEnabled = domainError(ErrConflict, "two_factor_not_enabled", "两步验证未启用")
+	ErrInvalidCode         = domainError(ErrValidation, "invalid_code", "验证码错误")
+	ErrChallengeExpired    = domainError(ErrUnauthorized, "challenge_expired", "登录已过期，请重新输入用户名和密码")
+)
+
+// TwoFactorRequired 表示密码正确，但还需要完成两步验证才能登录
+type TwoFactorRequired struct {
+	Challenge string // 第二步登录使用的临时令牌
+}
+
+func (e *TwoFactorRequired) Error() string {
+	return "需要两步验证"
+}
+
+// 等待两步验证的登录
+type loginChallenge struct {
+	UserID    int
+	Username  string
+	UserAgent string
+	IP        string
+	ExpiresAt time.Time
+	Attempts  int
+}
+
+// 当前时间，可以替换为固定时间以便验证TOTP计算
+var totpNow = time.Now
+
+// base32编码，不带填充，验证器应用都能识别
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// 生成新的TOTP密钥
+func generateTOTPSecret() (string, error) {
+	secret := make([]byte, TOTP_SECRET_SIZE)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// 计算指定时间步的验证码（RFC 4226 HOTP，计数器为时间步）
+func totpCode(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// 动态截断
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTP_DIGITS; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%mod)
+}
+
+// 时间对应的时间步
+func totpCounter(t time.Time) int64 {
+	return t.Unix() / TOTP_PERIOD
+}
+
+// 校验验证码，返回匹配的时间步。不接受不大于lastCounter的时间步，防止同一个验证码被重复使用
+func verifyTOTP(secret, code string, now time.Time, lastCounter int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+	code = strings.TrimSpace(code)
+	if len(code) != TOTP_DIGITS {
+		return 0, false
+	}
+
+	current := totpCounter(now)
+	for counter := current - TOTP_SKEW; counter <= current+TOTP_SKEW; counter++ {
+		if counter <= lastCounter {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, counter)), []byte(code)) {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// 生成验证器应用用于绑定的otpauth URI，可以渲染为二维码
+func totpURI(username, secret string) string {
+	label := url.PathEscape(TOTP_ISSUER + ":" + username)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", TOTP_ISSUER)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TOTP_DIGITS))
+	params.Set("period", fmt.Sprint(TOTP_PERIOD))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// 计算恢复码的哈希，忽略大小写和分隔符
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// 生成一组恢复码，返回明文和哈希
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, RECOVERY_CODE_COUNT)
+	hashes := make([]string, 0, RECOVERY_CODE_COUNT)
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b)) // 8个字符
+		code = code[:4] + "-" + code[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// 校验第二步的验证码或恢复码，恢复码使用后作废，调用时需持有s.mu。
+// 作废的记录没能保存时返回错误，否则同一个验证码或恢复码在重启后可以再次使用
+func (s *UserStore) verifySecondFactor(i int, code string) (bool, error) {
+	user := s.users[i]
+	if user.TOTPSecret == "" {
+		return false, nil
+	}
+
+	if counter, ok := verifyTOTP(user.TOTPSecret, code, totpNow(), user.TOTPLastCounter); ok {
+		s.users[i].TOTPLastCounter = counter
+		return true, s.persistUser(s.users[i])
+	}
+
+	hash := hashRecoveryCode(code)
+	for k, stored := range user.RecoveryCodes {
+		if hmac.Equal([]byte(stored), []byte(hash)) {
+			// 从中间删除会原地移动元素，复制后再删除，调用者持有的User中的恢复码保持不变
+			codes := append([]string(nil), user.RecoveryCodes[:k]...)
+			s.users[i].RecoveryCodes = append(codes, user.RecoveryCodes[k+1:]...)
+			return true, s.persistUser(s.users[i])
+		}
+	}
+	return false, nil
+}
+
+// 为通过密码校验的用户创建等待两步验证的登录，调用时需持有s.mu
+func (s *UserStore) createLoginChallenge(user User, userAgent, ip string) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	// 顺便清理过期的登录
+	now := time.Now()
+	for t, challenge := range s.challenges {
+		if now.After(challenge.ExpiresAt) {
+			delete(s.challenges, t)
+		}
+	}
+
+	s.challenges[token] = loginChallenge{
+		UserID:    user.ID,
+		Username:  user.Username,
+		UserAgent: userAgent,
+		IP:        ip,
+		ExpiresAt: now.Add(LOGIN_CHALLENGE_TTL),
+	}
+	return token, nil
+}
+
+// ChallengeUsername 返回等待两步验证的用户名
+func (s *UserStore) ChallengeUsername(token string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.challenges[token]
+	if !ok || time.Now().After(challenge.ExpiresAt) {
+		return "", false
+	}
+	return challenge.Username, true
+}
+
+// CompleteLogin 校验第二步的验证码或恢复码，成功后创建会话
+func (s *UserStore) CompleteLogin(token, code string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.challenges[token]
+	if !ok || time.Now().After(challenge.ExpiresAt) {
+		delete(s.challenges, token)
+		return Session{}, ErrChallengeExpired
+	}
+
+	i := s.userIndex(challenge.UserID)
+	if i < 0 || s.users[i].Disabled {
+		delete(s.challenges, token)
+		return Session{}, ErrChallengeExpired
+	}
+
+	ok, err := s.verifySecondFactor(i, code)
+	if err != nil {
+		return Session{}, err
+	}
+	if !ok {
+		// 错误次数过多时需要重新输入密码
+		challenge.Attempts++
+		if challenge.Attempts >= LOGIN_CHALLENGE_ATTEMPTS {
+			delete(s.challenges, token)
+		} else {
+			s.challenges[token] = challenge
+		}
+		return Session{}, ErrInvalidCode
+	}
+
+	delete(s.challenges, token)
+	s.users[i].LastLoginAt = time.Now()
+	if err := s.persistUser(s.users[i]); err != nil {
+		return Session{}, err
+	}
+
+	return s.createSession(s.users[i], challenge.UserAgent, challenge.IP)
+}
+
+// BeginTOTPEnrollment 为用户生成新的TOTP密钥，用户在验证器应用中添加后需调用ConfirmTOTPEnrollment确认
+func (s *UserStore) BeginTOTPEnrollment(userID int) (secret, uri string, err error) {
+	secret, err = generateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.userIndex(userID)
+	if i < 0 {
+		return "", "", fmt.Errorf("user with ID %d: %w", userID, ErrNotFound)
+	}
+	if s.users[i].TOTPSecret != "" {
+		return "", "", ErrTwoFactorEnabled
+	}
+
+	s.users[i].TOTPPendingSecret = secret
+	if err := s.persistUser(s.users[i]); err != nil {
+		return "", "", err
+	}
+
+	return secret, totpURI(s.users[i].Username, secret), nil
+}
+
+// ConfirmTOTPEnrollment 用验证器应用生成的验证码确认绑定，启用两步验证并返回恢复码
+func (s *UserStore) ConfirmTOTPEnrollment(userID int, code string) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.userIndex(userID)
+	if i < 0 {
+		return nil, fmt.Errorf("user with ID %d: %w", userID, ErrNotFound)
+	}
+	if s.users[i].TOTPSecret != "" {
+		return nil, ErrTwoFactorEnabled
+	}
+	if s.users[i].TOTPPendingSecret == "" {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	counter, ok := verifyTOTP(s.users[i].TOTPPendingSecret, code, totpNow(), 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	s.users[i].TOTPSecret = s.users[i].TOTPPendingSecret
+	s.users[i].TOTPPendingSecret = ""
+	s.users[i].TOTPLastCounter = counter
+	s.users[i].RecoveryCodes = hashes
+	if err := s.persistUser(s.users[i]); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP 校验验证码或恢复码后关闭两步验证
+func (s *UserStore) DisableTOTP(userID int, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.userIndex(userID)
+	if i < 0 {
+		return fmt.Errorf("user with ID %d: %w", userID, ErrNotFound)
+	}
+	if s.users[i].TOTPSecret == "" {
+		return ErrTwoFactorNotEnabled
+	}
+	ok, err := s.verifySecondFactor(i, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	return errors.Join(s.clearTwoFactor(i), s.deleteUserSessions(userID))
+}
+
+// RegenerateRecoveryCodes 校验验证码后生成新的恢复码，旧的恢复码全部作废
+func (s *UserStore) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.userIndex(userID)
+	if i < 0 {
+		return nil, fmt.Errorf("user with ID %d: %w", userID, ErrNotFound)
+	}
+	if s.users[i].TOTPSecret == "" {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	ok, err := s.verifySecondFactor(i, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	s.users[i].RecoveryCodes = hashes
+	if err := s.persistUser(s.users[i]); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// ResetTwoFactor 由管理员关闭用户的两步验证并撤销其所有会话，用于用户丢失验证器和恢复码的情况
+func (s *UserStore) ResetTwoFactor(userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.userIndex(userID)
+	if i < 0 {
+		return fmt.Errorf("user with ID %d: %w", userID, ErrNotFound)
+	}
+
+	return errors.Join(s.clearTwoFactor(i), s.deleteUserSessions(userID))
+}
+
+// 清除用户的两步验证设置，调用时需持有s.mu
+func (s *UserStore) clearTwoFactor(i int) error {
+	s.users[i].TOTPSecret = ""
+	s.users[i].TOTPPendingSecret = ""
+	s.users[i].TOTPLastCounter = 0
+	s.users[i].RecoveryCodes = nil
+	return s.persistUser(s.users[i])
+}
+
+// 处理两步验证设置的请求
+// GET  /api/2fa                 查看是否启用以及剩余的恢复码数量
+// POST /api/2fa/enroll          生成新密钥，返回secret和otpauth URI
+// POST /api/2fa/confirm         {"code": "123456"} 确认绑定并启用，返回恢复码
+// POST /api/2fa/disable         {"code": "123456"} 关闭两步验证，也可以使用恢复码
+// POST /api/2fa/recovery-codes  {"code": "123456"} 重新生成恢复码
+func handleTwoFactor(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户
+	identity, ok := identityFrom(r)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	// 两步验证只能通过浏览器会话设置
+	if identity.TokenScope != "" {
+		writeProblem(w, r, http.StatusForbidden, "不能使用访问令牌设置两步验证")
+		return
+	}
+
+	action := r.PathValue("action")
+
+	if action == "" {
+		user, err := userStore.GetUser(identity.UserID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled":                  user.TOTPSecret != "",
+			"recovery_codes_remaining": len(user.RecoveryCodes),
+		})
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	if action != "enroll" {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, r, ValidationErrors{"body": err.Error()})
+			return
+		}
+		if strings.TrimSpace(input.Code) == "" {
+			writeError(w, r, ValidationErrors{"code": "请输入验证码"})
+			return
+		}
+	}
+
+	var result interface{}
+	var err error
+	switch action {
+	case "enroll":
+		var secret, uri string
+		secret, uri, err = userStore.BeginTOTPEnrollment(identity.UserID)
+		result = map[string]string{"secret": secret, "uri": uri}
+
+	case "confirm":
+		var codes []string
+		codes, err = userStore.ConfirmTOTPEnrollment(identity.UserID, input.Code)
+		result = map[string]interface{}{"enabled": true, "recovery_codes": codes}
+
+	case "disable":
+		err = userStore.DisableTOTP(identity.UserID, input.Code)
+		result = map[string]interface{}{"enabled": false}
+
+	case "recovery-codes":
+		var codes []string
+		codes, err = userStore.RegenerateRecoveryCodes(identity.UserID, input.Code)
+		result = map[string]interface{}{"recovery_codes": codes}
+
+	default:
+		writeProblem(w, r, http.StatusNotFound, "页面不存在")
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// 处理两步验证设置页面
+func handleTwoFactorPage(w http.ResponseWriter, r *http.Request) {
+	// 传递数据到模板
+	data := map[string]interface{}{
+		"Username": currentUsername(r),
+	}
+
+	err := templates.ExecuteTemplate(w, "two_factor.html", data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// 设置或清除第二步登录使用的Cookie
+func setChallengeCookie(w http.ResponseWriter, challenge string) {
+	cookie := cookieConfig.apply(&http.Cookie{
+		Name:     LOGIN_CHALLENGE_COOKIE,
+		Value:    challenge,
+		Path:     "/login",
+		HttpOnly: true,
+	})
+	if challenge == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = time.Now().Add(LOGIN_CHALLENGE_TTL)
+	}
+	http.SetCookie(w, cookie)
+}
+
+// 处理登录的第二步
+// GET  /login/2fa 显示输入验证码的页面
+// POST /login/2fa 校验验证码或恢复码，请求体为{"code": "123456"}或表单，成功后设置会话Cookie
+func handleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	challenge := ""
+	if cookie, err := r.Cookie(LOGIN_CHALLENGE_COOKIE); err == nil {
+		challenge = cookie.Value
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if _, ok := userStore.ChallengeUsername(challenge); !ok {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		err := templates.ExecuteTemplate(w, "login_2fa.html", map[string]interface{}{
+			"CSRFToken": csrfToken(r),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		contentType := r.Header.Get("Content-Type")
+
+		var code string
+		if contentType == "application/json" {
+			var data struct {
+				Code string `json:"code"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+				writeProblem(w, r, http.StatusBadRequest, "无效的JSON数据")
+				return
+			}
+			code = data.Code
+		} else {
+			if err := r.ParseForm(); err != nil {
+				writeProblem(w, r, http.StatusBadRequest, err.Error())
+				return
+			}
+			code = r.Form.Get("code")
+		}
+
+		username, ok := userStore.ChallengeUsername(challenge)
+		if !ok {
+			setChallengeCookie(w, "")
+			writeError(w, r, ErrChallengeExpired)
+			return
+		}
+
+		// 验证码错误和密码错误一样计入账号锁定
+		ip := clientIP(r)
+		if wait, err := loginGuard.CheckLogin(ip, username); err != nil {
+			writeTooManyRequests(w, r, wait, err)
+			return
+		}
+
+		session, err := userStore.CompleteLogin(challenge, code)
+		if err != nil {
+			if errors.Is(err, ErrInvalidCode) {
+				loginGuard.LoginFailed(ip, username)
+			} else {
+				setChallengeCookie(w, "")
+			}
+			writeError(w, r, err)
+			return
+		}
+		loginGuard.LoginSucceeded(username)
+
+		// 设置会话Cookie
+		setChallengeCookie(w, "")
+		setSessionCookie(w, session)
+
+		if contentType == "application/json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "登录成功"})
+		} else {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		}
+	}
+}
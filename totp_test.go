@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录B中SHA1的测试密钥
+const rfc6238Secret = "12345678901234567890"
+
+// 固定totpNow，测试结束后恢复
+func setTOTPNow(t *testing.T, now time.Time) {
+	t.Helper()
+	old := totpNow
+	totpNow = func() time.Time { return now }
+	t.Cleanup(func() { totpNow = old })
+}
+
+// RFC 6238 附录B的测试向量，验证码取8位结果的后6位
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	secret := totpEncoding.EncodeToString([]byte(rfc6238Secret))
+	for _, v := range vectors {
+		now := time.Unix(v.unix, 0)
+		if got := totpCode([]byte(rfc6238Secret), totpCounter(now)); got != v.code {
+			t.Errorf("T=%d: code = %s, want %s", v.unix, got, v.code)
+		}
+		if _, ok := verifyTOTP(secret, v.code, now, 0); !ok {
+			t.Errorf("T=%d: verifyTOTP rejected %s", v.unix, v.code)
+		}
+		// 密钥大小写不敏感，验证码允许首尾空白
+		if _, ok := verifyTOTP(strings.ToLower(secret), " "+v.code+" ", now, 0); !ok {
+			t.Errorf("T=%d: verifyTOTP rejected a lowercase secret", v.unix)
+		}
+	}
+}
+
+// 只接受前后各TOTP_SKEW个时间步内的验证码，且同一时间步不能重复使用
+func TestTOTPWindow(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte(rfc6238Secret))
+	now := time.Unix(1234567890, 0)
+	current := totpCounter(now)
+
+	for offset := int64(-3); offset <= 3; offset++ {
+		code := totpCode([]byte(rfc6238Secret), current+offset)
+		counter, ok := verifyTOTP(secret, code, now, 0)
+		want := offset >= -TOTP_SKEW && offset <= TOTP_SKEW
+		if ok != want {
+			t.Errorf("offset %d: accepted = %v, want %v", offset, ok, want)
+		}
+		if ok && counter != current+offset {
+			t.Errorf("offset %d: counter = %d, want %d", offset, counter, current+offset)
+		}
+	}
+
+	// 已使用过的时间步及更早的时间步不再接受
+	code := totpCode([]byte(rfc6238Secret), current)
+	if _, ok := verifyTOTP(secret, code, now, current); ok {
+		t.Error("replayed code accepted")
+	}
+	later := totpCode([]byte(rfc6238Secret), current+1)
+	if _, ok := verifyTOTP(secret, later, now, current); !ok {
+		t.Error("code for a later step rejected")
+	}
+}
+
+// 启用两步验证，返回恢复码
+func enrollTOTP(t *testing.T, userID int, now time.Time) (string, []string) {
+	t.Helper()
+
+	secret, _, err := userStore.BeginTOTPEnrollment(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := userStore.ConfirmTOTPEnrollment(userID, totpCode(key, totpCounter(now)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret, codes
+}
+
+// 创建等待两步验证的登录
+func newLoginChallenge(t *testing.T, user User) string {
+	t.Helper()
+
+	userStore.mu.Lock()
+	defer userStore.mu.Unlock()
+	challenge, err := userStore.createLoginChallenge(user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func TestTOTPLoginRejectsReplay(t *testing.T) {
+	setupTestStores(t)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+	now := time.Unix(1700000000, 0)
+	setTOTPNow(t, now)
+	secret, _ := enrollTOTP(t, user.ID, now)
+	key, _ := totpEncoding.DecodeString(secret)
+
+	// 确认绑定时使用的验证码不能再用来登录
+	code := totpCode(key, totpCounter(now))
+	if _, err := userStore.CompleteLogin(newLoginChallenge(t, user), code); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("reusing the enrollment code: err = %v, want ErrInvalidCode", err)
+	}
+
+	next := now.Add(TOTP_PERIOD * time.Second)
+	setTOTPNow(t, next)
+	code = totpCode(key, totpCounter(next))
+	if _, err := userStore.CompleteLogin(newLoginChallenge(t, user), code); err != nil {
+		t.Fatalf("login with a fresh code: %v", err)
+	}
+	if _, err := userStore.CompleteLogin(newLoginChallenge(t, user), code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("second login with the same code: err = %v, want ErrInvalidCode", err)
+	}
+}
+
+// 每个恢复码只能使用一次，输入时忽略大小写和分隔符
+func TestRecoveryCodesSingleUse(t *testing.T) {
+	setupTestStores(t)
+	user := registerTestUser(t, "alice", ROLE_MEMBER)
+	now := time.Unix(1700000000, 0)
+	setTOTPNow(t, now)
+	_, codes := enrollTOTP(t, user.ID, now)
+	if len(codes) != RECOVERY_CODE_COUNT {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), RECOVERY_CODE_COUNT)
+	}
+
+	if _, err := userStore.CompleteLogin(newLoginChallenge(t, user), codes[0]); err != nil {
+		t.Fatalf("login with a recovery code: %v", err)
+	}
+	if _, err := userStore.CompleteLogin(newLoginChallenge(t, user), codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("reusing a recovery code: err = %v, want ErrInvalidCode", err)
+	}
+
+	formatted := strings.ToUpper(strings.ReplaceAll(codes[1], "-", ""))
+	if _, err := userStore.CompleteLogin(newLoginChallenge(t, user), formatted); err != nil {
+		t.Fatalf("login with a reformatted recovery code: %v", err)
+	}
+
+	stored, err := userStore.GetUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.RecoveryCodes) != RECOVERY_CODE_COUNT-2 {
+		t.Errorf("%d recovery codes left, want %d", len(stored.RecoveryCodes), RECOVERY_CODE_COUNT-2)
+	}
+
+	// 重新生成后旧的恢复码全部作废
+	newCodes, err := userStore.RegenerateRecoveryCodes(user.ID, codes[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userStore.CompleteLogin(newLoginChallenge(t, user), codes[3]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("old recovery code after regeneration: err = %v, want ErrInvalidCode", err)
+	}
+	if _, err := userStore.CompleteLogin(newLoginChallenge(t, user), newCodes[0]); err != nil {
+		t.Errorf("login with a regenerated recovery code: %v", err)
+	}
+}